@@ -0,0 +1,502 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Saied74/labjack/pkg/jack"
+)
+
+//Commands file holds one function per u3ctl command, see main.go for the list.
+
+type deviceInfo struct {
+	Number int
+	jack.Config
+	HV bool
+}
+
+func (d *device) info() error {
+	c, err := d.config()
+	if err != nil {
+		return err
+	}
+	emit(deviceInfo{d.num, c, c.HV()}, fmt.Sprintf(
+		"device:      %d\nname:        %s\nserial:      %s\nfirmware:    %s\nbootloader:  %s\nhardware:    %s\nproduct id:  %s\nlocal id:    %s\n",
+		d.num, c.DeviceName, c.SerialNumber, c.FirmwareVersion, c.BootLoaderVersion,
+		c.HardwareVersion, c.ProductID, c.LocalID))
+	return nil
+}
+
+func devices() error {
+	list := []deviceInfo{}
+	text := ""
+	for n := 1; n <= jack.DevCount(); n++ {
+		c, err := newDevice(n).config()
+		if err != nil {
+			return fmt.Errorf("device %d: %v", n, err)
+		}
+		list = append(list, deviceInfo{n, c, c.HV()})
+		text += fmt.Sprintf("%d  %-6s  serial %s\n", n, c.DeviceName, c.SerialNumber)
+	}
+	if len(list) == 0 {
+		text = "no U3 found\n"
+	}
+	emit(list, text)
+	return nil
+}
+
+type pinSetting struct {
+	Pin       string
+	Type      string //Analog or Digital
+	Direction string `json:",omitempty"` //Input or Output, for digital pins
+}
+
+func (d *device) configGet(args []string) error {
+	p, err := d.pinConfig()
+	if err != nil {
+		return err
+	}
+	list := []pinSetting{}
+	text := ""
+	for ch := 0; ch < 20; ch++ {
+		s := pinSetting{Pin: jack.ChannelName(ch), Type: "Digital", Direction: "Input"}
+		if p.isAnalog(ch) {
+			s.Type, s.Direction = "Analog", ""
+		} else if p.isOutput(ch) {
+			s.Direction = "Output"
+		}
+		list = append(list, s)
+		text += fmt.Sprintf("%-5s %-8s %s\n", s.Pin, s.Type, s.Direction)
+	}
+	emit(list, text)
+	return nil
+}
+
+//configSet changes the named pins and writes both the analog/digital setting
+//and the directions, so the rest of the pins stay as they were.
+func (d *device) configSet(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("config set needs PIN=analog|digital|input|output")
+	}
+	p, err := d.pinConfig()
+	if err != nil {
+		return err
+	}
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("%q is not PIN=SETTING", arg)
+		}
+		ch, err := jack.Channel(kv[0])
+		if err != nil {
+			return err
+		}
+		bit := byte(1 << (ch % 8))
+		switch strings.ToLower(kv[1]) {
+		case "analog", "digital":
+			if ch >= 16 {
+				return fmt.Errorf("%s is digital only", kv[0])
+			}
+			if p.hv && ch < 4 {
+				return fmt.Errorf("%s is analog only on a U3-HV", kv[0])
+			}
+			p.analog[ch/8] &^= bit
+			if strings.ToLower(kv[1]) == "analog" {
+				p.analog[ch/8] |= bit
+			}
+		case "input", "output":
+			p.dir[ch/8] &^= bit
+			if strings.ToLower(kv[1]) == "output" {
+				p.dir[ch/8] |= bit
+			}
+		default:
+			return fmt.Errorf("%q is not a setting, use analog, digital, input or output", kv[1])
+		}
+	}
+	if err := d.writeAnalog(p); err != nil {
+		return err
+	}
+	if err := d.writeDirection(p); err != nil {
+		return err
+	}
+	return d.configGet(nil)
+}
+
+type ainResult struct {
+	Pin     string
+	Channel int
+	Neg     int
+	Raw     float64
+	Volts   float64
+}
+
+func (d *device) ainRead(args []string) error {
+	fs := flag.NewFlagSet("ain read", flag.ContinueOnError)
+	neg := fs.Int("neg", jack.SENeg, "negative channel, 31 for single ended, 30 for Vref, 32 for the LV special range")
+	samples := fs.Int("samples", 1, "number of reads to average")
+	long := fs.Bool("long", true, "long settling, for high source impedance")
+	quick := fs.Bool("quick", false, "quick sample, faster and noisier")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *samples < 1 {
+		return fmt.Errorf("samples must be at least 1")
+	}
+	chans, err := channels(fs.Args())
+	if err != nil {
+		return err
+	}
+	p, err := d.pinConfig()
+	if err != nil {
+		return err
+	}
+	list := []ainResult{}
+	text := ""
+	for _, ch := range chans {
+		if !p.isAnalog(ch) {
+			return fmt.Errorf("%s is not analog, use config set %s=analog", jack.ChannelName(ch), jack.ChannelName(ch))
+		}
+		raw, v, err := d.readAIN(ch, *neg, *samples, *long, *quick)
+		if err != nil {
+			return err
+		}
+		list = append(list, ainResult{jack.ChannelName(ch), ch, *neg, raw, v})
+		text += fmt.Sprintf("%-5s %.4f V\n", jack.ChannelName(ch), v)
+	}
+	emit(list, text)
+	return nil
+}
+
+type dioResult struct {
+	Pin       string
+	Direction string
+	State     int
+}
+
+func (d *device) dioRead(args []string) error {
+	p, err := d.pinConfig()
+	if err != nil {
+		return err
+	}
+	chans := []int{}
+	if len(args) == 0 {
+		for ch := 0; ch < 20; ch++ {
+			if !p.isAnalog(ch) {
+				chans = append(chans, ch)
+			}
+		}
+	} else if chans, err = channels(args); err != nil {
+		return err
+	}
+	ports, err := d.readPorts()
+	if err != nil {
+		return err
+	}
+	list := []dioResult{}
+	text := ""
+	for _, ch := range chans {
+		if p.isAnalog(ch) {
+			return fmt.Errorf("%s is analog, use config set %s=digital", jack.ChannelName(ch), jack.ChannelName(ch))
+		}
+		r := dioResult{Pin: jack.ChannelName(ch), Direction: "Input"}
+		if p.isOutput(ch) {
+			r.Direction = "Output"
+		}
+		if ports[ch/8]&(1<<(ch%8)) != 0 {
+			r.State = 1
+		}
+		list = append(list, r)
+		text += fmt.Sprintf("%-5s %-6s %d\n", r.Pin, r.Direction, r.State)
+	}
+	emit(list, text)
+	return nil
+}
+
+//dioWrite makes the named pins outputs, if they are not already, and sets
+//their state.  The rest of the pins are left alone.
+func (d *device) dioWrite(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("dio write needs PIN=0|1")
+	}
+	p, err := d.pinConfig()
+	if err != nil {
+		return err
+	}
+	sr := d.cmds[jack.PortStateWrite]
+	var mask, state [3]byte
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || (kv[1] != "0" && kv[1] != "1") {
+			return fmt.Errorf("%q is not PIN=0 or PIN=1", arg)
+		}
+		ch, err := jack.Channel(kv[0])
+		if err != nil {
+			return err
+		}
+		if p.isAnalog(ch) {
+			return fmt.Errorf("%s is analog, use config set %s=digital", kv[0], kv[0])
+		}
+		bit := byte(1 << (ch % 8))
+		p.dir[ch/8] |= bit
+		mask[ch/8] |= bit
+		if kv[1] == "1" {
+			state[ch/8] |= bit
+		}
+	}
+	if err := d.writeDirection(p); err != nil {
+		return err
+	}
+	sr.Byte8, sr.Byte9, sr.Byte10 = mask[0], mask[1], mask[2]
+	sr.Byte11, sr.Byte12, sr.Byte13 = state[0], state[1], state[2]
+	if _, err := d.send(jack.PortStateWrite, 0x01); err != nil {
+		return err
+	}
+	return d.dioRead(pinNames(args))
+}
+
+//pinNames returns the pin names of PIN=VALUE arguments.
+func pinNames(args []string) []string {
+	names := make([]string, len(args))
+	for i, arg := range args {
+		names[i] = strings.SplitN(arg, "=", 2)[0]
+	}
+	return names
+}
+
+type dacResult struct {
+	DAC   int
+	Volts float64
+	Value uint16 //16 bit value sent to the device
+}
+
+func (d *device) dacSet(args []string) error {
+	if len(args) != 2 || (args[0] != "0" && args[0] != "1") {
+		return fmt.Errorf("dac set needs 0 or 1 and the volts")
+	}
+	dac, _ := strconv.Atoi(args[0])
+	volts, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a number of volts", args[1])
+	}
+	if err := d.calibrate(); err != nil {
+		return err
+	}
+	value := d.cal.DACValue(dac, volts)
+	d.cmds[jack.DAC].SetDAC(dac, value)
+	if _, err := d.send(jack.DAC, 0x00); err != nil {
+		return err
+	}
+	emit(dacResult{dac, volts, value}, fmt.Sprintf("DAC%d %.3f V\n", dac, volts))
+	return nil
+}
+
+//<++++++++++++++++++++++++++   watch and stream   ++++++++++++++++++++++++++++>
+
+type row struct {
+	Time   time.Time
+	Values map[string]float64
+}
+
+//sampler reads the pins in names every interval and hands each row to out,
+//until count rows (0 for no limit), duration (0 for no limit) or an
+//interrupt.  It returns the number of rows.
+func (d *device) sampler(names []string, interval time.Duration, count int, duration time.Duration,
+	out func(t time.Time, values []float64) error) (int, error) {
+	chans, err := channels(names)
+	if err != nil {
+		return 0, err
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("interval must be over zero")
+	}
+	p, err := d.pinConfig()
+	if err != nil {
+		return 0, err
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	defer signal.Stop(stop)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	var end <-chan time.Time
+	if duration > 0 {
+		end = time.After(duration)
+	}
+	n := 0
+	for count == 0 || n < count {
+		t, values, err := d.sample(p, chans)
+		if err != nil {
+			return n, err
+		}
+		if err := out(t, values); err != nil {
+			return n, err
+		}
+		n++
+		select {
+		case <-tick.C:
+		case <-end:
+			return n, nil
+		case <-stop:
+			return n, nil
+		}
+	}
+	return n, nil
+}
+
+func (d *device) watch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	interval := fs.Duration("interval", time.Second, "time between reads")
+	count := fs.Int("count", 0, "number of reads, 0 for until interrupted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	names := fs.Args()
+	enc := json.NewEncoder(os.Stdout)
+	_, err := d.sampler(names, *interval, *count, 0, func(t time.Time, values []float64) error {
+		if jsonOut {
+			return enc.Encode(newRow(t, names, values))
+		}
+		line := t.Format("15:04:05.000")
+		for i, name := range names {
+			line += fmt.Sprintf("  %s=%s", strings.ToUpper(name), format(values[i]))
+		}
+		fmt.Println(line)
+		return nil
+	})
+	return err
+}
+
+/*
+stream writes the reads to a file, CSV with a header row (time, then one
+column per pin), or one JSON object per line with -json.
+*/
+func (d *device) stream(args []string) error {
+	fs := flag.NewFlagSet("stream", flag.ContinueOnError)
+	file := fs.String("o", "", "file to write to")
+	interval := fs.Duration("interval", 100*time.Millisecond, "time between reads")
+	count := fs.Int("count", 0, "number of reads, 0 for no limit")
+	duration := fs.Duration("duration", 0, "how long to stream, 0 for no limit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("stream needs -o FILE")
+	}
+	names := fs.Args()
+	f, err := os.Create(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var out func(t time.Time, values []float64) error
+	var flush func() error
+	if jsonOut {
+		enc := json.NewEncoder(f)
+		out = func(t time.Time, values []float64) error {
+			return enc.Encode(newRow(t, names, values))
+		}
+		flush = func() error { return nil }
+	} else {
+		w := csv.NewWriter(f)
+		header := []string{"time"}
+		for _, name := range names {
+			header = append(header, strings.ToUpper(name))
+		}
+		w.Write(header)
+		out = func(t time.Time, values []float64) error {
+			rec := []string{t.Format(time.RFC3339Nano)}
+			for _, v := range values {
+				rec = append(rec, format(v))
+			}
+			return w.Write(rec)
+		}
+		flush = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	}
+	fmt.Fprintf(os.Stderr, "streaming %s to %s, interrupt to stop\n", strings.Join(names, ", "), *file)
+	n, err := d.sampler(names, *interval, *count, *duration, out)
+	if ferr := flush(); err == nil {
+		err = ferr
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d rows to %s\n", n, *file)
+	return err
+}
+
+func newRow(t time.Time, names []string, values []float64) row {
+	r := row{Time: t, Values: map[string]float64{}}
+	for i, name := range names {
+		r.Values[strings.ToUpper(name)] = values[i]
+	}
+	return r
+}
+
+func format(v float64) string {
+	return strconv.FormatFloat(v, 'f', 4, 64)
+}
+
+/*
+decode prints the fields of a packet given as bytes (see jack.ParseBytes), a
+response with -rec, split by the IOTypes of -send if it is a Feedback.  With
+-f it decodes both packets of every exchange of a trace file instead.
+*/
+func decode(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	rec := fs.Bool("rec", false, "the packet is a response from the U3")
+	send := fs.String("send", "", "the command a -rec packet answers")
+	file := fs.String("f", "", "trace file to decode")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	frames := []jack.Frame{}
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		entries, err := jack.ReadTrace(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.Device == 0 {
+				continue
+			}
+			frames = append(frames, jack.DecodeSend(e.Send))
+			if len(e.Rec) > 0 {
+				frames = append(frames, jack.DecodeRec(e.Rec, e.Send))
+			}
+		}
+	} else {
+		b, err := jack.ParseBytes(strings.Join(fs.Args(), " "))
+		if err != nil {
+			return err
+		}
+		if !*rec {
+			frames = append(frames, jack.DecodeSend(b))
+		} else {
+			var s []byte
+			if *send != "" {
+				if s, err = jack.ParseBytes(*send); err != nil {
+					return fmt.Errorf("-send: %v", err)
+				}
+			}
+			frames = append(frames, jack.DecodeRec(b, s))
+		}
+	}
+	text := ""
+	for _, f := range frames {
+		text += f.String() + "\n"
+	}
+	emit(frames, text)
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Saied74/labjack/pkg/jack"
+)
+
+//Device file holds what the commands need from the U3, on top of package jack.
+
+type device struct {
+	num  int
+	cmds jack.Commands
+	cfg  *jack.Config //nil until read from the device
+	cal  jack.Calibration
+}
+
+func newDevice(num int) *device {
+	return &device{num: num, cmds: jack.NewCommands(), cal: jack.NewCalibration()}
+}
+
+func (d *device) send(op string, writeMask byte) ([]byte, error) {
+	_, rec, err := jack.SendRec(d.num, d.cmds[op], writeMask)
+	return rec, err
+}
+
+//config reads the ConfigU3 response, once.  The write mask is zero so nothing
+//is written to flash.
+func (d *device) config() (jack.Config, error) {
+	if d.cfg == nil {
+		rec, err := d.send(jack.ConfigJack, 0x00)
+		if err != nil {
+			return jack.Config{}, err
+		}
+		c := jack.ParseConfig(rec)
+		d.cfg = &c
+	}
+	return *d.cfg, nil
+}
+
+//calibrate reads the calibration constants, once.
+func (d *device) calibrate() error {
+	for blk := 0; blk < jack.CalBlocks && !d.cal.Loaded(); blk++ {
+		d.cmds[jack.ReadMem].Byte7 = byte(blk)
+		rec, err := d.send(jack.ReadMem, 0x00)
+		if err != nil {
+			return err
+		}
+		d.cal.ParseBlock(byte(blk), rec)
+	}
+	return nil
+}
+
+/*
+pinConfig is the analog/digital and direction setting of every pin.  analog
+holds the FIOAnalog and EIOAnalog bytes of ConfigIO and dir the FIO, EIO and
+CIO bytes of Port Direction Read, so channel ch (see jack.Channel) is bit ch%8
+of byte ch/8 in both.
+*/
+type pinConfig struct {
+	analog [2]byte
+	dir    [3]byte
+	hv     bool
+}
+
+func (d *device) pinConfig() (pinConfig, error) {
+	p := pinConfig{}
+	c, err := d.config()
+	if err != nil {
+		return p, err
+	}
+	p.hv = c.HV()
+	rec, err := d.send(jack.ConfigIO, 0x00)
+	if err != nil {
+		return p, err
+	}
+	p.analog = [2]byte{rec[10], rec[11]}
+	rec, err = d.send(jack.PortDirRead, 0x00)
+	if err != nil {
+		return p, err
+	}
+	p.dir = jack.PortBits(rec)
+	return p, nil
+}
+
+//on a U3-HV FIO0 through FIO3 are analog only.
+func (p pinConfig) isAnalog(ch int) bool {
+	if p.hv && ch < 4 {
+		return true
+	}
+	return ch < 16 && p.analog[ch/8]&(1<<(ch%8)) != 0
+}
+
+func (p pinConfig) isOutput(ch int) bool {
+	return p.dir[ch/8]&(1<<(ch%8)) != 0
+}
+
+//writes the analog/digital setting to the device volatile memory.
+func (d *device) writeAnalog(p pinConfig) error {
+	d.cmds[jack.ConfigIO].Byte10 = p.analog[0]
+	d.cmds[jack.ConfigIO].Byte11 = p.analog[1]
+	_, err := d.send(jack.ConfigIO, 0x0C) //FIOAnalog and EIOAnalog
+	return err
+}
+
+//writes the direction of all digital pins.
+func (d *device) writeDirection(p pinConfig) error {
+	d.cmds[jack.PortDirWrite].Byte11 = p.dir[0]
+	d.cmds[jack.PortDirWrite].Byte12 = p.dir[1]
+	d.cmds[jack.PortDirWrite].Byte13 = p.dir[2]
+	_, err := d.send(jack.PortDirWrite, 0x01)
+	return err
+}
+
+//readAIN reads channel ch against neg samples times and returns the mean of
+//the raw reads and its voltage.
+func (d *device) readAIN(ch, neg, samples int, long, quick bool) (float64, float64, error) {
+	c, err := d.config()
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := d.calibrate(); err != nil {
+		return 0, 0, err
+	}
+	pos := byte(ch)
+	if long {
+		pos |= 0x40
+	}
+	if quick {
+		pos |= 0x80
+	}
+	sr := d.cmds[jack.AINBatch]
+	sum := 0.0
+	for left := samples; left > 0; left -= jack.MaxAINBatch {
+		n := left
+		if n > jack.MaxAINBatch {
+			n = jack.MaxAINBatch
+		}
+		sr.SetAINBatch(n)
+		sr.Byte8 = pos
+		sr.Byte9 = byte(neg)
+		rec, err := d.send(jack.AINBatch, 0x00)
+		if err != nil {
+			return 0, 0, err
+		}
+		for k := 0; k < n; k++ {
+			sum += float64(jack.AINBits(rec, k))
+		}
+	}
+	raw := sum / float64(samples)
+	v, err := d.cal.AINVoltage(ch, neg, raw, c.HV())
+	return raw, v, err
+}
+
+//readPorts returns the FIO, EIO and CIO state bytes.
+func (d *device) readPorts() ([3]byte, error) {
+	rec, err := d.send(jack.PortStateRead, 0x00)
+	if err != nil {
+		return [3]byte{}, err
+	}
+	return jack.PortBits(rec), nil
+}
+
+//sample reads every channel in chans once, volts for analog pins and 0 or 1
+//for digital pins.
+func (d *device) sample(p pinConfig, chans []int) (time.Time, []float64, error) {
+	t := time.Now()
+	values := make([]float64, len(chans))
+	var ports [3]byte
+	read := false //ports are read once, for the first digital pin
+	for i, ch := range chans {
+		if p.isAnalog(ch) {
+			_, v, err := d.readAIN(ch, jack.SENeg, 1, true, false)
+			if err != nil {
+				return t, nil, err
+			}
+			values[i] = v
+			continue
+		}
+		if !read {
+			var err error
+			if ports, err = d.readPorts(); err != nil {
+				return t, nil, err
+			}
+			read = true
+		}
+		if ports[ch/8]&(1<<(ch%8)) != 0 {
+			values[i] = 1
+		}
+	}
+	return t, values, nil
+}
+
+//channels turns pin names into channel numbers.
+func channels(names []string) ([]int, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("name at least one pin, FIO4 for example")
+	}
+	chans := make([]int, len(names))
+	for i, name := range names {
+		ch, err := jack.Channel(name)
+		if err != nil {
+			return nil, err
+		}
+		chans[i] = ch
+	}
+	return chans, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+/*
+u3ctl drives a LabJack U3 from the command line, for scripts and quick checks
+without the web program.  It is built on the same protocol code (package jack)
+and, like the web program, opens and closes the device for every command so it
+can be used while the web program is running.
+
+	u3ctl [-n device] [-json] [-trace file] [-replay file] command [arguments]
+
+	info                         device name, versions and serial number
+	devices                      all the U3s on the USB bus
+	config get                   analog/digital and direction of every pin
+	config set PIN=SETTING ...   SETTING is analog, digital, input or output
+	ain read [-neg 31] [-samples 1] [-quick] PIN ...
+	dio read [PIN ...]           state and direction of digital pins
+	dio write PIN=0|1 ...        makes the pins outputs and sets them
+	dac set 0|1 VOLTS            sets DAC0 or DAC1
+	watch [-interval 1s] [-count 0] PIN ...
+	stream -o FILE [-interval 100ms] [-count 0] [-duration 0] PIN ...
+	decode [-rec] [-send BYTES] BYTES    fields of a packet, no device needed
+	decode -f TRACE                      fields of every packet of a trace
+
+With -json every command prints JSON, watch and stream one object per line.
+-trace records the raw buffers to a file and -replay plays such a file back
+instead of talking to a device, see trace.go in package jack.
+Analog pins are reported in volts and digital pins as 0 or 1.  stream polls
+the pins with command/response reads, it does not use the U3 stream mode, so
+intervals much under 10ms are not met.  decode takes the bytes as printed by
+the Send Buffer and Rec Buffer lines of the web program ([95 248 2 ...]), as
+0x5f 0xf8 ... or as one run of hex like a trace has them.
+*/
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Saied74/labjack/pkg/jack"
+)
+
+var jsonOut bool
+
+func main() {
+	devNum := flag.Int("n", 1, "device number of the U3 to talk to, starting at 1")
+	flag.BoolVar(&jsonOut, "json", false, "print JSON")
+	traceFile := flag.String("trace", "", "file to record the raw buffers to")
+	replayFile := flag.String("replay", "", "trace to play back instead of talking to a device")
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	closeTrace, err := jack.UseTrace(*traceFile, *replayFile, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "u3ctl:", err)
+		os.Exit(1)
+	}
+	d := newDevice(*devNum)
+	switch args[0] {
+	case "info":
+		err = d.info()
+	case "devices":
+		err = devices()
+	case "config":
+		err = sub(args, map[string]func([]string) error{"get": d.configGet, "set": d.configSet})
+	case "ain":
+		err = sub(args, map[string]func([]string) error{"read": d.ainRead})
+	case "dio":
+		err = sub(args, map[string]func([]string) error{"read": d.dioRead, "write": d.dioWrite})
+	case "dac":
+		err = sub(args, map[string]func([]string) error{"set": d.dacSet})
+	case "watch":
+		err = d.watch(args[1:])
+	case "stream":
+		err = d.stream(args[1:])
+	case "decode":
+		err = decode(args[1:])
+	default:
+		err = fmt.Errorf("%q is not a command", args[0])
+	}
+	if cerr := closeTrace(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "u3ctl:", err)
+		os.Exit(1)
+	}
+}
+
+//sub runs the subcommand in args[1] of the command in args[0].
+func sub(args []string, cmds map[string]func([]string) error) error {
+	names := []string{}
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(args) < 2 || cmds[args[1]] == nil {
+		return fmt.Errorf("%s needs one of: %s", args[0], strings.Join(names, ", "))
+	}
+	return cmds[args[1]](args[2:])
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: u3ctl [-n device] [-json] [-trace file] [-replay file] command [arguments]
+
+commands:
+  info
+  devices
+  config get
+  config set PIN=analog|digital|input|output ...
+  ain read [-neg 31] [-samples 1] [-quick] PIN ...
+  dio read [PIN ...]
+  dio write PIN=0|1 ...
+  dac set 0|1 VOLTS
+  watch [-interval 1s] [-count 0] PIN ...
+  stream -o FILE [-interval 100ms] [-count 0] [-duration 0] PIN ...
+  decode [-rec] [-send BYTES] BYTES
+  decode -f TRACE
+
+flags:`)
+	flag.PrintDefaults()
+}
+
+//emit prints v as JSON with -json and text otherwise.
+func emit(v interface{}, text string) {
+	if jsonOut {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "u3ctl:", err)
+			return
+		}
+		fmt.Println(string(b))
+		return
+	}
+	fmt.Print(text)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"time"
+)
+
+/*
+Alarm file holds the alarm rules evaluated after every acquisition, whether it
+came from the measurement page or the background poll (see -poll in main.go).  Analog
+rules compare the pin Value (engineering units, volts when the pin has no
+scale), digital rules the pin state (DigitalRead for inputs, DigitalWrite for
+outputs).  The kinds are:
+
+Above:   raised when the value goes over High, cleared below High - Hysteresis.
+Below:   raised when the value goes under Low, cleared over Low + Hysteresis.
+Outside: raised outside Low to High, cleared back inside by Hysteresis.
+Edge:    raised on a Rising, Falling or Both edge of a digital pin.  Since an
+         edge has no end, it stays raised until acknowledged.
+Level:   raised while a digital pin is at Level (0 or 1).
+Stuck:   raised when the value has not moved more than Hysteresis for
+         StuckSeconds, cleared when it moves.
+
+Every raise and clear is an AlarmEvent.  Events are kept in app.u3.Events for
+the pages (the last maxEvents of them) and handed to the notifiers, see
+notify.go.
+*/
+
+const maxEvents = 500
+
+var alarmKinds = []string{"Above", "Below", "Outside", "Edge", "Level", "Stuck"}
+
+type AlarmRule struct {
+	ID           int
+	Pin          string //pin label, FIO4 for example
+	Kind         string
+	Low          float64
+	High         float64
+	Hysteresis   float64
+	Edge         string //Rising, Falling or Both, for Edge
+	Level        int    //0 or 1, for Level
+	StuckSeconds float64
+	Active       bool
+	Since        time.Time //time of the last raise or clear
+	Value        float64   //value at the last evaluation
+	started      bool      //false until the first evaluation
+	last         float64   //value at the last evaluation, for edges
+	stuckAt      float64   //value the Stuck timer started at
+	stuckSince   time.Time
+}
+
+type AlarmEvent struct {
+	Time    time.Time
+	RuleID  int
+	Pin     string
+	Name    string
+	Kind    string
+	State   string //Raised, Cleared or Acknowledged
+	Value   float64
+	Message string
+}
+
+//check makes sure the rule names a pin and has what its kind needs.
+func (a *AlarmRule) check(u *U3) error {
+	if _, _, err := u.pinByName(a.Pin); err != nil {
+		return err
+	}
+	//a NaN compares false with anything, an alarm on one would never fire
+	for _, n := range []struct {
+		name string
+		v    float64
+	}{{"low", a.Low}, {"high", a.High}, {"hysteresis", a.Hysteresis}, {"stuck seconds", a.StuckSeconds}} {
+		if math.IsNaN(n.v) || math.IsInf(n.v, 0) {
+			return fmt.Errorf("%s is %g, it must be a number", n.name, n.v)
+		}
+	}
+	switch a.Kind {
+	case "Above", "Below":
+	case "Outside":
+		if a.Low >= a.High {
+			return fmt.Errorf("Low must be under High")
+		}
+	case "Edge":
+		if a.Edge != "Rising" && a.Edge != "Falling" && a.Edge != "Both" {
+			return fmt.Errorf("edge must be Rising, Falling or Both")
+		}
+	case "Level":
+		if a.Level != 0 && a.Level != 1 {
+			return fmt.Errorf("level must be 0 or 1")
+		}
+	case "Stuck":
+		if a.StuckSeconds <= 0 {
+			return fmt.Errorf("stuck seconds must be over zero")
+		}
+	default:
+		return fmt.Errorf("%q is not an alarm, use one of %v", a.Kind, alarmKinds)
+	}
+	if a.Hysteresis < 0 {
+		return fmt.Errorf("hysteresis can not be negative")
+	}
+	return nil
+}
+
+//pinValue is the value the alarm rules and control rules look at, see above.
+//ok is false when the pin is not in use, could not be read or its scale gave
+//no number, see Pin.ReadFailed and Pin.OutOfRange.
+func (p *Pin) pinValue() (v float64, analog bool, ok bool) {
+	switch {
+	case p.AD == "Analog" && p.AnalogVoltage != "":
+		return p.Value, true, !p.OutOfRange && !p.ReadFailed
+	case p.AD == "Digital" && p.IO == "Input":
+		return float64(p.DigitalRead), false, true
+	case p.AD == "Digital" && p.IO == "Output":
+		return float64(p.DigitalWrite), false, true
+	}
+	return 0, false, false
+}
+
+//evaluate updates the rule with value v at time t and returns the new state
+//and true when it changed.
+func (a *AlarmRule) evaluate(v float64, t time.Time) (bool, bool) {
+	active := a.Active
+	switch a.Kind {
+	case "Above":
+		if v > a.High {
+			active = true
+		} else if v < a.High-a.Hysteresis {
+			active = false
+		}
+	case "Below":
+		if v < a.Low {
+			active = true
+		} else if v > a.Low+a.Hysteresis {
+			active = false
+		}
+	case "Outside":
+		if v < a.Low || v > a.High {
+			active = true
+		} else if v >= a.Low+a.Hysteresis && v <= a.High-a.Hysteresis {
+			active = false
+		}
+	case "Edge":
+		rising := a.started && a.last < 0.5 && v >= 0.5
+		falling := a.started && a.last >= 0.5 && v < 0.5
+		if (rising && a.Edge != "Falling") || (falling && a.Edge != "Rising") {
+			active = true
+		}
+	case "Level":
+		active = int(v+0.5) == a.Level
+	case "Stuck":
+		if !a.started || v > a.stuckAt+a.Hysteresis || v < a.stuckAt-a.Hysteresis {
+			a.stuckAt = v
+			a.stuckSince = t
+			active = false
+		} else if t.Sub(a.stuckSince).Seconds() >= a.StuckSeconds {
+			active = true
+		}
+	}
+	a.started = true
+	a.last = v
+	a.Value = v
+	if active == a.Active {
+		return active, false
+	}
+	a.Active = active
+	a.Since = t
+	return active, true
+}
+
+//describes the rule for the pages and the notifications.
+func (a *AlarmRule) String() string {
+	switch a.Kind {
+	case "Above":
+		return fmt.Sprintf("%s above %g", a.Pin, a.High)
+	case "Below":
+		return fmt.Sprintf("%s below %g", a.Pin, a.Low)
+	case "Outside":
+		return fmt.Sprintf("%s outside %g to %g", a.Pin, a.Low, a.High)
+	case "Edge":
+		return fmt.Sprintf("%s %s edge", a.Pin, a.Edge)
+	case "Level":
+		return fmt.Sprintf("%s at %d", a.Pin, a.Level)
+	case "Stuck":
+		return fmt.Sprintf("%s stuck for %gs", a.Pin, a.StuckSeconds)
+	}
+	return a.Kind
+}
+
+//evaluateAlarms runs every rule against the last acquisition and records and
+//notifies the changes.  Must be called with app.mu held.
+func (app *application) evaluateAlarms(t time.Time) {
+	for _, a := range app.u3.Alarms {
+		pin, _, err := app.u3.pinByName(a.Pin)
+		if err != nil {
+			continue
+		}
+		v, _, ok := pin.pinValue()
+		if !ok {
+			continue
+		}
+		state, changed := a.evaluate(v, t)
+		if changed {
+			e := AlarmEvent{Time: t, RuleID: a.ID, Pin: a.Pin, Name: pin.DisplayName(),
+				Kind: a.Kind, State: "Cleared", Value: v}
+			if state {
+				e.State = "Raised"
+			}
+			e.Message = fmt.Sprintf("%s %s: %s (value %.4g)", e.Name, e.State, a, v)
+			app.logEvent(e)
+		}
+	}
+	app.countAlarms()
+}
+
+func (app *application) countAlarms() {
+	app.u3.ActiveAlarms = 0
+	for _, a := range app.u3.Alarms {
+		if a.Active {
+			app.u3.ActiveAlarms++
+		}
+	}
+}
+
+//adds an event to the list shown on the pages and hands it to the notifiers.
+func (app *application) logEvent(e AlarmEvent) {
+	app.u3.Events = append(app.u3.Events, e)
+	if len(app.u3.Events) > maxEvents {
+		app.u3.Events = app.u3.Events[len(app.u3.Events)-maxEvents:]
+	}
+	app.notify(e)
+}
+
+//addAlarm checks the rule and adds it with the next free ID.
+func (app *application) addAlarm(a *AlarmRule) error {
+	if err := a.check(app.u3); err != nil {
+		return err
+	}
+	a.ID = 1
+	for _, b := range app.u3.Alarms {
+		if b.ID >= a.ID {
+			a.ID = b.ID + 1
+		}
+	}
+	a.Active = false
+	a.started = false
+	app.u3.Alarms = append(app.u3.Alarms, a)
+	return app.saveAlarms()
+}
+
+func (app *application) deleteAlarm(id int) error {
+	for i, a := range app.u3.Alarms {
+		if a.ID == id {
+			app.u3.Alarms = append(app.u3.Alarms[:i], app.u3.Alarms[i+1:]...)
+			app.countAlarms()
+			return app.saveAlarms()
+		}
+	}
+	return fmt.Errorf("there is no alarm %d", id)
+}
+
+//acknowledging clears an alarm until it is raised again.  Only Edge alarms
+//need it, the rest clear by themselves when the value comes back.
+func (app *application) ackAlarm(id int) error {
+	for _, a := range app.u3.Alarms {
+		if a.ID == id {
+			if a.Active {
+				a.Active = false
+				a.Since = time.Now()
+				app.logEvent(AlarmEvent{Time: a.Since, RuleID: a.ID, Pin: a.Pin, Kind: a.Kind,
+					State: "Acknowledged", Value: a.Value, Message: fmt.Sprintf("%s acknowledged", a)})
+				app.countAlarms()
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("there is no alarm %d", id)
+}
+
+//the rules are kept in app.alarmFile (when given) so they survive a restart.
+func (app *application) loadAlarms() error {
+	if app.alarmFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(app.alarmFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rules := []*AlarmRule{}
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return fmt.Errorf("%s: %v", app.alarmFile, err)
+	}
+	for _, a := range rules {
+		if err := a.check(app.u3); err != nil {
+			return fmt.Errorf("%s: alarm %d: %v", app.alarmFile, a.ID, err)
+		}
+		a.Active = false
+	}
+	app.u3.Alarms = rules
+	return nil
+}
+
+func (app *application) saveAlarms() error {
+	if app.alarmFile == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(app.u3.Alarms, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(app.alarmFile, b, 0644)
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+The api file holds the JSON versions of the pages, for driving the U3 from
+scripts rather than the browser.  They work on the same app.u3 model as the
+pages do, so a change made here shows up on the pages and the other way around.
+*/
+
+//pinSettings holds the per pin settings that can be changed through the api.
+//Only the fields present in the request body are changed.
+type pinSettings struct {
+	Name         *string
+	Unit         *string
+	Scale        *Scale
+	NegChannel   *int
+	LongSettling *bool
+	QuickSample  *bool
+	Samples      *int
+	Filter       *string
+	EMAWeight    *float64
+}
+
+//returns the whole U3 model as JSON
+func (app *application) apiU3(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	app.writeJSON(w, app.u3)
+}
+
+/*
+apiPin returns the pin named in the "pin" query parameter (for example
+/api/pin?pin=FIO4) as JSON.  A POST with a pinSettings JSON body changes the
+settings first, for example {"LongSettling": false, "QuickSample": true}
+*/
+func (app *application) apiPin(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	pin, _, err := app.u3.pinByName(r.URL.Query().Get("pin"))
+	if err != nil {
+		app.notFound(w)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var s pinSettings
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		if err := s.apply(app.u3, pin); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		app.clientError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	app.writeJSON(w, pin)
+}
+
+//copies the settings present in s into pin.
+func (s *pinSettings) apply(u *U3, pin *Pin) error {
+	if s.Scale != nil {
+		if err := s.Scale.check(); err != nil {
+			return err
+		}
+		pin.Scale = *s.Scale
+	}
+	if s.Name != nil {
+		pin.Name = *s.Name
+	}
+	if s.Unit != nil {
+		pin.Unit = *s.Unit
+	}
+	if s.NegChannel != nil {
+		if err := u.setNeg(pin, *s.NegChannel); err != nil {
+			return err
+		}
+	}
+	if s.LongSettling != nil {
+		pin.LongSettling = *s.LongSettling
+	}
+	if s.QuickSample != nil {
+		pin.QuickSample = *s.QuickSample
+	}
+	if s.Samples != nil {
+		if err := pin.setSamples(*s.Samples); err != nil {
+			return err
+		}
+	}
+	if s.Filter != nil {
+		if err := pin.setFilter(*s.Filter); err != nil {
+			return err
+		}
+	}
+	if s.EMAWeight != nil {
+		if err := pin.setEMAWeight(*s.EMAWeight); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//bitSettings is the body of a POST to /api/bit.  Only the fields present are
+//written, Direction first.
+type bitSettings struct {
+	Direction *string //Input or Output
+	State     *int    //0 or 1, makes the pin an output
+	Toggle    bool    //sets State to the opposite of the last one written
+}
+
+/*
+apiBit reads the direction and state of the digital pin named in the "pin"
+query parameter from the device (for example /api/bit?pin=EIO3) and returns
+them as JSON.  A POST with a bitSettings JSON body writes them first, for
+example {"State": 1} or {"Toggle": true}.  Only that pin is read or written,
+with the bit commands, the other pins are left alone.
+*/
+func (app *application) apiBit(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	pin, ch, err := app.u3.pinByName(r.URL.Query().Get("pin"))
+	if err != nil {
+		app.notFound(w)
+		return
+	}
+	if pin.AD != "Digital" {
+		http.Error(w, pin.Label+" is not a digital pin", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var s bitSettings
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		if s.Toggle {
+			level := 1 - pin.DigitalWrite
+			s.State = &level
+		}
+		if s.State != nil && *s.State != 0 && *s.State != 1 {
+			http.Error(w, "State must be 0 or 1", http.StatusBadRequest)
+			return
+		}
+		if s.Direction != nil {
+			if err := app.writeBitDir(ch, *s.Direction); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if s.State != nil {
+			if err := app.writeBit(ch, *s.State); err != nil {
+				app.serverError(w, err)
+				return
+			}
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		app.clientError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if err := app.readBit(ch); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.writeJSON(w, struct {
+		Pin, Direction string
+		State, Written int
+	}{pin.Label, pin.IO, pin.DigitalRead, pin.DigitalWrite})
+}
+
+/*
+apiAlarms returns the alarm rules and the event log as JSON.  A POST with an
+AlarmRule JSON body adds a rule, for example
+{"Pin": "FIO4", "Kind": "Above", "High": 2.5, "Hysteresis": 0.1}
+and a DELETE with an "id" query parameter removes one.
+*/
+func (app *application) apiAlarms(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		a := &AlarmRule{}
+		if err := json.NewDecoder(r.Body).Decode(a); err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		if err := app.addAlarm(a); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		if err := app.deleteAlarm(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		app.clientError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	app.writeJSON(w, struct {
+		Alarms       []*AlarmRule
+		Events       []AlarmEvent
+		ActiveAlarms int
+	}{app.u3.Alarms, app.u3.Events, app.u3.ActiveAlarms})
+}
+
+/*
+apiEdges returns the edge settings and counts of the pins the edges page shows
+and the edges seen as JSON.  A POST with an EdgeSettings JSON body sets a pin,
+for example {"Pin": "EIO3", "Edge": "Rising", "Debounce": 50}
+*/
+func (app *application) apiEdges(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var s EdgeSettings
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		if err := app.u3.setEdge(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		app.clientError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	type edgePin struct {
+		Pin, Name, Edge string
+		Debounce        float64
+		Level           int
+		Rising, Falling int
+		LastEdge        time.Time
+	}
+	pins := []edgePin{}
+	for _, p := range app.u3.EdgePins() {
+		pins = append(pins, edgePin{p.Label, p.Name, p.Edge, p.Debounce, p.DigitalRead,
+			p.Rising, p.Falling, p.LastEdge})
+	}
+	app.writeJSON(w, struct {
+		Pins   []edgePin
+		Events []EdgeEvent
+	}{pins, app.u3.EdgeEvents})
+}
+
+//resets the edge counts of the pin in the "pin" query parameter, or of all
+//of them without one, with a POST.
+func (app *application) apiResetEdges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		app.clientError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	if err := app.u3.resetEdgeCounts(r.URL.Query().Get("pin")); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//acknowledges the alarm in the "id" query parameter, with a POST.
+func (app *application) apiAckAlarm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		app.clientError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	if err := app.ackAlarm(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+apiRules returns the interlock rules and the changes they made as JSON.  A POST
+adds a rule, either in its text form {"Rule": "if FIO4 > 2.5 then EIO0 = 0"}
+or as a ControlRule {"Input": "FIO4", "Kind": "Above", "Threshold": 2.5,
+"Output": "EIO0", "Level": 0}.  A DELETE with an "id" query parameter removes
+one.
+*/
+func (app *application) apiRules(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		body := struct {
+			ControlRule
+			Rule string
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		c := &body.ControlRule
+		if body.Rule != "" {
+			var err error
+			if c, err = parseControlRule(body.Rule); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := app.addRule(c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		if err := app.deleteRule(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		app.clientError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	app.writeJSON(w, struct {
+		Rules         []*ControlRule
+		ControlEvents []ControlEvent
+	}{app.u3.Rules, app.u3.ControlEvents})
+}
+
+/*
+apiPatterns returns the output patterns, the last plays and the one playing as
+JSON.  A POST adds a pattern in its text form {"Pattern": "pulse EIO0 500us"}
+and a DELETE with an "id" query parameter removes one.
+*/
+func (app *application) apiPatterns(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		body := struct{ Pattern string }{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		p, err := parsePattern(body.Pattern)
+		if err == nil {
+			err = app.addPattern(p)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		if err := app.deletePattern(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		app.clientError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	app.writeJSON(w, struct {
+		Patterns    []*Pattern
+		PatternRuns []*PatternRun
+		Playing     *PatternRun
+	}{app.u3.Patterns, app.u3.PatternRuns, app.u3.Playing})
+}
+
+//plays the pattern in the "id" query parameter with a POST and returns its
+//run.  A pattern played in one packet is over by then, one played by the host
+//is still playing, see Playing on /api/patterns.
+func (app *application) apiPlayPattern(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		app.clientError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	run, err := app.playPattern(origin(r), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	app.writeJSON(w, run)
+}
+
+//stops the pattern the host is playing, with a POST.
+func (app *application) apiStopPattern(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		app.clientError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	if err := app.stopPattern(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+apiWaves returns the waveform playing or last played on each DAC as JSON, with
+the rate achieved so far.  A POST with a Waveform JSON body starts one, for
+example {"DAC": 0, "Shape": "Sine", "Amplitude": 1, "Offset": 2.5,
+"Frequency": 2, "Rate": 100}.  The points of an Arbitrary one go in Points or,
+as the text of a CSV file, in CSV.
+*/
+func (app *application) apiWaves(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		body := struct {
+			Waveform
+			CSV string
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		var err error
+		if body.CSV != "" {
+			body.Points, err = parseWaveCSV(strings.NewReader(body.CSV))
+		}
+		if err == nil {
+			_, err = app.playWave(origin(r), body.Waveform)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		app.clientError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	app.writeJSON(w, app.u3.Waves)
+}
+
+//stops the waveform on the DAC in the "dac" query parameter, with a POST.
+func (app *application) apiStopWave(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		app.clientError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	dac, err := strconv.Atoi(r.URL.Query().Get("dac"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	if err := app.stopWave(dac); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//apiSequences returns the names of the saved sequences and the last runs as
+//JSON, or only the run in the "id" query parameter when there is one.
+func (app *application) apiSequences(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	if id := r.URL.Query().Get("id"); id != "" {
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		run := app.findRun(n)
+		if run == nil {
+			app.notFound(w)
+			return
+		}
+		app.writeJSON(w, run)
+		return
+	}
+	names, err := app.listSequences()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.writeJSON(w, struct {
+		Sequences []string
+		Runs      []*SequenceRun
+	}{names, app.u3.Runs})
+}
+
+/*
+apiRunSequence runs a sequence with a POST and returns its run as JSON once it
+is over.  The body is the text of the sequence; when it is empty the sequence
+saved under the "name" query parameter is run.  Check Passed for the result.
+*/
+func (app *application) apiRunSequence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		app.clientError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	name, text := r.URL.Query().Get("name"), string(b)
+	if name == "" {
+		name = "unnamed"
+	}
+	if strings.TrimSpace(text) == "" {
+		app.lock(origin(r))
+		text, err = app.loadSequence(name)
+		app.unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+	run, err := app.runSequence(origin(r), name, text)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.writeJSON(w, run)
+}
+
+func (app *application) writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+//returns the transactions picked by the query parameters, see parseTxFilter.
+func (app *application) apiTransactions(w http.ResponseWriter, r *http.Request) {
+	f, err := parseTxFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.writeJSON(w, app.queryTransactions(f))
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Auth file holds the user accounts, the login sessions and the checks every
+request goes through.  It is off unless the -users file is given, so the
+program still works as the single user demo it started as.
+
+Each line of the users file is name:role:hash, see hashPassword.  Users are
+added on the users page or, for the first admin, with
+
+	web -users users.txt -adduser alice:admin
+
+which reads the password from standard input.  The roles are
+
+	viewer    looks at every page and reads the api
+	operator  also changes pins, settings, alarms, rules and sequences
+	admin     also manages the users and writes the device flash
+
+No page writes the device flash yet, ConfigU3 is always sent with a zero write
+mask.  When one does it goes behind roleAdmin.
+
+Pages log in with a form and a session cookie, and every change made with the
+cookie has to carry the session's CSRF token, in the csrf_token form field or
+the X-CSRF-Token header.  Scripts can use HTTP basic authentication instead,
+which needs no token.  Every change, login and logout is written to the audit
+log, a change with the form or api body it sent, passwords left out.
+*/
+
+//Role is what a user is allowed to do, each role can do everything the ones
+//before it can.
+type Role int
+
+const (
+	roleNone Role = iota
+	roleViewer
+	roleOperator
+	roleAdmin
+)
+
+var roleNames = []string{"none", "viewer", "operator", "admin"}
+
+func (r Role) String() string {
+	if r < 0 || int(r) >= len(roleNames) {
+		return "none"
+	}
+	return roleNames[r]
+}
+
+func parseRole(s string) (Role, error) {
+	for i, name := range roleNames {
+		if i > 0 && name == s {
+			return Role(i), nil
+		}
+	}
+	return roleNone, fmt.Errorf("%q is not a role, use viewer, operator or admin", s)
+}
+
+//User is one account, the hash is never shown on a page.
+type User struct {
+	Name string
+	Role Role
+	hash string
+}
+
+//Session is the logged in user of a request.  It is put on the templateData
+//the page is rendered from, see renderData.
+type Session struct {
+	User      string
+	Role      Role
+	CSRFToken string
+	expires   time.Time
+	basic     bool //basic authentication, no cookie and no CSRF token
+}
+
+//Admin is for the templates.
+func (s *Session) Admin() bool {
+	return s.Role >= roleAdmin
+}
+
+const (
+	sessionCookie = "session"
+	hashIters     = 100000
+	minPassword   = 8
+	maxAuditBody  = 1000 //bytes of an api body written to the audit log
+)
+
+var userNameRE = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+//userStore holds the accounts and the sessions.  It has its own lock so
+//logging in does not wait on the device.
+type userStore struct {
+	mu       sync.Mutex
+	path     string
+	users    map[string]*User
+	sessions map[string]*Session
+	lifetime time.Duration
+}
+
+func newUserStore(path string, lifetime time.Duration) *userStore {
+	return &userStore{path: path, users: map[string]*User{},
+		sessions: map[string]*Session{}, lifetime: lifetime}
+}
+
+//load reads the users file, a missing file is no users.
+func (s *userStore) load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.SplitN(line, ":", 3)
+		if len(f) != 3 {
+			return fmt.Errorf("%s line %d: expected name:role:hash", s.path, n)
+		}
+		role, err := parseRole(f[1])
+		if err != nil {
+			return fmt.Errorf("%s line %d: %v", s.path, n, err)
+		}
+		s.users[f[0]] = &User{Name: f[0], Role: role, hash: f[2]}
+	}
+	return scanner.Err()
+}
+
+//must be called with s.mu held.
+func (s *userStore) save() error {
+	lines := []string{}
+	for _, u := range s.users {
+		lines = append(lines, u.Name+":"+u.Role.String()+":"+u.hash)
+	}
+	sort.Strings(lines)
+	return ioutil.WriteFile(s.path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+//list returns the users sorted by name.
+func (s *userStore) list() []*User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := []*User{}
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
+//setUser adds the user or changes its role and password.
+func (s *userStore) setUser(name, role, password string) error {
+	if !userNameRE.MatchString(name) {
+		return fmt.Errorf("%q is not a user name, use letters, digits, _ . and -", name)
+	}
+	r, err := parseRole(role)
+	if err != nil {
+		return err
+	}
+	if len(password) < minPassword {
+		return fmt.Errorf("the password needs at least %d characters", minPassword)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[name] = &User{Name: name, Role: r, hash: hash}
+	return s.save()
+}
+
+//deleteUser removes the user and ends its sessions.
+func (s *userStore) deleteUser(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.users[name] == nil {
+		return fmt.Errorf("there is no user %q", name)
+	}
+	delete(s.users, name)
+	for token, sess := range s.sessions {
+		if sess.User == name {
+			delete(s.sessions, token)
+		}
+	}
+	return s.save()
+}
+
+//check returns the user if the password is right.
+func (s *userStore) check(name, password string) *User {
+	s.mu.Lock()
+	u := s.users[name]
+	s.mu.Unlock()
+	if u == nil || !checkPassword(u.hash, password) {
+		return nil
+	}
+	return u
+}
+
+//login starts a session for u and returns its cookie token.
+func (s *userStore) login(u *User) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for t, sess := range s.sessions {
+		if now.After(sess.expires) {
+			delete(s.sessions, t)
+		}
+	}
+	s.sessions[token] = &Session{User: u.Name, Role: u.Role, CSRFToken: csrf,
+		expires: now.Add(s.lifetime)}
+	return token, nil
+}
+
+func (s *userStore) logout(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
+
+//session returns the session of the request, from the cookie or from basic
+//authentication, or nil.
+func (s *userStore) session(r *http.Request) *Session {
+	if name, password, ok := r.BasicAuth(); ok {
+		u := s.check(name, password)
+		if u == nil {
+			return nil
+		}
+		return &Session{User: u.Name, Role: u.Role, basic: true}
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[c.Value]
+	if sess == nil {
+		return nil
+	}
+	if time.Now().After(sess.expires) {
+		delete(s.sessions, c.Value)
+		return nil
+	}
+	return sess
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//hashPassword returns pbkdf2-sha256$iterations$salt$key with the salt and key
+//base64 encoded.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, hashIters, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", hashIters,
+		enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+func checkPassword(hash, password string) bool {
+	f := strings.Split(hash, "$")
+	if len(f) != 4 || f[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iters, err := strconv.Atoi(f[1])
+	if err != nil || iters < 1 {
+		return false
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(f[2])
+	if err != nil {
+		return false
+	}
+	key, err := enc.DecodeString(f[3])
+	if err != nil {
+		return false
+	}
+	want, err := pbkdf2.Key(sha256.New, password, salt, iters, len(key))
+	return err == nil && hmac.Equal(key, want)
+}
+
+//<++++++++++++++++++++++++++   request checks   +++++++++++++++++++++++++++>
+
+type contextKey string
+
+const sessionKey = contextKey("session")
+
+//sessionOf returns the session require put on the request, nil when the
+//users are off.
+func sessionOf(r *http.Request) *Session {
+	s, _ := r.Context().Value(sessionKey).(*Session)
+	return s
+}
+
+//who names the user of the request for the logs.
+func who(r *http.Request) string {
+	if s := sessionOf(r); s != nil {
+		return s.User
+	}
+	return r.RemoteAddr
+}
+
+/*
+require lets the request through to h if its user has role.  Anything but GET
+and HEAD needs at least roleOperator, and with a session cookie the CSRF token
+too.  Pages that are not logged in are sent to the login page, the api gets a
+401.
+*/
+func (app *application) require(role Role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.users == nil {
+			h(w, r)
+			return
+		}
+		//need is per request, role is shared by every request to the route
+		need := role
+		changes := r.Method != http.MethodGet && r.Method != http.MethodHead
+		if changes && need < roleOperator {
+			need = roleOperator
+		}
+		sess := app.users.session(r)
+		if sess == nil {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				app.clientError(w, http.StatusUnauthorized)
+				return
+			}
+			next := "/home"
+			if r.Method == http.MethodGet {
+				next = r.URL.RequestURI()
+			}
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(next), http.StatusSeeOther)
+			return
+		}
+		if sess.Role < need {
+			app.audit.Printf("%s (%s) denied %s %s", sess.User, sess.Role, r.Method, r.URL.Path)
+			app.clientError(w, http.StatusForbidden)
+			return
+		}
+		if changes && !sess.basic {
+			token := r.Header.Get("X-CSRF-Token")
+			if token == "" {
+				token = r.PostFormValue("csrf_token")
+			}
+			if !hmac.Equal([]byte(token), []byte(sess.CSRFToken)) {
+				app.audit.Printf("%s (%s) bad CSRF token %s %s", sess.User, sess.Role, r.Method, r.URL.Path)
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+		}
+		if changes {
+			app.audit.Printf("%s (%s) %s %s %s", sess.User, sess.Role, r.Method, r.URL.RequestURI(), changeText(r))
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), sessionKey, sess)))
+	}
+}
+
+//postOnly is for the handlers of the page forms, which read r.PostForm and so
+//would act on an empty form if they were sent a GET.
+func postOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+//changeText is what a change sent for the audit log, the form of the pages or
+//the body of the api.  A file upload is left out.
+func changeText(r *http.Request) string {
+	kind, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch kind {
+	case "application/x-www-form-urlencoded":
+		r.ParseForm()
+		return formText(r)
+	case "multipart/form-data":
+		return ""
+	}
+	return bodyText(r)
+}
+
+//formText is the posted form for the audit log, without the CSRF token and
+//passwords.
+func formText(r *http.Request) string {
+	if r.PostForm == nil {
+		return ""
+	}
+	f := url.Values{}
+	for k, v := range r.PostForm {
+		if k != "csrf_token" && !strings.Contains(k, "password") {
+			f[k] = v
+		}
+	}
+	return f.Encode()
+}
+
+//bodyText is the body of an api change for the audit log, JSON without any
+//password fields or else the text quoted.  What it reads is put back for the
+//handler.
+func bodyText(r *http.Request) string {
+	if r.Body == nil || r.Body == http.NoBody {
+		return ""
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(r.Body, maxAuditBody+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+	if len(b) == 0 {
+		return ""
+	}
+	if len(b) > maxAuditBody {
+		return fmt.Sprintf("(body over %d bytes)", maxAuditBody)
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return strconv.Quote(string(b))
+	}
+	out, _ := json.Marshal(dropPasswords(v))
+	return string(out)
+}
+
+//dropPasswords takes the password fields out of decoded JSON, at any depth.
+func dropPasswords(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			if strings.Contains(strings.ToLower(k), "password") {
+				delete(t, k)
+			} else {
+				t[k] = dropPasswords(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = dropPasswords(e)
+		}
+	}
+	return v
+}
+
+//addUser is for -adduser name:role, the password is the first line of the
+//standard input.
+func addUser(store *userStore, spec string, audit *log.Logger) error {
+	f := strings.SplitN(spec, ":", 2)
+	if len(f) != 2 {
+		return fmt.Errorf("-adduser wants name:role")
+	}
+	fmt.Fprintf(os.Stderr, "password for %s: ", f[0])
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return err
+	}
+	if err := store.setUser(f[0], f[1], strings.TrimRight(line, "\r\n")); err != nil {
+		return err
+	}
+	audit.Printf("user %s set to %s from the command line", f[0], f[1])
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/Saied74/labjack/ui"
+)
+
+/*
+Config file lets every command line flag also be given in an environment
+variable or a config file.  The variable is the flag name in upper case with -
+turned into _ and prefixed by U3WEB_, so -tlscert is U3WEB_TLSCERT.  The config
+file, named by -config or U3WEB_CONFIG, has one flag per line:
+
+	# comments and blank lines are ignored
+	addr = :8443
+	tlscert = /etc/u3web/cert.pem
+
+The command line wins over the environment, which wins over the file.
+*/
+
+const envPrefix = "U3WEB_"
+
+//envName is the environment variable for flag name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+//applyConfig sets the flags not given on the command line from the environment
+//and then from the config file named by the config flag.  Call it after
+//flag.Parse.
+func applyConfig(fset *flag.FlagSet) error {
+	set := map[string]bool{}
+	fset.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	fset.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok || set[f.Name] || err != nil {
+			return
+		}
+		if e := fset.Set(f.Name, v); e != nil {
+			err = fmt.Errorf("%s: %v", envName(f.Name), e)
+		}
+		set[f.Name] = true
+	})
+	if err != nil {
+		return err
+	}
+	configFile := fset.Lookup("config").Value.String()
+	if configFile == "" {
+		return nil
+	}
+
+	file, err := os.Open(configFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("%s line %d: expected name = value", configFile, n)
+		}
+		name, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if fset.Lookup(name) == nil {
+			return fmt.Errorf("%s line %d: %q is not a flag", configFile, n, name)
+		}
+		if set[name] {
+			continue
+		}
+		if err := fset.Set(name, value); err != nil {
+			return fmt.Errorf("%s line %d: %v", configFile, n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+//uiFiles returns the ui directory dir on disk, for working on the templates
+//without rebuilding, or the copy built into the binary when dir is empty.
+func uiFiles(dir string) fs.FS {
+	if dir != "" {
+		return os.DirFS(dir)
+	}
+	return ui.Files
+}
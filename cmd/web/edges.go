@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+Edges file holds the edge detection on the digital inputs.  The U3 is polled,
+Port State Read only gives the level at the time of the read, so the edges are
+found here by comparing each acquisition with the one before, whether it came
+from the measurement page or the background poll (see -poll in main.go).  An
+edge shorter than the time between acquisitions is not seen, so run -poll
+faster than the inputs change.
+
+Each pin has its own settings, Edge says which edges are counted and logged
+(None, Rising, Falling or Both) and Debounce how many milliseconds a new level
+has to be read for before it counts, so contact bounce read on a couple of
+acquisitions in a row is not a string of edges.  With a Debounce of 0 every
+change counts.  The time of an edge is that of the first acquisition the new
+level was read in.
+
+Every edge is counted on the pin (Rising and Falling) and is an EdgeEvent in
+app.u3.EdgeEvents, the last maxEvents of them.  The counts are reset on the
+edges page or the api, and when the settings of the pin change.
+*/
+
+var edgeKinds = []string{"None", "Rising", "Falling", "Both"}
+
+const maxDebounce = 60000 //milliseconds
+
+type EdgeEvent struct {
+	Time  time.Time
+	Pin   string
+	Name  string
+	Edge  string //Rising or Falling
+	Count int    //edges of that kind on the pin so far, this one included
+}
+
+//EdgeSettings is what the edges page and the api set for one pin.
+type EdgeSettings struct {
+	Pin      string
+	Edge     string
+	Debounce float64
+}
+
+//setEdge checks s and puts it on its pin, which starts over with no counts.
+func (u *U3) setEdge(s EdgeSettings) error {
+	pin, ch, err := u.pinByName(s.Pin)
+	if err != nil {
+		return err
+	}
+	ok := false
+	for _, k := range edgeKinds {
+		ok = ok || k == s.Edge
+	}
+	switch {
+	case !ok:
+		return fmt.Errorf("%q is not an edge, use one of %v", s.Edge, edgeKinds)
+	case s.Debounce < 0 || s.Debounce > maxDebounce:
+		return fmt.Errorf("debounce must be from 0 to %d ms, got %g", maxDebounce, s.Debounce)
+	case ch < 4 && s.Edge != "None":
+		return fmt.Errorf("%s is analog only", s.Pin)
+	}
+	pin.Edge = s.Edge
+	pin.Debounce = s.Debounce
+	pin.resetEdges()
+	return nil
+}
+
+//resetEdges zeroes the counts and forgets the last level read.
+func (p *Pin) resetEdges() {
+	p.Rising, p.Falling = 0, 0
+	p.LastEdge = time.Time{}
+	p.edgeStarted = false
+}
+
+//resetEdgeCounts resets the pin named, or all of them for "".
+func (u *U3) resetEdgeCounts(name string) error {
+	if name == "" {
+		for _, pin := range u.pins() {
+			pin.resetEdges()
+		}
+		return nil
+	}
+	pin, _, err := u.pinByName(name)
+	if err != nil {
+		return err
+	}
+	pin.resetEdges()
+	return nil
+}
+
+//detectEdge looks at the level read at t and returns Rising or Falling, with
+//the time it started, when it is a debounced edge.  It returns "" when it is
+//not an edge, or not yet.
+func (p *Pin) detectEdge(t time.Time) (string, time.Time) {
+	level := p.DigitalRead
+	if !p.edgeStarted {
+		p.edgeStarted = true
+		p.edgeLevel = level
+		p.edgeSince = time.Time{}
+		return "", t
+	}
+	if level == p.edgeLevel {
+		p.edgeSince = time.Time{} //bounced back before the debounce time
+		return "", t
+	}
+	if p.edgeSince.IsZero() {
+		p.edgeSince = t
+	}
+	if t.Sub(p.edgeSince) < time.Duration(p.Debounce*float64(time.Millisecond)) {
+		return "", t
+	}
+	at := p.edgeSince
+	p.edgeLevel = level
+	p.edgeSince = time.Time{}
+	if level == 1 {
+		return "Rising", at
+	}
+	return "Falling", at
+}
+
+//detectEdges runs the edge detection of every digital input on the last
+//acquisition.  Must be called with app.mu held.
+func (app *application) detectEdges(t time.Time) {
+	for _, pin := range app.u3.pins() {
+		if pin.Edge == "" || pin.Edge == "None" || pin.AD != "Digital" || pin.IO != "Input" {
+			pin.edgeStarted = false //no edge from what it was before it became an input
+			continue
+		}
+		edge, at := pin.detectEdge(t)
+		if edge == "" || (pin.Edge != "Both" && pin.Edge != edge) {
+			continue
+		}
+		e := EdgeEvent{Time: at, Pin: pin.Label, Name: pin.DisplayName(), Edge: edge}
+		if edge == "Rising" {
+			pin.Rising++
+			e.Count = pin.Rising
+		} else {
+			pin.Falling++
+			e.Count = pin.Falling
+		}
+		pin.LastEdge = at
+		app.u3.EdgeEvents = append(app.u3.EdgeEvents, e)
+		if len(app.u3.EdgeEvents) > maxEvents {
+			app.u3.EdgeEvents = app.u3.EdgeEvents[len(app.u3.EdgeEvents)-maxEvents:]
+		}
+	}
+}
+
+//EdgePins are the pins the edges page shows, the digital inputs and any pin
+//with edges turned on.
+func (u *U3) EdgePins() []*Pin {
+	pins := []*Pin{}
+	for _, pin := range u.pins() {
+		if (pin.AD == "Digital" && pin.IO == "Input") || (pin.Edge != "" && pin.Edge != "None") {
+			pins = append(pins, pin)
+		}
+	}
+	return pins
+}
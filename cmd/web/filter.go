@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+Filter file holds the host side filtering of analog reads.  Single reads on the
+U3 are noisy, so each analog pin can be read Samples times per acquisition and
+the reads combined with one of the filters below.  The reads of one acquisition
+are batched into as few feedback packets as possible (jack.MaxAINBatch reads
+each).
+
+None:   the last read is used as is.
+Mean:   the average of the reads of this acquisition.
+Median: the middle of the reads of this acquisition, good for spikes.
+EMA:    exponential moving average, each read moves the average EMAWeight of
+        the way towards itself.  It carries over from one acquisition to the
+        next, so it also smooths between page refreshes.
+
+The last raw read stays in AnalogRead and AnalogVoltage, the filtered result is
+in FilteredRead and FilteredVoltage.
+*/
+
+const (
+	maxSamples       = 256 //upper limit on Samples, to keep acquisitions short
+	defaultEMAWeight = 0.2
+)
+
+var filters = []string{"None", "Mean", "Median", "EMA"}
+
+func (p *Pin) setSamples(n int) error {
+	if n < 1 || n > maxSamples {
+		return fmt.Errorf("samples must be between 1 and %d, got %d", maxSamples, n)
+	}
+	p.Samples = n
+	return nil
+}
+
+func (p *Pin) setFilter(name string) error {
+	for _, f := range filters {
+		if f == name {
+			p.Filter = name
+			p.emaValid = false
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not a filter, use one of %v", name, filters)
+}
+
+func (p *Pin) setEMAWeight(w float64) error {
+	if w <= 0 || w > 1 {
+		return fmt.Errorf("EMA weight must be over 0 and at most 1, got %v", w)
+	}
+	p.EMAWeight = w
+	return nil
+}
+
+// filterAIN turns the reads of the last acquisition of pin (analog channel ch)
+// into the raw and filtered values and clears them for the next acquisition.
+func (u *U3) filterAIN(pin *Pin, ch int) {
+	if len(pin.samples) == 0 {
+		return
+	}
+	defer func() { pin.samples = pin.samples[:0] }()
+	read := pin.samples[len(pin.samples)-1]
+	pin.AnalogRead = read
+	hv, err := u.hv()
+	var v float64
+	if err == nil {
+		v, err = u.cal.AINVoltage(ch, pin.NegChannel, float64(read), hv)
+	}
+	if err != nil {
+		//the value of the last good read must not be taken for this one
+		pin.AnalogVoltage = err.Error()
+		pin.FilteredVoltage = err.Error()
+		pin.Value = 0
+		pin.ValueText = "out of range"
+		pin.OutOfRange = true
+		return
+	}
+	pin.AnalogVoltage = fmt.Sprintf("%0.3f", v)
+
+	pin.FilteredRead = pin.filter()
+	v, _ = u.cal.AINVoltage(ch, pin.NegChannel, pin.FilteredRead, hv)
+	pin.FilteredVoltage = fmt.Sprintf("%0.3f", v)
+	pin.scale(v)
+}
+
+func (p *Pin) filter() float64 {
+	switch p.Filter {
+	case "Mean":
+		sum := 0.0
+		for _, s := range p.samples {
+			sum += float64(s)
+		}
+		return sum / float64(len(p.samples))
+	case "Median":
+		sorted := make([]float64, len(p.samples))
+		for i, s := range p.samples {
+			sorted[i] = float64(s)
+		}
+		sort.Float64s(sorted)
+		n := len(sorted)
+		if n%2 == 1 {
+			return sorted[n/2]
+		}
+		return (sorted[n/2-1] + sorted[n/2]) / 2
+	case "EMA":
+		for _, s := range p.samples {
+			if !p.emaValid {
+				p.ema = float64(s)
+				p.emaValid = true
+				continue
+			}
+			p.ema += p.EMAWeight * (float64(s) - p.ema)
+		}
+		return p.ema
+	}
+	return float64(p.samples[len(p.samples)-1])
+}
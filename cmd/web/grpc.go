@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Saied74/labjack/pkg/jack"
+	"github.com/Saied74/labjack/pkg/u3pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+/*
+gRPC file holds the gRPC api, turned on with -grpc and an address such as
+:50051, for services written against the generated client in pkg/u3pb.  The
+service and its messages are in pkg/u3pb/u3.proto.  Like the JSON api it works
+on app.u3 holding app.mu, so what is done here shows up on the pages.  It is
+served with TLS when -tlscert and -tlskey are given.
+
+With -users every call needs basic authentication in the "authorization"
+metadata, "Basic " and base64 of name:password, the same as the JSON api.
+GetDevice, Read and StreamSamples need a viewer and the rest an operator.
+Every change goes in the audit log.
+
+Read and StreamSamples acquire like the measurement page, unless -poll is on
+in which case they return what the poll read last.
+*/
+
+const minStreamInterval = 10 * time.Millisecond
+
+type grpcServer struct {
+	u3pb.UnimplementedU3Server
+	app *application
+}
+
+//serveGRPC listens on addr and serves the api, it only returns when the
+//listener fails.
+func (app *application) serveGRPC(addr, certFile, keyFile string) error {
+	var opts []grpc.ServerOption
+	if certFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer(opts...)
+	u3pb.RegisterU3Server(s, &grpcServer{app: app})
+	app.infoLog.Printf("gRPC server on %s", addr)
+	return s.Serve(ln)
+}
+
+//caller checks that the caller of ctx has role and returns who it is for the
+//logs.
+func (g *grpcServer) caller(ctx context.Context, role Role) (string, error) {
+	from := "grpc"
+	if p, ok := peer.FromContext(ctx); ok {
+		from += " " + p.Addr.String()
+	}
+	if g.app.users == nil {
+		return from, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	auth := md.Get("authorization")
+	if len(auth) == 0 || !strings.HasPrefix(auth[0], "Basic ") {
+		return "", status.Error(codes.Unauthenticated, "basic authentication needed")
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth[0], "Basic "))
+	if err != nil {
+		return "", status.Error(codes.Unauthenticated, "bad authorization")
+	}
+	name, password, _ := strings.Cut(string(b), ":")
+	u := g.app.users.check(name, password)
+	if u == nil {
+		g.app.audit.Printf("%s failed login as %s", from, name)
+		return "", status.Error(codes.Unauthenticated, "wrong name or password")
+	}
+	if u.Role < role {
+		method, _ := grpc.Method(ctx)
+		g.app.audit.Printf("%s (%s) denied %s from %s", u.Name, u.Role, method, from)
+		return "", status.Errorf(codes.PermissionDenied, "%s is a %s", u.Name, u.Role)
+	}
+	return "grpc " + u.Name, nil
+}
+
+//deviceError is the status of a failed exchange with the device.
+func deviceError(err error) error {
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+func (g *grpcServer) GetDevice(ctx context.Context, _ *u3pb.GetDeviceRequest) (*u3pb.Device, error) {
+	from, err := g.caller(ctx, roleViewer)
+	if err != nil {
+		return nil, err
+	}
+	g.app.lock(from)
+	defer g.app.unlock()
+	return deviceMessage(g.app.u3), nil
+}
+
+//Configure checks every pin before changing any of them.
+func (g *grpcServer) Configure(ctx context.Context, req *u3pb.ConfigureRequest) (*u3pb.Device, error) {
+	from, err := g.caller(ctx, roleOperator)
+	if err != nil {
+		return nil, err
+	}
+	g.app.lock(from)
+	defer g.app.unlock()
+	scratch := newU3()
+	chans := []int{}
+	for _, m := range req.Pins {
+		ch, err := jack.Channel(m.Pin)
+		if err == nil {
+			err = scratch.setMode(ch, m.Ad, m.Io)
+		}
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		chans = append(chans, ch)
+	}
+	for i, m := range req.Pins {
+		g.app.u3.setMode(chans[i], m.Ad, m.Io)
+		mode := m.Ad
+		if mode == "Digital" {
+			mode += " " + m.Io
+		}
+		g.app.audit.Printf("%s configured %s %s", from, m.Pin, mode)
+	}
+	if err := g.app.writeConfig(); err != nil {
+		return nil, deviceError(err)
+	}
+	return deviceMessage(g.app.u3), nil
+}
+
+func (g *grpcServer) SetPinSettings(ctx context.Context, req *u3pb.PinSettings) (*u3pb.Pin, error) {
+	from, err := g.caller(ctx, roleOperator)
+	if err != nil {
+		return nil, err
+	}
+	g.app.lock(from)
+	defer g.app.unlock()
+	pin, _, err := g.app.u3.pinByName(req.Pin)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	s := pinSettings{Name: req.Name, Unit: req.Unit, LongSettling: req.LongSettling,
+		QuickSample: req.QuickSample, Filter: req.Filter, EMAWeight: req.EmaWeight}
+	if req.Scale != nil {
+		scale := scaleFromMessage(req.Scale)
+		s.Scale = &scale
+	}
+	if req.NegChannel != nil {
+		n := int(*req.NegChannel)
+		s.NegChannel = &n
+	}
+	if req.Samples != nil {
+		n := int(*req.Samples)
+		s.Samples = &n
+	}
+	if err := s.apply(g.app.u3, pin); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	g.app.audit.Printf("%s changed the settings of %s: %v", from, req.Pin, req)
+	return pinMessage(pin), nil
+}
+
+func (g *grpcServer) Read(ctx context.Context, req *u3pb.ReadRequest) (*u3pb.Readings, error) {
+	from, err := g.caller(ctx, roleViewer)
+	if err != nil {
+		return nil, err
+	}
+	g.app.lock(from)
+	defer g.app.unlock()
+	return g.readings(req.Pins, true)
+}
+
+//readings acquires, unless the poll does, and returns the pins named.  Must
+//be called with app.mu held.
+func (g *grpcServer) readings(names []string, acquire bool) (*u3pb.Readings, error) {
+	pins := []*Pin{}
+	for _, name := range names {
+		pin, _, err := g.app.u3.pinByName(name)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		pins = append(pins, pin)
+	}
+	if acquire && !g.app.polling {
+		if err := g.app.acquire(); err != nil {
+			return nil, deviceError(err)
+		}
+	}
+	if len(names) == 0 {
+		for _, pin := range g.app.u3.pins() {
+			if _, _, ok := pin.pinValue(); ok {
+				pins = append(pins, pin)
+			}
+		}
+	}
+	r := &u3pb.Readings{Time: time.Now().UnixNano(), Temperature: g.app.u3.Temperature}
+	for _, pin := range pins {
+		r.Pins = append(r.Pins, pinMessage(pin))
+	}
+	return r, nil
+}
+
+//WriteDigital checks every level before writing any of them, and writes them
+//all with one Port State Write.
+func (g *grpcServer) WriteDigital(ctx context.Context, req *u3pb.WriteDigitalRequest) (*u3pb.Readings, error) {
+	from, err := g.caller(ctx, roleOperator)
+	if err != nil {
+		return nil, err
+	}
+	g.app.lock(from)
+	defer g.app.unlock()
+	names := []string{}
+	for _, l := range req.Levels {
+		pin, _, err := g.app.u3.pinByName(l.Pin)
+		switch {
+		case err != nil:
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case pin.AD != "Digital" || pin.IO != "Output":
+			return nil, status.Errorf(codes.FailedPrecondition, "%s is not a digital output", l.Pin)
+		case l.Level != 0 && l.Level != 1:
+			return nil, status.Errorf(codes.InvalidArgument, "%d is not a level, use 0 or 1", l.Level)
+		}
+		names = append(names, l.Pin)
+	}
+	write := map[*Pin]int{}
+	for _, l := range req.Levels {
+		pin, _, _ := g.app.u3.pinByName(l.Pin)
+		write[pin] = int(l.Level)
+	}
+	if err := g.app.writePort(write); err != nil {
+		g.app.audit.Printf("%s could not set %s: %v", from, strings.Join(names, ", "), err)
+		return nil, deviceError(err)
+	}
+	for _, l := range req.Levels {
+		g.app.audit.Printf("%s set %s to %d", from, l.Pin, l.Level)
+	}
+	return g.readings(names, false)
+}
+
+func (g *grpcServer) WriteDAC(ctx context.Context, req *u3pb.WriteDACRequest) (*u3pb.Device, error) {
+	from, err := g.caller(ctx, roleOperator)
+	if err != nil {
+		return nil, err
+	}
+	g.app.lock(from)
+	defer g.app.unlock()
+	if req.Dac != 0 && req.Dac != 1 {
+		return nil, status.Errorf(codes.InvalidArgument, "there is no DAC%d", req.Dac)
+	}
+	if math.IsNaN(req.Volts) || req.Volts < 0 || req.Volts > 5 {
+		return nil, status.Errorf(codes.InvalidArgument, "%g volts, the DACs go from 0 to 5", req.Volts)
+	}
+	if err := g.app.setDAC(int(req.Dac), req.Volts); err != nil {
+		return nil, deviceError(err)
+	}
+	g.app.audit.Printf("%s set DAC%d to %g V", from, req.Dac, req.Volts)
+	return deviceMessage(g.app.u3), nil
+}
+
+//StreamSamples holds app.mu only for each sample, so the pages keep working
+//in between.
+func (g *grpcServer) StreamSamples(req *u3pb.StreamRequest, stream u3pb.U3_StreamSamplesServer) error {
+	from, err := g.caller(stream.Context(), roleViewer)
+	if err != nil {
+		return err
+	}
+	interval := time.Duration(req.IntervalMs) * time.Millisecond
+	if interval < minStreamInterval {
+		return status.Errorf(codes.InvalidArgument, "interval_ms must be at least %d", minStreamInterval/time.Millisecond)
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for sent := int64(0); req.Count == 0 || sent < req.Count; sent++ {
+		g.app.lock(from)
+		r, err := g.readings(req.Pins, true)
+		g.app.unlock()
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(r); err != nil {
+			return err
+		}
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-tick.C:
+		}
+	}
+	return nil
+}
+
+//<++++++++++++++++++++++   model to messages and back   ++++++++++++++++++++++>
+
+func deviceMessage(u *U3) *u3pb.Device {
+	d := &u3pb.Device{FirmwareVersion: u.FirmwareVersion, BootLoaderVersion: u.BootLoaderVersion,
+		HardwareVersion: u.HardwareVersion, SerialNumber: u.SerialNumber, ProductId: u.ProductID,
+		LocalId: u.LocalID, DeviceName: u.DeviceName, Temperature: u.Temperature, Dac: u.DAC[:],
+		Message: u.Message, Led: u.LED, DeviceNumber: int32(u.DeviceNumber)}
+	for _, pin := range u.FIO {
+		d.Fio = append(d.Fio, pinMessage(pin))
+	}
+	for _, pin := range u.EIO {
+		d.Eio = append(d.Eio, pinMessage(pin))
+	}
+	for _, pin := range u.CIO {
+		d.Cio = append(d.Cio, pinMessage(pin))
+	}
+	return d
+}
+
+func pinMessage(p *Pin) *u3pb.Pin {
+	return &u3pb.Pin{Ad: p.AD, Io: p.IO, AnalogRead: uint32(p.AnalogRead), AnalogVoltage: p.AnalogVoltage,
+		DigitalRead: int32(p.DigitalRead), DigitalWrite: int32(p.DigitalWrite), NegChannel: int32(p.NegChannel),
+		LongSettling: p.LongSettling, QuickSample: p.QuickSample, Samples: int32(p.Samples),
+		Filter: p.Filter, EmaWeight: p.EMAWeight, FilteredRead: p.FilteredRead,
+		FilteredVoltage: p.FilteredVoltage, Label: p.Label, Name: p.Name, Unit: p.Unit,
+		Scale: scaleMessage(p.Scale), Value: p.Value, ValueText: p.ValueText}
+}
+
+func scaleMessage(s Scale) *u3pb.Scale {
+	m := &u3pb.Scale{Kind: s.Kind, Slope: s.Slope, Offset: s.Offset, Coefficients: s.Coefficients,
+		Beta: s.Beta, R0: s.R0, T0: s.T0, RFixed: s.RFixed, Supply: s.Supply, TcType: s.TCType,
+		ColdJunction: s.ColdJunction, Gain: s.Gain}
+	for _, p := range s.Table {
+		m.Table = append(m.Table, &u3pb.TablePoint{Volts: p[0], Value: p[1]})
+	}
+	return m
+}
+
+func scaleFromMessage(m *u3pb.Scale) Scale {
+	s := Scale{Kind: m.Kind, Slope: m.Slope, Offset: m.Offset, Coefficients: m.Coefficients,
+		Beta: m.Beta, R0: m.R0, T0: m.T0, RFixed: m.RFixed, Supply: m.Supply, TCType: m.TcType,
+		ColdJunction: m.ColdJunction, Gain: m.Gain}
+	for _, p := range m.Table {
+		s.Table = append(s.Table, [2]float64{p.Volts, p.Value})
+	}
+	return s
+}
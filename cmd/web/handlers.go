@@ -1,11 +1,33 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"html/template"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Saied74/labjack/pkg/jack"
 )
 
+//templateData is what a page is rendered from: a copy of app.u3 and what
+//belongs to the one request, which is not put on the shared app.u3.
 type templateData struct {
+	*U3
+	Sequences    []string //names of the sequences in the -sequences directory
+	SequenceName string   //sequence in the editor
+	SequenceText string
+	Run          *SequenceRun  //run shown on the report page
+	Session      *Session      //user of the request, see auth.go
+	Users        []*User       //for the users page
+	Transactions []Transaction //found on the transactions page, see txlog.go
+	TxFilter     TxFilter
+	TxQuery      template.URL //the filter as url query, for the export links
+	DecodeSend   string       //packets pasted on the decode page
+	DecodeRec    string
+	Frames       []jack.Frame //and what they decode to, see package jack
+	Chart        *Chart       //for the charts page, see history.go
 }
 
 //home page contains very basic documentation.
@@ -21,18 +43,23 @@ func (app *application) notImplemented(w http.ResponseWriter, r *http.Request) {
 //reads results from the device flash and displays them on the configuraton page.
 //It needs to be invked at the start of operation.
 func (app *application) flash(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
 	//u3SendRec is the generic function for accessing all U3 commands.
 	//the command name is passed on to the functon to choose the command.
 	//configJack reads all data from the device flash memory
 	//writeMask is set to zero to avoid aging the flash memory
-	app.u3SendRec(configJack, 0x00)
+	app.u3SendRec(jack.ConfigJack, 0x00)
+	app.readCalibration()
 	app.render(w, r, "configure.page.html", app.u3)
 }
 
 //reads the results from the device voltaile memory
 func (app *application) getConfig(w http.ResponseWriter, r *http.Request) {
-	app.u3SendRec(configIO, 0x00)    //reads the Anolog, Digital setting.
-	app.u3SendRec(portDirRead, 0x00) //Reads the Input/Output setting for digital pins.
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3SendRec(jack.ConfigIO, 0x00)    //reads the Anolog, Digital setting.
+	app.u3SendRec(jack.PortDirRead, 0x00) //Reads the Input/Output setting for digital pins.
 	app.render(w, r, "configure.page.html", app.u3)
 }
 
@@ -44,47 +71,77 @@ func (app *application) configure(w http.ResponseWriter, r *http.Request) {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
+	app.lock(origin(r))
+	defer app.unlock()
 	//pulls the Analog/Digital settings from the web form and populates app.u3
 	err = app.u3.pullAD(r.PostForm)
 	if err != nil {
-		fmt.Println("pullAD returned error", err)
+		app.rejectConfig(w, r, err)
+		return
 	}
 	//pulls the Input/Output settings from the web form and populates app.u3
 	//and app.srData.  Note that app.u3 is fit for the web page and app.srData
 	//is fit for the U3 device itself.
 	err = app.u3.pullIO(r.PostForm)
 	if err != nil {
-		fmt.Println("pullIO returned error", err)
+		app.rejectConfig(w, r, err)
+		return
+	}
+	//pulls the negative channel of analog pins, it only goes into app.u3
+	//since it is sent with every analog read.
+	err = app.u3.pullNeg(r.PostForm)
+	if err != nil {
+		app.rejectConfig(w, r, err)
+		return
+	}
+	//pulls the settling and quick sample options of analog pins, same as above.
+	err = app.u3.pullAcquisition(r.PostForm)
+	if err != nil {
+		app.rejectConfig(w, r, err)
+		return
+	}
+	//pulls the samples and filter of analog pins, same as above.
+	err = app.u3.pullFilter(r.PostForm)
+	if err != nil {
+		app.rejectConfig(w, r, err)
+		return
 	}
 	//copy Analog/Digital setting from app.u3 to app.srData
-	app.copyToWriteJack(configIO)
+	app.copyToWriteJack(jack.ConfigIO)
 	writeMask := byte(0x0C)
 	//write Analog/Digital setting to the device volatile memory
-	app.u3SendRec(configIO, writeMask)
+	if err := app.u3SendRec(jack.ConfigIO, writeMask); err != nil {
+		app.rejectConfig(w, r, err)
+		return
+	}
 	//copy Input/Output setting for digital pins from app.u3 to app.srData
-	app.copyToWriteDirection(portDirWrite)
+	app.copyToWriteDirection(jack.PortDirWrite)
 	writeMask = byte(0x01) //just to satisfy function signature
 	//write the Input/Output setting for digital pins to the device volatile memory.
-	app.u3SendRec(portDirWrite, writeMask)
+	if err := app.u3SendRec(jack.PortDirWrite, writeMask); err != nil {
+		app.rejectConfig(w, r, err)
+		return
+	}
+	app.render(w, r, "configure.page.html", app.u3)
+}
+
+//rejectConfig shows the configuration the device has, with err as the
+//message, for a configure form that does not check out or could not be
+//written.
+func (app *application) rejectConfig(w http.ResponseWriter, r *http.Request, err error) {
+	app.u3SendRec(jack.ConfigIO, 0x00)
+	app.u3SendRec(jack.PortDirRead, 0x00)
+	app.u3.Message = err.Error()
 	app.render(w, r, "configure.page.html", app.u3)
 }
 
 func (app *application) measure(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
 
-	app.u3SendRec(portStateRead, 0x00)
-	for i, pin := range app.u3.FIO {
-		app.srData[ain].byte8 = 0x00
-		if pin.AD == "Analog" {
-			app.srData[ain].byte8 = byte(i) | 0x40 //for long settling
-			app.u3SendRec(ain, 0x00)
-		}
-	}
-	for i, pin := range app.u3.EIO {
-		app.srData[ain].byte8 = 0x00
-		if pin.AD == "Analog" {
-			app.srData[ain].byte8 = byte(i+8) | 0x40 //for long settling
-			app.u3SendRec(ain, 0x00)
-		}
+	app.u3.Message = "No Message"
+	if err := app.acquire(); err != nil {
+		app.u3.Message = err.Error()
 	}
 	app.render(w, r, "measure.page.html", app.u3)
 }
@@ -95,14 +152,702 @@ func (app *application) updateDigital(w http.ResponseWriter, r *http.Request) {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
-	fmt.Println("postform", r.PostForm)
+	app.lock(origin(r))
+	defer app.unlock()
+	//DigitalWrite is what the device has, so it goes back when the form can
+	//not be written
+	was := map[*Pin]int{}
+	for _, pin := range app.u3.pins() {
+		was[pin] = pin.DigitalWrite
+	}
+	app.u3.Message = "No Message"
 	//pulls the digitalWrite settings from the web form and populates app.u3
 	err = app.u3.pullDigitalOutput(r.PostForm)
+	if err == nil {
+		app.copyToWirteDigitalOutput(jack.PortStateWrite)
+		writeMask := byte(0x01)
+		err = app.u3SendRec(jack.PortStateWrite, writeMask)
+	}
 	if err != nil {
-		fmt.Println("pullDigitalOutput returned error", err)
+		for pin, level := range was {
+			pin.DigitalWrite = level
+		}
+		app.u3.Message = err.Error()
 	}
-	app.copyToWirteDigitalOutput(portStateWrite)
-	writeMask := byte(0x01)
-	app.u3SendRec(portStateWrite, writeMask)
 	app.render(w, r, "measure.page.html", app.u3)
 }
+
+//lists all the U3s on the USB bus so the one this program talks to can be
+//picked or identified.
+func (app *application) devices(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	app.scanDevices()
+	app.render(w, r, "devices.page.html", app.u3)
+}
+
+//makes the device picked on the devices page the one all other pages talk to
+//and reads its flash setting so the configuration page matches it.
+func (app *application) selectDevice(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	n, err := strconv.Atoi(r.PostForm.Get("device"))
+	if err != nil || n < 1 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.DeviceNumber = n
+	app.u3.cal = jack.NewCalibration()
+	app.u3.config = nil
+	app.u3SendRec(jack.ConfigJack, 0x00)
+	app.readCalibration()
+	app.render(w, r, "configure.page.html", app.u3)
+}
+
+//turns the LED on the selected device on or off.
+func (app *application) setLED(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	state := r.PostForm.Get("led")
+	if state != "On" && state != "Off" {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.srData[jack.LED].Byte8 = 0x00
+	if state == "On" {
+		app.srData[jack.LED].Byte8 = 0x01
+	}
+	if err := app.u3SendRec(jack.LED, 0x00); err == nil {
+		app.u3.LED = state
+	}
+	app.render(w, r, "devices.page.html", app.u3)
+}
+
+//blinks the LED of the device picked on the devices page.  Blinking is done in
+//the background so the page comes back right away.
+func (app *application) identify(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	n, err := strconv.Atoi(r.PostForm.Get("device"))
+	if err != nil || n < 1 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	go app.blinkLED(origin(r), n, identifyBlinks)
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = fmt.Sprintf("Blinking the LED on device %d", n)
+	app.render(w, r, "devices.page.html", app.u3)
+}
+
+//shows the name, unit and scale of each pin.
+func (app *application) channels(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	app.render(w, r, "channels.page.html", app.u3)
+}
+
+//changes the name, unit and scale of pins from the channels page.
+func (app *application) updateChannels(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = "No Message"
+	if err := app.u3.pullChannels(r.PostForm); err != nil {
+		app.u3.Message = err.Error()
+	}
+	app.render(w, r, "channels.page.html", app.u3)
+}
+
+//exports the last measurement as CSV, one pin to a line.
+func (app *application) export(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"measurement.csv\"")
+	app.u3.writeCSV(w)
+}
+
+//shows the alarm rules, their state and the event log.
+func (app *application) alarms(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	app.render(w, r, "alarms.page.html", app.u3)
+}
+
+//adds an alarm rule from the alarms page.
+func (app *application) addAlarmForm(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = "No Message"
+	a, err := pullAlarm(r.PostForm)
+	if err == nil {
+		err = app.addAlarm(a)
+	}
+	if err != nil {
+		app.u3.Message = err.Error()
+	}
+	app.render(w, r, "alarms.page.html", app.u3)
+}
+
+func (app *application) deleteAlarmForm(w http.ResponseWriter, r *http.Request) {
+	app.alarmFormAction(w, r, app.deleteAlarm)
+}
+
+func (app *application) ackAlarmForm(w http.ResponseWriter, r *http.Request) {
+	app.alarmFormAction(w, r, app.ackAlarm)
+}
+
+//runs action on the alarm whose id is in the form and shows the alarms page.
+func (app *application) alarmFormAction(w http.ResponseWriter, r *http.Request, action func(int) error) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.Atoi(r.PostForm.Get("id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = "No Message"
+	if err := action(id); err != nil {
+		app.u3.Message = err.Error()
+	}
+	app.render(w, r, "alarms.page.html", app.u3)
+}
+
+//shows the edge settings and counts of the digital inputs and the edges seen.
+func (app *application) edges(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	app.render(w, r, "edges.page.html", app.u3)
+}
+
+//sets the edge and debounce of a pin from the edges page.
+func (app *application) setEdgeForm(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = "No Message"
+	s := EdgeSettings{Pin: r.PostForm.Get("pin"), Edge: r.PostForm.Get("edge")}
+	if s.Debounce, err = strconv.ParseFloat(r.PostForm.Get("debounce"), 64); err != nil {
+		err = fmt.Errorf("debounce %q is not a number", r.PostForm.Get("debounce"))
+	} else {
+		err = app.u3.setEdge(s)
+	}
+	if err != nil {
+		app.u3.Message = err.Error()
+	}
+	app.render(w, r, "edges.page.html", app.u3)
+}
+
+//resets the edge counts of the pin in the form, or of all of them.
+func (app *application) resetEdgesForm(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = "No Message"
+	if err := app.u3.resetEdgeCounts(r.PostForm.Get("pin")); err != nil {
+		app.u3.Message = err.Error()
+	}
+	app.render(w, r, "edges.page.html", app.u3)
+}
+
+//shows the interlock rules and the changes they made.
+func (app *application) rules(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	app.render(w, r, "rules.page.html", app.u3)
+}
+
+//adds an interlock rule written as text on the rules page.
+func (app *application) addRuleForm(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = "No Message"
+	c, err := parseControlRule(r.PostForm.Get("rule"))
+	if err == nil {
+		err = app.addRule(c)
+	}
+	if err != nil {
+		app.u3.Message = err.Error()
+	}
+	app.render(w, r, "rules.page.html", app.u3)
+}
+
+func (app *application) deleteRuleForm(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.Atoi(r.PostForm.Get("id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = "No Message"
+	if err := app.deleteRule(id); err != nil {
+		app.u3.Message = err.Error()
+	}
+	app.render(w, r, "rules.page.html", app.u3)
+}
+
+//shows the output patterns and the last plays.
+func (app *application) patterns(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	app.render(w, r, "patterns.page.html", app.u3)
+}
+
+//adds a pattern written as text on the patterns page.
+func (app *application) addPatternForm(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = "No Message"
+	p, err := parsePattern(r.PostForm.Get("pattern"))
+	if err == nil {
+		err = app.addPattern(p)
+	}
+	if err != nil {
+		app.u3.Message = err.Error()
+	}
+	app.render(w, r, "patterns.page.html", app.u3)
+}
+
+//deletePatternForm, playPatternForm and stopPatternForm do what their button
+//on the patterns page says.
+func (app *application) deletePatternForm(w http.ResponseWriter, r *http.Request) {
+	app.patternForm(w, r, func(id int) error { return app.deletePattern(id) })
+}
+
+func (app *application) playPatternForm(w http.ResponseWriter, r *http.Request) {
+	app.patternForm(w, r, func(id int) error {
+		run, err := app.playPattern(origin(r), id)
+		if err == nil && run.Error != "" {
+			err = fmt.Errorf("%s", run.Error)
+		}
+		return err
+	})
+}
+
+func (app *application) stopPatternForm(w http.ResponseWriter, r *http.Request) {
+	app.patternForm(w, r, func(int) error { return app.stopPattern() })
+}
+
+//patternForm runs do with the pattern id posted, if any, and shows the page.
+func (app *application) patternForm(w http.ResponseWriter, r *http.Request, do func(id int) error) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	id := 0
+	if s := r.PostForm.Get("id"); s != "" {
+		if id, err = strconv.Atoi(s); err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = "No Message"
+	if err := do(id); err != nil {
+		app.u3.Message = err.Error()
+	}
+	app.render(w, r, "patterns.page.html", app.u3)
+}
+
+//shows the waveforms on the DACs.
+func (app *application) waves(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	app.render(w, r, "waves.page.html", app.u3)
+}
+
+//starts the waveform in the form, the points of an Arbitrary one come from
+//the CSV file uploaded with it.
+func (app *application) playWaveForm(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(1 << 20)
+	if err != nil && err != http.ErrNotMultipart {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	wf := Waveform{Shape: r.PostForm.Get("shape")}
+	wf.DAC, err = strconv.Atoi(r.PostForm.Get("dac"))
+	for _, f := range []struct {
+		name string
+		v    *float64
+	}{{"amplitude", &wf.Amplitude}, {"offset", &wf.Offset}, {"frequency", &wf.Frequency},
+		{"rate", &wf.Rate}, {"seconds", &wf.Seconds}} {
+		if err != nil {
+			break
+		}
+		if *f.v, err = strconv.ParseFloat(r.PostForm.Get(f.name), 64); err != nil {
+			err = fmt.Errorf("%s %q is not a number", f.name, r.PostForm.Get(f.name))
+		}
+	}
+	if err == nil && wf.Shape == "Arbitrary" {
+		file, _, ferr := r.FormFile("csv")
+		if ferr != nil {
+			err = fmt.Errorf("an arbitrary waveform needs a CSV file of volts")
+		} else {
+			wf.Points, err = parseWaveCSV(file)
+			file.Close()
+		}
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = "No Message"
+	if err == nil {
+		_, err = app.playWave(origin(r), wf)
+	}
+	if err != nil {
+		app.u3.Message = err.Error()
+	}
+	app.render(w, r, "waves.page.html", app.u3)
+}
+
+func (app *application) stopWaveForm(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	dac, err := strconv.Atoi(r.PostForm.Get("dac"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = "No Message"
+	if err := app.stopWave(dac); err != nil {
+		app.u3.Message = err.Error()
+	}
+	app.render(w, r, "waves.page.html", app.u3)
+}
+
+//shows the test sequences, the one named in the "name" query parameter in the
+//editor, and the last runs.
+func (app *application) sequences(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = "No Message"
+	td := &templateData{}
+	if name := r.URL.Query().Get("name"); name != "" {
+		text, err := app.loadSequence(name)
+		if err != nil {
+			app.u3.Message = err.Error()
+		} else {
+			td.SequenceName, td.SequenceText = name, text
+		}
+	}
+	app.renderSequences(w, r, td)
+}
+
+func (app *application) saveSequenceForm(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = "Saved"
+	td := &templateData{SequenceName: r.PostForm.Get("name"), SequenceText: r.PostForm.Get("text")}
+	if _, err := parseSequence(app.u3, td.SequenceText); err != nil {
+		app.u3.Message = err.Error()
+	} else if err := app.saveSequence(td.SequenceName, td.SequenceText); err != nil {
+		app.u3.Message = err.Error()
+	}
+	app.renderSequences(w, r, td)
+}
+
+//runs the sequence in the editor and shows its report.  The page does not
+//come back until the run is over.
+func (app *application) runSequenceForm(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	name, text := r.PostForm.Get("name"), r.PostForm.Get("text")
+	if name == "" {
+		name = "unnamed"
+	}
+	run, err := app.runSequence(origin(r), name, text)
+	app.lock(origin(r))
+	defer app.unlock()
+	if err != nil {
+		app.u3.Message = err.Error()
+		app.renderSequences(w, r, &templateData{SequenceName: name, SequenceText: text})
+		return
+	}
+	app.u3.Message = "No Message"
+	app.renderData(w, r, "report.page.html", &templateData{U3: app.u3, Run: run})
+}
+
+//shows the report of the run in the "id" query parameter.
+func (app *application) report(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	run := app.findRun(id)
+	if run == nil {
+		app.notFound(w)
+		return
+	}
+	app.renderData(w, r, "report.page.html", &templateData{U3: app.u3, Run: run})
+}
+
+//shows the sequences page with the editor in td.  Must be called with app.mu
+//held.
+func (app *application) renderSequences(w http.ResponseWriter, r *http.Request, td *templateData) {
+	names, err := app.listSequences()
+	if err != nil {
+		app.u3.Message = err.Error()
+	}
+	td.U3, td.Sequences = app.u3, names
+	app.renderData(w, r, "sequences.page.html", td)
+}
+
+//shows the login form, and logs in when it is posted.  The form posts back to
+//the same url so the next query parameter is kept.
+func (app *application) login(w http.ResponseWriter, r *http.Request) {
+	if app.users == nil {
+		http.Redirect(w, r, "/home", http.StatusSeeOther)
+		return
+	}
+	next := r.URL.Query().Get("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/home"
+	}
+	if r.Method != http.MethodPost {
+		app.render(w, r, "login.page.html", &U3{})
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	name := r.PostForm.Get("name")
+	u := app.users.check(name, r.PostForm.Get("password"))
+	if u == nil {
+		app.audit.Printf("%s failed to log in from %s", name, r.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		app.render(w, r, "login.page.html", &U3{Message: "Wrong user name or password"})
+		return
+	}
+	token, err := app.users.login(u)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit.Printf("%s (%s) logged in from %s", u.Name, u.Role, r.RemoteAddr)
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: token, Path: "/",
+		HttpOnly: true, Secure: app.tls, SameSite: http.SameSiteLaxMode})
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+//ends the session of the cookie.  It is not behind require so a viewer can
+//log out with a plain link.
+func (app *application) logout(w http.ResponseWriter, r *http.Request) {
+	if app.users == nil {
+		http.Redirect(w, r, "/home", http.StatusSeeOther)
+		return
+	}
+	if sess := app.users.session(r); sess != nil {
+		app.audit.Printf("%s (%s) logged out", sess.User, sess.Role)
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		app.users.logout(c.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+//lists the user accounts, for admins.
+func (app *application) usersPage(w http.ResponseWriter, r *http.Request) {
+	app.renderUsers(w, r, "No Message")
+}
+
+//adds a user or changes its role and password.
+func (app *application) setUserForm(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	msg := "Saved"
+	name, role := r.PostForm.Get("name"), r.PostForm.Get("role")
+	if err := app.users.setUser(name, role, r.PostForm.Get("password")); err != nil {
+		msg = err.Error()
+	}
+	app.renderUsers(w, r, msg)
+}
+
+func (app *application) deleteUserForm(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	msg := "Deleted"
+	name := r.PostForm.Get("name")
+	if name == who(r) {
+		msg = "You can not delete yourself"
+	} else if err := app.users.deleteUser(name); err != nil {
+		msg = err.Error()
+	}
+	app.renderUsers(w, r, msg)
+}
+
+func (app *application) renderUsers(w http.ResponseWriter, r *http.Request, msg string) {
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = msg
+	app.renderData(w, r, "users.page.html", &templateData{U3: app.u3, Users: app.users.list()})
+}
+
+//shows the transaction log, filtered by the query parameters, see
+//parseTxFilter.
+func (app *application) transactionsPage(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = "No Message"
+	f, err := parseTxFilter(r.URL.Query())
+	td := &templateData{U3: app.u3, TxFilter: f, TxQuery: template.URL(r.URL.Query().Encode())}
+	if err == nil {
+		td.Transactions = app.queryTransactions(f)
+	}
+	if err != nil {
+		app.u3.Message = err.Error()
+	}
+	app.renderData(w, r, "transactions.page.html", td)
+}
+
+//shows the fields of the packets given as send and rec in the query, a
+//command and the response to it or either one alone.  It does not touch
+//the device.
+func (app *application) decodePage(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = "No Message"
+	td := &templateData{U3: app.u3, DecodeSend: r.URL.Query().Get("send"), DecodeRec: r.URL.Query().Get("rec")}
+	var send []byte
+	var err error
+	if strings.TrimSpace(td.DecodeSend) != "" {
+		if send, err = jack.ParseBytes(td.DecodeSend); err != nil {
+			app.u3.Message = "Command: " + err.Error()
+		} else {
+			td.Frames = append(td.Frames, jack.DecodeSend(send))
+		}
+	}
+	if strings.TrimSpace(td.DecodeRec) != "" {
+		if rec, err := jack.ParseBytes(td.DecodeRec); err != nil {
+			app.u3.Message = "Response: " + err.Error()
+		} else {
+			td.Frames = append(td.Frames, jack.DecodeRec(rec, send))
+		}
+	}
+	app.renderData(w, r, "decode.page.html", td)
+}
+
+//charts the samples kept by -history for the channel and times in the query,
+//see parseHistoryQuery.  It does not touch the device.
+func (app *application) chartsPage(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+	if v.Get("channel") == "" {
+		v.Set("channel", "FIO4")
+	}
+	q, err := parseHistoryQuery(v)
+	var samples []Sample
+	if err == nil {
+		samples, q.Resolution, err = q.run(app.history)
+	}
+	app.lock(origin(r))
+	defer app.unlock()
+	app.u3.Message = "No Message"
+	if err != nil {
+		app.u3.Message = err.Error()
+	}
+	app.renderData(w, r, "charts.page.html", &templateData{U3: app.u3, Chart: newChart(q, samples)})
+}
+
+//downloads the transactions picked by the same query parameters as the page,
+//as CSV or, with format=json, as JSON lines like the -txlog file.
+func (app *application) exportTransactions(w http.ResponseWriter, r *http.Request) {
+	f, err := parseTxFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	txs, err := app.readTxLog(f)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"transactions.jsonl\"")
+		enc := json.NewEncoder(w)
+		for _, tx := range txs {
+			enc.Encode(tx)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"transactions.csv\"")
+	writeTransactionsCSV(w, txs)
+}
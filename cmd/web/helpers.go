@@ -4,37 +4,36 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 
-	"path/filepath"
+	"io/fs"
+	"path"
 	"runtime/debug"
+	"time"
+
+	"github.com/Saied74/labjack/pkg/jack"
 )
 
 // <+++++++++++++++++++++++ Template Processing +++++++++++++++++++++++++++>
 
 //This is straight out of Alex Edward's Let's Go book
-func newTemplateCache(dir string) (map[string]*template.Template, error) {
+//except the templates come from the html directory of fsys, see uiFiles.
+func newTemplateCache(fsys fs.FS) (map[string]*template.Template, error) {
 	cache := map[string]*template.Template{}
 
-	pages, err := filepath.Glob(filepath.Join(dir, "*.page.html"))
+	pages, err := fs.Glob(fsys, "html/*.page.html")
 	if err != nil {
 		return nil, err
 	}
 
 	for _, page := range pages {
-		name := filepath.Base(page)
-		ts, err := template.ParseFiles(page)
-		if err != nil {
-			return nil, err
-		}
-
-		ts, err = ts.ParseGlob(filepath.Join(dir, "*.layout.html"))
-		if err != nil {
-			return nil, err
-		}
-
-		ts, err = ts.ParseGlob(filepath.Join(dir, "*.partial.html"))
+		name := path.Base(page)
+		ts, err := template.ParseFS(fsys, page, "html/*.layout.html",
+			"html/*.partial.html")
 		if err != nil {
 			return nil, err
 		}
@@ -47,14 +46,29 @@ func newTemplateCache(dir string) (map[string]*template.Template, error) {
 //This is straight out of Alex Edward's Let's Go book
 func (app *application) render(w http.ResponseWriter, r *http.Request,
 	name string, u *U3) {
+	app.renderData(w, r, name, &templateData{U3: u})
+}
+
+//renderData renders a page with what belongs to the request in td, see
+//templateData.
+func (app *application) renderData(w http.ResponseWriter, r *http.Request,
+	name string, td *templateData) {
 	ts, ok := app.templateCache[name]
 	if !ok {
 		app.serverError(w, fmt.Errorf("The template %s does not exist",
 			name))
 		return
 	}
+	//the page is rendered from a copy of app.u3 so a page rendered by another
+	//request does not change under it.
+	data := *td
+	data.U3 = &U3{}
+	if td.U3 != nil {
+		*data.U3 = *td.U3
+	}
+	data.Session = sessionOf(r)
 	buf := new(bytes.Buffer)
-	err := ts.Execute(buf, u)
+	err := ts.Execute(buf, &data)
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -150,72 +164,222 @@ func (u *U3) pullIO(r url.Values) error {
 	return nil
 }
 
+/*
+pullNeg pulls the negative channel of each analog pin from the web form.  The
+choices are GND (31, single ended), Vref (30), the special range (32) or any
+other analog FIO or EIO pin (0-15) for a differential read.  High voltage pins
+can only be read single ended, so FIO0 through FIO3 are not on the form.
+*/
+func (u *U3) pullNeg(r url.Values) error {
+	var err error
+	pins := map[string]*Pin{}
+	for i := 0; i < 8; i++ {
+		pins[fmt.Sprintf("fioNeg%d", i)] = u.FIO[i]
+		pins[fmt.Sprintf("eioNeg%d", i)] = u.EIO[i]
+	}
+	for c, pin := range pins {
+		val, ok := r[c]
+		if !ok {
+			continue
+		}
+		neg, e := strconv.Atoi(val[0])
+		if e != nil {
+			err = fmt.Errorf("%s: %v", c, e)
+			continue
+		}
+		if e := u.setNeg(pin, neg); e != nil {
+			err = fmt.Errorf("%s: %v", c, e)
+		}
+	}
+	return err
+}
+
+//sets the negative channel of pin after checking neg is one of the choices
+//listed for pullNeg, and that pin can be read against it as in
+//jack.Calibration.AINVoltage.  Before the ConfigU3 is read it is not known
+//which pins are high voltage, the read refuses them then, see filterAIN.
+func (u *U3) setNeg(pin *Pin, neg int) error {
+	if hv, err := u.hv(); err == nil && hv {
+		for i, p := range u.FIO[:4] {
+			if p == pin && neg != jack.SENeg {
+				return fmt.Errorf("FIO%d is a high voltage input and can only be read single ended", i)
+			}
+		}
+	}
+	switch {
+	case neg == jack.SENeg || neg == jack.VrefNeg || neg == jack.SpecialNeg:
+	case neg >= 0 && neg < 8 && u.FIO[neg].AD == "Analog":
+	case neg >= 8 && neg < 16 && u.EIO[neg-8].AD == "Analog":
+	default:
+		return fmt.Errorf("negative channel %d is not an analog pin", neg)
+	}
+	pin.NegChannel = neg
+	return nil
+}
+
+//pullAcquisition pulls the LongSettling and QuickSample options of each analog
+//pin from the web form.  "1" turns the option on and "2" off.
+func (u *U3) pullAcquisition(r url.Values) error {
+	for i := 0; i < 8; i++ {
+		for port, pin := range map[string]*Pin{"fio": u.FIO[i], "eio": u.EIO[i]} {
+			if val, ok := r[fmt.Sprintf("%sLS%d", port, i)]; ok {
+				pin.LongSettling = val[0] == "1"
+			}
+			if val, ok := r[fmt.Sprintf("%sQS%d", port, i)]; ok {
+				pin.QuickSample = val[0] == "1"
+			}
+		}
+	}
+	return nil
+}
+
+//pullFilter pulls the number of samples, the filter and the EMA weight of each
+//analog pin from the web form.
+func (u *U3) pullFilter(r url.Values) error {
+	var err error
+	for i := 0; i < 8; i++ {
+		for port, pin := range map[string]*Pin{"fio": u.FIO[i], "eio": u.EIO[i]} {
+			if val, ok := r[fmt.Sprintf("%sN%d", port, i)]; ok {
+				n, e := strconv.Atoi(val[0])
+				if e == nil {
+					e = pin.setSamples(n)
+				}
+				if e != nil {
+					err = e
+				}
+			}
+			if val, ok := r[fmt.Sprintf("%sF%d", port, i)]; ok {
+				if e := pin.setFilter(val[0]); e != nil {
+					err = e
+				}
+			}
+			if val, ok := r[fmt.Sprintf("%sW%d", port, i)]; ok {
+				w, e := strconv.ParseFloat(val[0], 64)
+				if e == nil {
+					e = pin.setEMAWeight(w)
+				}
+				if e != nil {
+					err = e
+				}
+			}
+		}
+	}
+	return err
+}
+
+//pullChannels pulls the name, unit and scale of each pin from the channels
+//web form.  A pin whose scale does not check out keeps its old scale.
+func (u *U3) pullChannels(r url.Values) error {
+	var err error
+	for _, pin := range u.pins() {
+		if val, ok := r["name_"+pin.Label]; ok {
+			pin.Name = strings.TrimSpace(val[0])
+		}
+		if val, ok := r["unit_"+pin.Label]; ok {
+			pin.Unit = strings.TrimSpace(val[0])
+		}
+		kind, ok := r["kind_"+pin.Label]
+		if !ok {
+			continue
+		}
+		s, e := parseScaleParams(kind[0], r.Get("params_"+pin.Label))
+		if e != nil {
+			err = fmt.Errorf("%s: %v", pin.Label, e)
+			continue
+		}
+		pin.Scale = s
+	}
+	return err
+}
+
+//pullAlarm builds an alarm rule from the add alarm web form.  Fields that the
+//kind does not use may be left empty.
+func pullAlarm(r url.Values) (*AlarmRule, error) {
+	a := &AlarmRule{Pin: strings.ToUpper(r.Get("pin")), Kind: r.Get("kind"), Edge: r.Get("edge")}
+	nums := map[string]*float64{"low": &a.Low, "high": &a.High,
+		"hysteresis": &a.Hysteresis, "stuck": &a.StuckSeconds}
+	for name, p := range nums {
+		if r.Get(name) == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(r.Get(name), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q is not a number", name, r.Get(name))
+		}
+		*p = v
+	}
+	if r.Get("level") == "1" {
+		a.Level = 1
+	}
+	return a, nil
+}
+
 func (app *application) copyToWriteJack(op string) {
-	app.srData[op].byte11 = 0x00
+	app.srData[op].Byte11 = 0x00
 	for i, val := range app.u3.EIO {
 		if val.AD == "Analog" {
-			app.srData[op].byte11 = app.srData[op].byte11 | (1 << i)
+			app.srData[op].Byte11 = app.srData[op].Byte11 | (1 << i)
 		}
 	}
-	app.srData[op].byte10 = 0x00
+	app.srData[op].Byte10 = 0x00
 	for i, val := range app.u3.FIO {
 		if i > 3 && val.AD == "Analog" {
-			app.srData[op].byte10 = app.srData[op].byte10 | (1 << i)
+			app.srData[op].Byte10 = app.srData[op].Byte10 | (1 << i)
 		}
 	}
 }
 
 func (app *application) copyToWriteDirection(op string) {
-	app.srData[op].byte12 = 0x00
-	app.srData[op].byte9 = 0x00
+	app.srData[op].Byte12 = 0x00
+	app.srData[op].Byte9 = 0x00
 	for i, val := range app.u3.EIO {
 		if val.IO == "Output" {
-			app.srData[op].byte12 = app.srData[op].byte12 | (1 << i)
-			app.srData[op].byte9 = app.srData[op].byte9 | (1 << i)
+			app.srData[op].Byte12 = app.srData[op].Byte12 | (1 << i)
+			app.srData[op].Byte9 = app.srData[op].Byte9 | (1 << i)
 		}
 	}
-	app.srData[op].byte11 = 0x00
-	app.srData[op].byte8 = 0x00
+	app.srData[op].Byte11 = 0x00
+	app.srData[op].Byte8 = 0x00
 	for i, val := range app.u3.FIO {
 		if i > 3 && val.IO == "Output" {
-			app.srData[op].byte11 = app.srData[op].byte11 | (1 << i)
-			app.srData[op].byte8 = app.srData[op].byte8 | (1 << i)
+			app.srData[op].Byte11 = app.srData[op].Byte11 | (1 << i)
+			app.srData[op].Byte8 = app.srData[op].Byte8 | (1 << i)
 		}
 	}
-	app.srData[op].byte13 = 0x00
-	app.srData[op].byte10 = 0x00
+	app.srData[op].Byte13 = 0x00
+	app.srData[op].Byte10 = 0x00
 	for i, val := range app.u3.CIO {
 		if i < 4 && val.IO == "Output" {
-			app.srData[op].byte13 = app.srData[op].byte13 | (1 << i)
-			app.srData[op].byte10 = app.srData[op].byte10 | (1 << i)
+			app.srData[op].Byte13 = app.srData[op].Byte13 | (1 << i)
+			app.srData[op].Byte10 = app.srData[op].Byte10 | (1 << i)
 		}
 	}
 }
 
 func (app *application) copyToWirteDigitalOutput(op string) {
 
-	app.srData[op].byte12 = 0x00
-	app.srData[op].byte9 = 0x00
+	app.srData[op].Byte12 = 0x00
+	app.srData[op].Byte9 = 0x00
 	for i, val := range app.u3.EIO {
 		if val.IO == "Output" {
-			app.srData[op].byte12 = app.srData[op].byte12 | (byte(val.DigitalWrite) << i)
-			app.srData[op].byte9 = app.srData[op].byte9 | (1 << i)
+			app.srData[op].Byte12 = app.srData[op].Byte12 | (byte(val.DigitalWrite) << i)
+			app.srData[op].Byte9 = app.srData[op].Byte9 | (1 << i)
 		}
 	}
-	app.srData[op].byte11 = 0x00
-	app.srData[op].byte8 = 0x00
+	app.srData[op].Byte11 = 0x00
+	app.srData[op].Byte8 = 0x00
 	for i, val := range app.u3.FIO {
 		if i > 3 && val.IO == "Output" {
-			app.srData[op].byte11 = app.srData[op].byte11 | (byte(val.DigitalWrite) << i)
-			app.srData[op].byte8 = app.srData[op].byte8 | (1 << i)
+			app.srData[op].Byte11 = app.srData[op].Byte11 | (byte(val.DigitalWrite) << i)
+			app.srData[op].Byte8 = app.srData[op].Byte8 | (1 << i)
 		}
 	}
-	app.srData[op].byte13 = 0x00
-	app.srData[op].byte10 = 0x00
+	app.srData[op].Byte13 = 0x00
+	app.srData[op].Byte10 = 0x00
 	for i, val := range app.u3.CIO {
 		if i < 4 && val.IO == "Output" {
-			app.srData[op].byte13 = app.srData[op].byte13 | (byte(val.DigitalWrite) << i)
-			app.srData[op].byte10 = app.srData[op].byte10 | (1 << i)
+			app.srData[op].Byte13 = app.srData[op].Byte13 | (byte(val.DigitalWrite) << i)
+			app.srData[op].Byte10 = app.srData[op].Byte10 | (1 << i)
 		}
 	}
 }
@@ -258,3 +422,300 @@ func (u *U3) pullDigitalOutput(r url.Values) error {
 	// }
 	return nil
 }
+
+//reads the calibration constants of the selected device into app.u3.cal.
+//Must be called with app.mu held.
+func (app *application) readCalibration() error {
+	for blk := 0; blk < jack.CalBlocks; blk++ {
+		app.srData[jack.ReadMem].Byte7 = byte(blk)
+		if err := app.u3SendRec(jack.ReadMem, 0x00); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//<++++++++++++++++++++++++++   acquisition   +++++++++++++++++++++++++++++++>
+
+//acquire reads the state of the digital pins, all the analog pins and the
+//temperature of the selected device into app.u3.  It returns the error of the
+//port state read, which fails when the device can not be reached, and then of
+//the first analog or temperature read that failed.  A pin that could not be
+//read is left out of what works on the acquisition, see Pin.ReadFailed.  Must
+//be called with app.mu held.
+func (app *application) acquire() error {
+	if !app.u3.cal.Loaded() {
+		app.readCalibration()
+	}
+	if app.u3.config == nil {
+		//for hv, without the flash setting the ConfigU3 response also holds
+		if c, err := app.readConfig(app.u3.DeviceNumber); err == nil {
+			app.u3.config = &c
+		}
+	}
+	if err := app.u3SendRec(jack.PortStateRead, 0x00); err != nil {
+		app.metrics.acquireFailed++
+		return err
+	}
+	err := app.u3SendRec(jack.TempSense, 0x00)
+	if err != nil {
+		app.u3.temperatureRead = false
+	}
+	for ch, pin := range app.u3.pins()[:16] {
+		if pin.AD != "Analog" {
+			continue
+		}
+		if e := app.readAnalog(pin, ch); e != nil && err == nil {
+			err = e
+		}
+	}
+	t := time.Now()
+	app.metrics.acquired = t
+	app.afterAcquire(t)
+	if err != nil {
+		app.metrics.acquireFailed++
+	}
+	return err
+}
+
+//afterAcquire runs everything that works on a fresh acquisition.
+func (app *application) afterAcquire(t time.Time) {
+	app.detectEdges(t)
+	app.evaluateAlarms(t)
+	app.evaluateRules(t)
+	app.publishReadings()
+	app.recordInflux(t)
+	app.recordHistory(t)
+}
+
+//poll acquires every interval in the background so the alarms keep running
+//with no page open.
+func (app *application) poll(interval time.Duration) {
+	for range time.Tick(interval) {
+		app.lock("poll")
+		app.acquire()
+		app.unlock()
+	}
+}
+
+//readAnalog reads pin (analog channel ch) pin.Samples times and filters the
+//reads.  More than one read is batched, jack.MaxAINBatch to a packet.  When a
+//read fails the pin is marked ReadFailed and none of the reads are used.
+func (app *application) readAnalog(pin *Pin, ch int) error {
+	pin.samples = pin.samples[:0]
+	var err error
+	if pin.Samples <= 1 {
+		app.srData[jack.AIN].Byte8 = pin.ainOptions(ch)
+		app.srData[jack.AIN].Byte9 = byte(pin.NegChannel)
+		err = app.u3SendRec(jack.AIN, 0x00)
+	}
+	for left := pin.Samples; err == nil && pin.Samples > 1 && left > 0; left -= jack.MaxAINBatch {
+		n := left
+		if n > jack.MaxAINBatch {
+			n = jack.MaxAINBatch
+		}
+		app.srData[jack.AINBatch].SetAINBatch(n)
+		app.srData[jack.AINBatch].Byte8 = pin.ainOptions(ch)
+		app.srData[jack.AINBatch].Byte9 = byte(pin.NegChannel)
+		err = app.u3SendRec(jack.AINBatch, 0x00)
+	}
+	pin.ReadFailed = err != nil
+	if err != nil {
+		pin.samples = pin.samples[:0]
+		pin.ValueText = "read failed"
+		return fmt.Errorf("reading %s: %v", pin.Label, err)
+	}
+	app.u3.filterAIN(pin, ch)
+	return nil
+}
+
+//<+++++++++++++++++++++++++++   writing outputs   +++++++++++++++++++++++++++>
+
+//setDigital sets the digital output named name to level (0 or 1) with a Bit
+//State Write, the other outputs are not touched.  Must be called with app.mu
+//held.
+func (app *application) setDigital(name string, level int) error {
+	pin, ch, err := app.u3.pinByName(name)
+	if err != nil {
+		return err
+	}
+	if pin.AD != "Digital" || pin.IO != "Output" {
+		return fmt.Errorf("%s is not a digital output", name)
+	}
+	if level != 0 && level != 1 {
+		return fmt.Errorf("%d is not a level, use 0 or 1", level)
+	}
+	return app.writeBit(ch, level)
+}
+
+//writePort sets the digital outputs in levels with one Port State Write, the
+//other outputs are written with the levels they have.  DigitalWrite is left as
+//it was when the write fails.  Must be called with app.mu held.
+func (app *application) writePort(levels map[*Pin]int) error {
+	was := map[*Pin]int{}
+	for pin, level := range levels {
+		was[pin] = pin.DigitalWrite
+		pin.DigitalWrite = level
+	}
+	app.copyToWirteDigitalOutput(jack.PortStateWrite)
+	if err := app.u3SendRec(jack.PortStateWrite, 0x01); err != nil {
+		for pin, level := range was {
+			pin.DigitalWrite = level
+		}
+		return err
+	}
+	return nil
+}
+
+/*
+writeBit, writeBitDir and readBit work on the one digital pin on channel ch
+with the bit commands, so the rest of the pins are left alone where the port
+commands (copyToWirteDigitalOutput, copyToWriteDirection) write all of them.
+The callers check the pin is digital.  They must be called with app.mu held.
+*/
+
+//writeBit sets the pin to level, the U3 makes it an output if it was not.
+func (app *application) writeBit(ch, level int) error {
+	app.srData[jack.BitStateWrite].SetBit(ch, level)
+	if err := app.u3SendRec(jack.BitStateWrite, 0x00); err != nil {
+		return err
+	}
+	pin := app.u3.pins()[ch]
+	pin.DigitalWrite = level
+	pin.IO = "Output"
+	return nil
+}
+
+//writeBitDir sets the pin to io, Input or Output.
+func (app *application) writeBitDir(ch int, io string) error {
+	dir := 0
+	switch io {
+	case "Output":
+		dir = 1
+	case "Input":
+	default:
+		return fmt.Errorf("%q is not a direction, use Input or Output", io)
+	}
+	app.srData[jack.BitDirWrite].SetBit(ch, dir)
+	if err := app.u3SendRec(jack.BitDirWrite, 0x00); err != nil {
+		return err
+	}
+	app.u3.pins()[ch].IO = io
+	return nil
+}
+
+//readBit reads the direction and the state of the pin into it.
+func (app *application) readBit(ch int) error {
+	for _, op := range []string{jack.BitDirRead, jack.BitStateRead} {
+		app.srData[op].SetBit(ch, 0)
+		if err := app.u3SendRec(op, 0x00); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//writeConfig writes the analog/digital setting and the directions of the
+//digital pins in app.u3 to the device, the way configure does.  Must be called
+//with app.mu held.
+func (app *application) writeConfig() error {
+	app.copyToWriteJack(jack.ConfigIO)
+	if err := app.u3SendRec(jack.ConfigIO, 0x0C); err != nil {
+		return err
+	}
+	app.copyToWriteDirection(jack.PortDirWrite)
+	return app.u3SendRec(jack.PortDirWrite, 0x01)
+}
+
+//setMode sets the pin on channel ch Analog or Digital, and Input or Output
+//when digital, in app.u3 only, writeConfig writes it to the device.  FIO0-3
+//are taken as analog only as on the U3-HV, see copyToWriteJack, and CIO0-3
+//are digital only.
+func (u *U3) setMode(ch int, ad, io string) error {
+	pin := u.pins()[ch]
+	switch {
+	case ad != "Analog" && ad != "Digital":
+		return fmt.Errorf("%q is not Analog or Digital", ad)
+	case ad == "Digital" && io != "Input" && io != "Output":
+		return fmt.Errorf("%q is not Input or Output", io)
+	case ch < 4 && ad != "Analog":
+		return fmt.Errorf("%s is analog only", pin.Label)
+	case ch >= 16 && ad != "Digital":
+		return fmt.Errorf("%s is digital only", pin.Label)
+	}
+	pin.AD = ad
+	if ad == "Digital" {
+		pin.IO = io
+	}
+	return nil
+}
+
+//setDAC sets DAC output dac (0 or 1) to volts, 0 to 5.  Must be called with
+//app.mu held.
+func (app *application) setDAC(dac int, volts float64) error {
+	if dac != 0 && dac != 1 {
+		return fmt.Errorf("there is no DAC%d", dac)
+	}
+	if math.IsNaN(volts) || volts < 0 || volts > 5 {
+		return fmt.Errorf("%g volts, the DACs go from 0 to 5", volts)
+	}
+	app.srData[jack.DAC].SetDAC(dac, app.u3.cal.DACValue(dac, volts))
+	if err := app.u3SendRec(jack.DAC, 0x00); err != nil {
+		return err
+	}
+	app.u3.DAC[dac] = volts
+	return nil
+}
+
+//<++++++++++++++++++   finding and identifying devices   ++++++++++++++++++++>
+
+const (
+	identifyBlinks = 10                     //number of off/on cycles
+	blinkPeriod    = 250 * time.Millisecond //time between LED toggles
+)
+
+//scanDevices reads the ConfigU3 of every U3 on the bus into app.u3.Devices.
+//Must be called with app.mu held.
+func (app *application) scanDevices() {
+	devices := []*DeviceEntry{}
+	count := u3DevCount()
+	for n := 1; n <= count; n++ {
+		entry := &DeviceEntry{Number: n}
+		c, err := app.readConfig(n)
+		if err != nil {
+			entry.DeviceName = err.Error()
+		} else {
+			entry.SerialNumber = c.SerialNumber
+			entry.DeviceName = c.DeviceName
+			entry.LocalID = c.LocalID
+		}
+		devices = append(devices, entry)
+	}
+	app.u3.Devices = devices
+	if count == 0 {
+		app.u3.Message = "No U3 found on the USB bus"
+	}
+}
+
+//blinkLED toggles the LED on device devNum so it can be found on the bench.
+//app.mu is only held for each toggle so the pages stay responsive while it
+//blinks.  The LED is left on at the end since that is its power up state.
+func (app *application) blinkLED(from string, devNum int, count int) {
+	for i := 0; i < 2*count; i++ {
+		app.lock(from)
+		app.srData[jack.LED].Byte8 = byte(i % 2)
+		err := app.u3SendRecDev(devNum, jack.LED, 0x00)
+		if err == nil && devNum == app.u3.DeviceNumber {
+			app.u3.LED = "On"
+			if i%2 == 0 {
+				app.u3.LED = "Off"
+			}
+		}
+		app.unlock()
+		if err != nil {
+			app.errorLog.Printf("identify device %d: %v", devNum, err)
+			return
+		}
+		time.Sleep(blinkPeriod)
+	}
+}
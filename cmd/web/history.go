@@ -0,0 +1,471 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Saied74/labjack/pkg/jack"
+)
+
+/*
+History file keeps the samples of every acquisition on disk, in the -history
+directory, so they can be looked at later on the charts page or through
+/api/history.  The value kept is the one the alarms look at, the scaled value
+of analog pins and the state of digital pins, plus the temperature of the U3.
+There is no database, the samples go in append only segment files:
+
+	raw-2006010215.seg  every sample of one hour, kept for -historyraw (24h)
+	min-20060102.seg    one minute aggregates of one day, kept for -historymin (30 days)
+
+with the hour and day in UTC.  A raw record is 17 bytes, the unix nanoseconds
+(8), the channel (1) and the value as a float64 (8).  A minute record is 37
+bytes, the unix seconds of the start of the minute (8), the channel (1), the
+number of samples (4) and their min, max and mean as float64s (24).  All are
+little endian.  The channels are numbered like jack.Channel, FIO0-7 are 0-7,
+EIO0-7 are 8-15 and CIO0-3 are 16-19, and 20 is the temperature.
+
+The minute being aggregated is kept in memory and written when the next one
+starts, so a restart loses at most that minute of aggregates, the raw samples
+are all on disk.  A record cut short by a crash is cut off when the segment is
+opened again.  Segments past their retention are removed once a minute.
+*/
+
+const (
+	historyTemperature = 20 //channel number of the temperature
+	rawRecord          = 17
+	minuteRecord       = 37
+	defaultHistorySpan = time.Hour //of a query with no since
+	maxHistorySpan     = 400 * 24 * time.Hour
+)
+
+//Sample is one raw sample, or one minute aggregate with Value the mean.  For
+//raw samples Count is 1 and Min and Max are Value.
+type Sample struct {
+	Time  time.Time
+	Value float64
+	Min   float64
+	Max   float64
+	Count int
+}
+
+type aggregate struct {
+	count    int
+	min, max float64
+	sum      float64
+}
+
+type history struct {
+	mu        sync.Mutex
+	dir       string
+	raw       time.Duration //retention of the raw samples
+	minutes   time.Duration //and of the minute aggregates
+	rawFile   *os.File
+	minFile   *os.File
+	minute    int64 //unix seconds of the minute in acc
+	acc       map[int]*aggregate
+	lastPrune time.Time
+}
+
+func openHistory(dir string, raw, minutes time.Duration) (*history, error) {
+	if raw <= 0 || minutes <= 0 {
+		return nil, fmt.Errorf("-historyraw and -historymin must be over 0")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	h := &history{dir: dir, raw: raw, minutes: minutes, acc: map[int]*aggregate{}}
+	return h, h.prune(time.Now())
+}
+
+//historyChannel returns the channel number of a pin name or "temperature".
+func historyChannel(name string) (int, error) {
+	if strings.EqualFold(name, "temperature") {
+		return historyTemperature, nil
+	}
+	return jack.Channel(name)
+}
+
+func historyChannelName(ch int) string {
+	if ch == historyTemperature {
+		return "temperature"
+	}
+	return jack.ChannelName(ch)
+}
+
+func rawSegment(t time.Time) string {
+	return "raw-" + t.UTC().Format("2006010215") + ".seg"
+}
+
+func minuteSegment(t time.Time) string {
+	return "min-" + t.UTC().Format("20060102") + ".seg"
+}
+
+//appendTo writes b to segment name, opening it in f first when f is not
+//already it.  A partial record left at the end by a crash is cut off.
+func (h *history) appendTo(f **os.File, name string, size int, b []byte) error {
+	path := filepath.Join(h.dir, name)
+	if *f == nil || (*f).Name() != path {
+		if *f != nil {
+			(*f).Close()
+			*f = nil
+		}
+		nf, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+		if err != nil {
+			return err
+		}
+		st, err := nf.Stat()
+		if err == nil {
+			err = nf.Truncate(st.Size() - st.Size()%int64(size))
+		}
+		if err != nil {
+			nf.Close()
+			return err
+		}
+		*f = nf
+	}
+	_, err := (*f).Write(b)
+	return err
+}
+
+//record writes the values (by channel) sampled at t.
+func (h *history) record(t time.Time, values map[int]float64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	minute := t.Unix() - t.Unix()%60
+	if minute != h.minute {
+		if err := h.flushMinute(); err != nil {
+			return err
+		}
+		h.minute = minute
+	}
+	b := make([]byte, 0, rawRecord*len(values))
+	for ch, v := range values {
+		b = binary.LittleEndian.AppendUint64(b, uint64(t.UnixNano()))
+		b = append(b, byte(ch))
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+		a := h.acc[ch]
+		if a == nil {
+			a = &aggregate{min: v, max: v}
+			h.acc[ch] = a
+		}
+		a.count++
+		a.sum += v
+		a.min = math.Min(a.min, v)
+		a.max = math.Max(a.max, v)
+	}
+	if err := h.appendTo(&h.rawFile, rawSegment(t), rawRecord, b); err != nil {
+		return err
+	}
+	if time.Since(h.lastPrune) >= time.Minute {
+		return h.prune(t)
+	}
+	return nil
+}
+
+//flushMinute writes the aggregates of h.minute.  Must be called with h.mu
+//held.
+func (h *history) flushMinute() error {
+	if len(h.acc) == 0 {
+		return nil
+	}
+	b := []byte{}
+	for ch, a := range h.acc {
+		b = binary.LittleEndian.AppendUint64(b, uint64(h.minute))
+		b = append(b, byte(ch))
+		b = binary.LittleEndian.AppendUint32(b, uint32(a.count))
+		for _, v := range []float64{a.min, a.max, a.sum / float64(a.count)} {
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+		}
+	}
+	h.acc = map[int]*aggregate{}
+	return h.appendTo(&h.minFile, minuteSegment(time.Unix(h.minute, 0)), minuteRecord, b)
+}
+
+//prune removes the segments that are all past their retention.  Must be
+//called with h.mu held.
+func (h *history) prune(now time.Time) error {
+	h.lastPrune = now
+	names, err := filepath.Glob(filepath.Join(h.dir, "*.seg"))
+	if err != nil {
+		return err
+	}
+	for _, path := range names {
+		name := filepath.Base(path)
+		var end time.Time
+		if t, err := time.Parse("raw-2006010215.seg", name); err == nil {
+			end = t.Add(time.Hour).Add(h.raw)
+		} else if t, err := time.Parse("min-20060102.seg", name); err == nil {
+			end = t.Add(24 * time.Hour).Add(h.minutes)
+		} else {
+			continue
+		}
+		if end.Before(now) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+query returns the samples of channel ch from since to until, raw or by the
+minute as resolution says.  With resolution "" it is raw when since is
+within the raw retention and minute when it is not.  The minute still being
+aggregated is in the minute results.
+
+The segments are read without h.mu so the recording does not wait on a long
+query, a record still being written is left off the end of what is read.
+*/
+func (h *history) query(ch int, since, until time.Time, resolution string) ([]Sample, string, error) {
+	h.mu.Lock()
+	minute, a := h.minute, h.acc[ch]
+	var acc aggregate
+	if a != nil {
+		acc = *a
+	}
+	h.mu.Unlock()
+	//nothing is kept from after now either
+	if now := time.Now(); until.After(now) {
+		until = now
+	}
+	if resolution == "" {
+		resolution = "raw"
+		if since.Before(time.Now().Add(-h.raw)) {
+			resolution = "minute"
+		}
+	}
+	//nothing is kept from before first, so the segments are not looked for
+	first := func(kept time.Duration) time.Time {
+		if oldest := time.Now().Add(-kept - 24*time.Hour); since.Before(oldest) {
+			return oldest
+		}
+		return since
+	}
+	samples := []Sample{}
+	switch resolution {
+	case "raw":
+		for t := first(h.raw).UTC().Truncate(time.Hour); !t.After(until); t = t.Add(time.Hour) {
+			b, err := ioutil.ReadFile(filepath.Join(h.dir, rawSegment(t)))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, "", err
+			}
+			for ; len(b) >= rawRecord; b = b[rawRecord:] {
+				at := time.Unix(0, int64(binary.LittleEndian.Uint64(b)))
+				if int(b[8]) != ch || at.Before(since) || at.After(until) {
+					continue
+				}
+				v := math.Float64frombits(binary.LittleEndian.Uint64(b[9:]))
+				samples = append(samples, Sample{Time: at, Value: v, Min: v, Max: v, Count: 1})
+			}
+		}
+	case "minute":
+		for t := first(h.minutes).UTC().Truncate(24 * time.Hour); !t.After(until); t = t.Add(24 * time.Hour) {
+			b, err := ioutil.ReadFile(filepath.Join(h.dir, minuteSegment(t)))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, "", err
+			}
+			for ; len(b) >= minuteRecord; b = b[minuteRecord:] {
+				at := time.Unix(int64(binary.LittleEndian.Uint64(b)), 0)
+				if int(b[8]) != ch || at.Before(since.Truncate(time.Minute)) || at.After(until) {
+					continue
+				}
+				f := func(i int) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(b[i:])) }
+				samples = append(samples, Sample{Time: at, Value: f(29), Min: f(13), Max: f(21),
+					Count: int(binary.LittleEndian.Uint32(b[9:]))})
+			}
+		}
+		at := time.Unix(minute, 0)
+		if acc.count > 0 && !at.After(until) && !at.Before(since.Truncate(time.Minute)) {
+			samples = append(samples, Sample{Time: at, Value: acc.sum / float64(acc.count), Min: acc.min,
+				Max: acc.max, Count: acc.count})
+		}
+	default:
+		return nil, "", fmt.Errorf("resolution %q is not raw or minute", resolution)
+	}
+	//records of one acquisition are in map order, and a segment can be
+	//written out of order when the clock is set back
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples, resolution, nil
+}
+
+//recordHistory writes the value of every pin in use and the temperature.
+//Must be called with app.mu held.
+func (app *application) recordHistory(t time.Time) {
+	if app.history == nil {
+		return
+	}
+	values := map[int]float64{}
+	for ch, pin := range app.u3.pins() {
+		if v, _, ok := pin.pinValue(); ok {
+			values[ch] = v
+		}
+	}
+	if app.u3.temperatureRead {
+		values[historyTemperature] = app.u3.Temperature
+	}
+	if err := app.history.record(t, values); err != nil {
+		app.errorLog.Printf("history: %v", err)
+	}
+}
+
+//<++++++++++++++++++++++++++   queries over http   ++++++++++++++++++++++++++>
+
+//HistoryQuery is what the query parameters channel, since, until
+//(2006-01-02T15:04 in local time, or RFC 3339) and resolution ask for.  Until
+//is at most now and since at most maxHistorySpan before it.
+type HistoryQuery struct {
+	Channel    string
+	Since      time.Time
+	Until      time.Time
+	Resolution string //raw, minute or "" to pick by Since
+}
+
+func parseHistoryQuery(v url.Values) (HistoryQuery, error) {
+	q := HistoryQuery{Channel: strings.ToUpper(v.Get("channel")), Resolution: v.Get("resolution"),
+		Until: time.Now()}
+	if q.Channel == "TEMPERATURE" {
+		q.Channel = "temperature"
+	}
+	if _, err := historyChannel(q.Channel); err != nil {
+		return q, err
+	}
+	var err error
+	if s := v.Get("until"); s != "" {
+		if q.Until, err = parseFormTime(s); err != nil {
+			return q, fmt.Errorf("until %v", err)
+		}
+	}
+	if now := time.Now(); q.Until.After(now) {
+		q.Until = now
+	}
+	q.Since = q.Until.Add(-defaultHistorySpan)
+	if s := v.Get("since"); s != "" {
+		if q.Since, err = parseFormTime(s); err != nil {
+			return q, fmt.Errorf("since %v", err)
+		}
+	}
+	switch {
+	case q.Since.After(q.Until):
+		return q, fmt.Errorf("since %s is after until %s", formTime(q.Since), formTime(q.Until))
+	case q.Until.Sub(q.Since) > maxHistorySpan:
+		return q, fmt.Errorf("since to until is over %d days", maxHistorySpan/(24*time.Hour))
+	}
+	return q, nil
+}
+
+//parseFormTime reads a time from a form or query, 2006-01-02T15:04 in local
+//time as the datetime-local inputs give it, or RFC 3339.
+func parseFormTime(s string) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02T15:04", s, time.Local)
+	if err != nil {
+		if t, err = time.Parse(time.RFC3339, s); err != nil {
+			return t, fmt.Errorf("%q is not a time like 2006-01-02T15:04", s)
+		}
+	}
+	return t, nil
+}
+
+//run returns the samples asked for and the resolution they are at.
+func (q HistoryQuery) run(h *history) ([]Sample, string, error) {
+	if h == nil {
+		return nil, "", fmt.Errorf("no history is kept, start with -history")
+	}
+	ch, _ := historyChannel(q.Channel)
+	return h.query(ch, q.Since, q.Until, q.Resolution)
+}
+
+//apiHistory returns the samples picked by the query parameters, see
+//parseHistoryQuery, for example /api/history?channel=FIO4&since=2024-05-01T08:00
+func (app *application) apiHistory(w http.ResponseWriter, r *http.Request) {
+	q, err := parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	samples, resolution, err := q.run(app.history)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Resolution = resolution
+	app.writeJSON(w, struct {
+		HistoryQuery
+		Samples []Sample
+	}{q, samples})
+}
+
+//<++++++++++++++++++++++++++++   the charts page   +++++++++++++++++++++++++++>
+
+const (
+	chartWidth  = 800
+	chartHeight = 300
+)
+
+//Chart is the charts page, the samples drawn as SVG polylines in a
+//chartWidth by chartHeight box.
+type Chart struct {
+	Query     HistoryQuery
+	Channels  []string //to pick from
+	SinceText string   //for the datetime-local inputs
+	UntilText string
+	Samples   int
+	Line      string //points of the values
+	MinLine   string //and of the min and max of minute aggregates
+	MaxLine   string
+	Low       float64 //value at the bottom of the box
+	High      float64 //and at the top
+	Width     int
+	Height    int
+}
+
+func newChart(q HistoryQuery, samples []Sample) *Chart {
+	c := &Chart{Query: q, Samples: len(samples), Width: chartWidth, Height: chartHeight,
+		SinceText: q.Since.Local().Format("2006-01-02T15:04"),
+		UntilText: q.Until.Local().Format("2006-01-02T15:04")}
+	for ch := 0; ch <= historyTemperature; ch++ {
+		c.Channels = append(c.Channels, historyChannelName(ch))
+	}
+	if len(samples) == 0 {
+		return c
+	}
+	c.Low, c.High = samples[0].Min, samples[0].Max
+	for _, s := range samples {
+		c.Low, c.High = math.Min(c.Low, s.Min), math.Max(c.High, s.Max)
+	}
+	if c.High == c.Low {
+		c.Low, c.High = c.Low-1, c.High+1
+	}
+	span := q.Until.Sub(q.Since)
+	point := func(t time.Time, v float64) string {
+		x := float64(chartWidth) * float64(t.Sub(q.Since)) / float64(span)
+		y := float64(chartHeight) * (c.High - v) / (c.High - c.Low)
+		return fmt.Sprintf("%.1f,%.1f ", x, y)
+	}
+	var line, min, max strings.Builder
+	for _, s := range samples {
+		line.WriteString(point(s.Time, s.Value))
+		if q.Resolution == "minute" {
+			min.WriteString(point(s.Time, s.Min))
+			max.WriteString(point(s.Time, s.Max))
+		}
+	}
+	c.Line, c.MinLine, c.MaxLine = line.String(), min.String(), max.String()
+	return c
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//the start of a minute a few minutes back, so everything recorded after it
+//is before now and inside the retention.
+func historyStart() time.Time {
+	return time.Now().Truncate(time.Minute).Add(-5 * time.Minute)
+}
+
+func testHistory(t *testing.T, dir string) *history {
+	t.Helper()
+	h, err := openHistory(dir, 24*time.Hour, 30*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.closeFiles() })
+	return h
+}
+
+//closeFiles closes the open segments the way a crash would leave them.
+func (h *history) closeFiles() {
+	for _, f := range []**os.File{&h.rawFile, &h.minFile} {
+		if *f != nil {
+			(*f).Close()
+			*f = nil
+		}
+	}
+}
+
+func checkSamples(t *testing.T, name string, got, want []Sample) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got %d samples %v, want %d", name, len(got), got, len(want))
+		return
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if !g.Time.Equal(w.Time) || g.Value != w.Value || g.Min != w.Min || g.Max != w.Max || g.Count != w.Count {
+			t.Errorf("%s: sample %d is %+v, want %+v", name, i, g, w)
+		}
+	}
+}
+
+func rawSample(t time.Time, v float64) Sample {
+	return Sample{Time: t, Value: v, Min: v, Max: v, Count: 1}
+}
+
+func TestHistoryRoundTrip(t *testing.T) {
+	m0 := historyStart()
+	at := func(s int) time.Time { return m0.Add(time.Duration(s) * time.Second) }
+	h := testHistory(t, t.TempDir())
+	for _, r := range []struct {
+		s      int
+		values map[int]float64
+	}{
+		{0, map[int]float64{0: 1, historyTemperature: 25.5}},
+		{10, map[int]float64{0: 3, historyTemperature: 26.5}},
+		{70, map[int]float64{0: -2}},
+		{130, map[int]float64{0: 4}}, //the minute still in memory
+	} {
+		if err := h.record(at(r.s), r.values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name       string
+		ch         int
+		since      time.Time
+		until      time.Time
+		resolution string
+		want       []Sample
+	}{
+		{"raw", 0, m0, time.Now(), "raw", []Sample{rawSample(at(0), 1), rawSample(at(10), 3), rawSample(at(70), -2), rawSample(at(130), 4)}},
+		{"raw temperature", historyTemperature, m0, time.Now(), "raw", []Sample{rawSample(at(0), 25.5), rawSample(at(10), 26.5)}},
+		{"raw unused channel", 5, m0, time.Now(), "raw", []Sample{}},
+		{"raw span", 0, at(5), at(80), "raw", []Sample{rawSample(at(10), 3), rawSample(at(70), -2)}},
+		{"raw until in the future", 0, at(100), time.Now().Add(time.Hour), "raw", []Sample{rawSample(at(130), 4)}},
+		{"minute", 0, m0, time.Now(), "minute", []Sample{
+			{Time: at(0), Value: 2, Min: 1, Max: 3, Count: 2},
+			{Time: at(60), Value: -2, Min: -2, Max: -2, Count: 1},
+			{Time: at(120), Value: 4, Min: 4, Max: 4, Count: 1},
+		}},
+		{"minute temperature", historyTemperature, m0, time.Now(), "minute", []Sample{
+			{Time: at(0), Value: 26, Min: 25.5, Max: 26.5, Count: 2},
+		}},
+		{"minute since inside a minute", 0, at(65), at(100), "minute", []Sample{
+			{Time: at(60), Value: -2, Min: -2, Max: -2, Count: 1},
+		}},
+		{"recent is raw", 0, at(100), time.Now(), "", []Sample{rawSample(at(130), 4)}},
+	}
+	for _, tt := range tests {
+		got, _, err := h.query(tt.ch, tt.since, tt.until, tt.resolution)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		checkSamples(t, tt.name, got, tt.want)
+	}
+	if _, _, err := h.query(0, m0, time.Now(), "hourly"); err == nil {
+		t.Error("resolution hourly: no error")
+	}
+}
+
+//a record cut short by a crash is cut off when the segment is opened again,
+//so the records written after it are read back right.
+func TestHistoryTruncatedRecord(t *testing.T) {
+	m0 := historyStart()
+	at := func(s int) time.Time { return m0.Add(time.Duration(s) * time.Second) }
+	tests := []struct {
+		name    string
+		segment string
+		partial int
+	}{
+		{"raw 1 byte", rawSegment(at(60)), 1},
+		{"raw 8 bytes", rawSegment(at(60)), 8},
+		{"raw 16 bytes", rawSegment(at(60)), rawRecord - 1},
+		{"minute 1 byte", minuteSegment(at(60)), 1},
+		{"minute 36 bytes", minuteSegment(at(60)), minuteRecord - 1},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		h := testHistory(t, dir)
+		if err := h.record(at(0), map[int]float64{0: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.record(at(60), map[int]float64{0: 2}); err != nil {
+			t.Fatal(err)
+		}
+		h.closeFiles()
+		f, err := os.OpenFile(filepath.Join(dir, tt.segment), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(bytes.Repeat([]byte{0xFF}, tt.partial))
+		f.Close()
+
+		h = testHistory(t, dir)
+		for _, s := range []int{61, 120} {
+			if err := h.record(at(s), map[int]float64{0: float64(s)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, _, err := h.query(0, m0, time.Now(), "raw")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		checkSamples(t, tt.name+" raw", got, []Sample{rawSample(at(0), 1), rawSample(at(60), 2), rawSample(at(61), 61), rawSample(at(120), 120)})
+		got, _, err = h.query(0, m0, time.Now(), "minute")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		checkSamples(t, tt.name+" minute", got, []Sample{
+			{Time: at(0), Value: 1, Min: 1, Max: 1, Count: 1},
+			{Time: at(60), Value: 61, Min: 61, Max: 61, Count: 1}, //the 2 was in memory
+			{Time: at(120), Value: 120, Min: 120, Max: 120, Count: 1},
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Influx file holds a sink that writes every acquisition to a time series
+database as InfluxDB line protocol over HTTP.  It is turned on with -influx and
+the whole write url, so it works with InfluxDB 1.x
+
+	http://localhost:8086/write?db=lab&precision=ns
+
+and 2.x (with -influxtoken, sent as "Authorization: Token ...")
+
+	http://localhost:8086/api/v2/write?org=lab&bucket=u3&precision=ns
+
+and anything else that takes line protocol.  Each pin in use is one line, with
+the value the alarms look at (scaled value for analog pins, state for digital
+pins) and for analog pins the filtered volts and the raw read too:
+
+	u3,channel=FIO4,name=Tank,serial=320012345,unit=C value=21.5,volts=1.215,raw=40000i 1700000000000000000
+
+The name tag is left out when the pin has no name.  The temperature of the U3
+is the line with channel=temperature.  Lines are queued and sent in batches of
+-influxbatch lines, or every -influxflush when fewer come in, by one goroutine
+so a slow database never holds up an acquisition.
+
+A batch the database can not be reached for is tried influxRetries times and
+then, with -influxspool, written to a file in that directory.  The spooled
+files are sent oldest first before anything new once the database answers
+again.  A batch the database turns down (a 4xx other than 429, bad line
+protocol or a missing bucket) would be turned down again, so it is not retried
+and its spool file is renamed to .rejected to be looked at by hand.  Without
+-influxspool unsent batches are dropped and logged.
+*/
+
+const (
+	influxQueue   = 10000 //lines waiting to be batched, more are dropped
+	influxRetries = 3
+	influxBackoff = time.Second //doubled after each try
+	influxTimeout = 10 * time.Second
+)
+
+type influxSink struct {
+	url         string
+	token       string
+	measurement string
+	spool       string //directory, "" for no spooling
+	batch       int
+	flush       time.Duration
+	client      *http.Client
+	lines       chan string
+	backoff     time.Duration
+}
+
+func newInfluxSink(writeURL, token, measurement, spool string, batch int, flush time.Duration) (*influxSink, error) {
+	u, err := url.Parse(writeURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("-influx %q is not an http or https url", writeURL)
+	}
+	if batch < 1 || flush <= 0 {
+		return nil, fmt.Errorf("-influxbatch and -influxflush must be over 0")
+	}
+	if spool != "" {
+		if err := os.MkdirAll(spool, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &influxSink{url: writeURL, token: token, measurement: measurement, spool: spool,
+		batch: batch, flush: flush, client: &http.Client{Timeout: influxTimeout},
+		lines: make(chan string, influxQueue), backoff: influxBackoff}, nil
+}
+
+//influxRejected is the error of a write the database turned down.
+type influxRejected struct {
+	status int
+	msg    string
+}
+
+func (e *influxRejected) Error() string {
+	return fmt.Sprintf("rejected with %d: %s", e.status, e.msg)
+}
+
+//<++++++++++++++++++++++++++   the app side   ++++++++++++++++++++++++++++++++>
+
+//influxEscape escapes the characters line protocol gives a meaning to in a
+//tag key, tag value or measurement.
+var influxEscape = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+//influxLine is one line for channel with fields already formatted.
+func (s *influxSink) influxLine(serial, channel, name, unit, fields string, t time.Time) string {
+	b := strings.Builder{}
+	b.WriteString(influxEscape.Replace(s.measurement))
+	//tags sorted by key, which is what the database prefers
+	for _, tag := range [][2]string{{"channel", channel}, {"name", name}, {"serial", serial}, {"unit", unit}} {
+		if tag[1] != "" {
+			fmt.Fprintf(&b, ",%s=%s", tag[0], influxEscape.Replace(tag[1]))
+		}
+	}
+	fmt.Fprintf(&b, " %s %d", fields, t.UnixNano())
+	return b.String()
+}
+
+//recordInflux queues a line for every pin in use and the temperature.  Must
+//be called with app.mu held.
+func (app *application) recordInflux(t time.Time) {
+	s := app.influx
+	if s == nil {
+		return
+	}
+	serial := app.u3.SerialNumber
+	if serial == "" {
+		serial = app.metrics.serials[app.u3.DeviceNumber]
+	}
+	for _, pin := range app.u3.pins() {
+		v, analog, ok := pin.pinValue()
+		if !ok {
+			continue
+		}
+		fields := "value=" + number(v)
+		unit := pin.Unit
+		if analog {
+			volts, err := strconv.ParseFloat(pin.FilteredVoltage, 64)
+			if err != nil {
+				continue
+			}
+			fields += fmt.Sprintf(",volts=%s,raw=%di", number(volts), pin.AnalogRead)
+			if unit == "" && pin.Scale.Kind == "None" {
+				unit = "V"
+			}
+		}
+		s.queue(s.influxLine(serial, pin.Label, pin.Name, unit, fields, t))
+	}
+	if app.u3.temperatureRead {
+		s.queue(s.influxLine(serial, "temperature", "", "C", "value="+number(app.u3.Temperature), t))
+	}
+}
+
+//queue hands the line to run, it does not wait.
+func (s *influxSink) queue(line string) {
+	select {
+	case s.lines <- line:
+	default:
+	}
+}
+
+//<++++++++++++++++++++++++++   the sending side   ++++++++++++++++++++++++++++>
+
+//run batches the queued lines and sends them, it does not return.
+func (s *influxSink) run(errorLog *log.Logger) {
+	tick := time.NewTicker(s.flush)
+	defer tick.Stop()
+	batch := []string{}
+	for {
+		select {
+		case line := <-s.lines:
+			batch = append(batch, line)
+			if len(batch) < s.batch {
+				continue
+			}
+		case <-tick.C:
+		}
+		if err := s.sendSpool(); err != nil {
+			errorLog.Printf("influx: %v", err)
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		body := []byte(strings.Join(batch, "\n") + "\n")
+		batch = batch[:0]
+		if err := s.send(body); err != nil {
+			errorLog.Printf("influx: %v", err)
+		}
+	}
+}
+
+//send writes body, retrying when the database can not be reached, and
+//spools it when it still can not.
+func (s *influxSink) send(body []byte) error {
+	err := s.write(body)
+	for try, wait := 1, s.backoff; err != nil && try < influxRetries; try, wait = try+1, wait*2 {
+		if _, rejected := err.(*influxRejected); rejected {
+			break
+		}
+		time.Sleep(wait)
+		err = s.write(body)
+	}
+	if err == nil {
+		return nil
+	}
+	if _, rejected := err.(*influxRejected); rejected || s.spool == "" {
+		return fmt.Errorf("%d lines dropped: %w", bytes.Count(body, []byte("\n")), err)
+	}
+	path := filepath.Join(s.spool, fmt.Sprintf("%020d.lp", time.Now().UnixNano()))
+	if werr := ioutil.WriteFile(path, body, 0644); werr != nil {
+		return fmt.Errorf("%v, and spooling failed: %v", err, werr)
+	}
+	return fmt.Errorf("%v, spooled to %s", err, path)
+}
+
+//sendSpool sends the spooled files oldest first and removes them, it stops at
+//the first one that can not be sent.
+func (s *influxSink) sendSpool() error {
+	if s.spool == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(s.spool, "*.lp"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		err = s.write(body)
+		if e, rejected := err.(*influxRejected); rejected {
+			os.Rename(path, strings.TrimSuffix(path, ".lp")+".rejected")
+			return fmt.Errorf("%s %v", path, e)
+		}
+		if err != nil {
+			return nil //still down, the files stay for the next try
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//write POSTs body once.
+func (s *influxSink) write(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+		return &influxRejected{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Saied74/labjack/pkg/jack"
+)
+
+/*
+Interlock file holds the control rules that drive digital outputs from inputs.
+They are evaluated after the alarms on every acquisition (see afterAcquire) and
+write the outputs through the same path as the Update Digital button on the
+measurement page: DigitalWrite of the output pin, copyToWirteDigitalOutput and
+a Port State Write.  Rules are written as text:
+
+	if FIO4 > 2.5 then EIO0 = 0
+	if FIO4 < 1.0 then EIO0 = 1
+	CIO1 follows FIO5
+	CIO1 follows FIO5 inverted
+	CIO1 follows FIO5 above 1.2
+
+The input is compared by its pin value, the same one the alarms use (scaled
+value for analog pins, state for digital pins).  An "if" rule only sets the
+output while its condition holds and leaves it alone otherwise, so it works as
+a latch.  A "follows" rule always sets the output, high when the input is over
+the threshold (0.5 unless given, which is right for digital inputs).  When more
+than one rule drives an output, the last one in the list wins.
+
+The level a rule wants is written even when DigitalWrite already has it the
+first time the rule acts after start-up or after it is added, since
+DigitalWrite is only what this program last wrote and not read back from the
+device.  Every change a rule makes to an output is recorded as a ControlEvent,
+once the Port State Write has gone through.  When it fails nothing is
+recorded, the rule says so in its Status and it is tried again on the next
+acquisition.
+*/
+
+const defaultFollowThreshold = 0.5
+
+type ControlRule struct {
+	ID        int
+	Input     string //pin label
+	Kind      string //Above, Below or Follow
+	Threshold float64
+	Output    string //pin label, must be a digital output
+	Level     int    //output level for Above and Below
+	Inverted  bool   //for Follow
+	Status    string //why the rule did not run at the last evaluation, if it did not
+	Changes   int    //number of times the rule changed its output
+	asserted  bool   //its level has been written since start-up or since it was added
+}
+
+type ControlEvent struct {
+	Time    time.Time
+	RuleID  int
+	Output  string
+	From    int
+	To      int
+	Message string
+}
+
+/*
+parseControlRule parses the text form of a rule, see the top of the file.
+*/
+func parseControlRule(text string) (*ControlRule, error) {
+	f := strings.Fields(strings.ToUpper(text))
+	bad := fmt.Errorf("%q is not a rule, see the examples on the page", text)
+	c := &ControlRule{}
+	switch {
+	case len(f) == 8 && f[0] == "IF" && f[4] == "THEN" && f[6] == "=":
+		c.Input, c.Output = f[1], f[5]
+		switch f[2] {
+		case ">":
+			c.Kind = "Above"
+		case "<":
+			c.Kind = "Below"
+		default:
+			return nil, bad
+		}
+		t, err := strconv.ParseFloat(f[3], 64)
+		if err != nil {
+			return nil, bad
+		}
+		c.Threshold = t
+		if f[7] != "0" && f[7] != "1" {
+			return nil, bad
+		}
+		c.Level, _ = strconv.Atoi(f[7])
+	case len(f) >= 3 && f[1] == "FOLLOWS":
+		c.Kind, c.Output, c.Input = "Follow", f[0], f[2]
+		c.Threshold = defaultFollowThreshold
+		rest := f[3:]
+		if len(rest) > 0 && rest[0] == "INVERTED" {
+			c.Inverted = true
+			rest = rest[1:]
+		}
+		if len(rest) == 2 && rest[0] == "ABOVE" {
+			t, err := strconv.ParseFloat(rest[1], 64)
+			if err != nil {
+				return nil, bad
+			}
+			c.Threshold = t
+			rest = rest[2:]
+		}
+		if len(rest) != 0 {
+			return nil, bad
+		}
+	default:
+		return nil, bad
+	}
+	return c, nil
+}
+
+func (c *ControlRule) String() string {
+	switch c.Kind {
+	case "Above":
+		return fmt.Sprintf("if %s > %g then %s = %d", c.Input, c.Threshold, c.Output, c.Level)
+	case "Below":
+		return fmt.Sprintf("if %s < %g then %s = %d", c.Input, c.Threshold, c.Output, c.Level)
+	}
+	s := c.Output + " follows " + c.Input
+	if c.Inverted {
+		s += " inverted"
+	}
+	if c.Threshold != defaultFollowThreshold {
+		s += fmt.Sprintf(" above %g", c.Threshold)
+	}
+	return s
+}
+
+func (c *ControlRule) check(u *U3) error {
+	if _, _, err := u.pinByName(c.Input); err != nil {
+		return err
+	}
+	if _, _, err := u.pinByName(c.Output); err != nil {
+		return err
+	}
+	if c.Input == c.Output {
+		return fmt.Errorf("a rule can not drive its own input")
+	}
+	switch c.Kind {
+	case "Above", "Below", "Follow":
+	default:
+		return fmt.Errorf("%q is not a rule kind, use Above, Below or Follow", c.Kind)
+	}
+	if c.Level != 0 && c.Level != 1 {
+		return fmt.Errorf("level must be 0 or 1")
+	}
+	//a NaN compares false with anything, a rule on one would never act
+	if math.IsNaN(c.Threshold) || math.IsInf(c.Threshold, 0) {
+		return fmt.Errorf("the threshold is %g, it must be a number", c.Threshold)
+	}
+	return nil
+}
+
+//level returns the level the rule wants on its output for input value v, and
+//false when it leaves the output alone.
+func (c *ControlRule) level(v float64) (int, bool) {
+	switch c.Kind {
+	case "Above":
+		return c.Level, v > c.Threshold
+	case "Below":
+		return c.Level, v < c.Threshold
+	}
+	high := v > c.Threshold
+	if high != c.Inverted {
+		return 1, true
+	}
+	return 0, true
+}
+
+//ruleWrite is the level a rule wants on its output, and the input value it
+//wants it for.
+type ruleWrite struct {
+	c     *ControlRule
+	out   *Pin
+	level int
+	v     float64
+}
+
+//evaluateRules runs every control rule against the last acquisition and writes
+//the outputs that changed in one Port State Write.  Must be called with app.mu
+//held.
+func (app *application) evaluateRules(t time.Time) {
+	writes := []ruleWrite{}
+	for _, c := range app.u3.Rules {
+		in, _, _ := app.u3.pinByName(c.Input)
+		out, _, _ := app.u3.pinByName(c.Output)
+		if in == nil || out == nil {
+			continue
+		}
+		v, _, ok := in.pinValue()
+		if !ok && in.ReadFailed {
+			c.Status = c.Input + " could not be read"
+			continue
+		}
+		if !ok && in.OutOfRange {
+			c.Status = c.Input + " is out of range of its scale"
+			continue
+		}
+		if !ok {
+			c.Status = c.Input + " is not in use"
+			continue
+		}
+		if out.AD != "Digital" || out.IO != "Output" {
+			c.Status = c.Output + " is not a digital output"
+			continue
+		}
+		c.Status = ""
+		level, act := c.level(v)
+		if act && (out.DigitalWrite != level || !c.asserted) {
+			writes = append(writes, ruleWrite{c, out, level, v})
+		}
+	}
+	if len(writes) == 0 {
+		return
+	}
+	//the levels go in DigitalWrite for copyToWirteDigitalOutput, and back out
+	//when the write fails, the last rule on an output wins
+	was := map[*Pin]int{}
+	last := map[*Pin]*ControlRule{}
+	for _, w := range writes {
+		if _, ok := was[w.out]; !ok {
+			was[w.out] = w.out.DigitalWrite
+		}
+		w.out.DigitalWrite = w.level
+		last[w.out] = w.c
+	}
+	app.copyToWirteDigitalOutput(jack.PortStateWrite)
+	if err := app.u3SendRec(jack.PortStateWrite, 0x01); err != nil {
+		for out, level := range was {
+			out.DigitalWrite = level
+		}
+		for _, w := range writes {
+			w.c.Status = fmt.Sprintf("setting %s to %d failed: %v", w.c.Output, w.level, err)
+		}
+		return
+	}
+	for _, w := range writes {
+		w.c.asserted = true
+		if last[w.out] != w.c || was[w.out] == w.level {
+			continue //another rule won the output, or it had the level already
+		}
+		e := ControlEvent{Time: t, RuleID: w.c.ID, Output: w.c.Output, From: was[w.out], To: w.level}
+		e.Message = fmt.Sprintf("rule %d (%s) set %s from %d to %d, %s was %.4g",
+			w.c.ID, w.c, w.c.Output, e.From, e.To, w.c.Input, w.v)
+		w.c.Changes++
+		app.u3.ControlEvents = append(app.u3.ControlEvents, e)
+		if len(app.u3.ControlEvents) > maxEvents {
+			app.u3.ControlEvents = app.u3.ControlEvents[len(app.u3.ControlEvents)-maxEvents:]
+		}
+		app.infoLog.Print(e.Message)
+	}
+}
+
+//addRule checks the rule and adds it with the next free ID.
+func (app *application) addRule(c *ControlRule) error {
+	if err := c.check(app.u3); err != nil {
+		return err
+	}
+	c.ID = 1
+	for _, b := range app.u3.Rules {
+		if b.ID >= c.ID {
+			c.ID = b.ID + 1
+		}
+	}
+	c.Changes = 0
+	app.u3.Rules = append(app.u3.Rules, c)
+	return app.saveRules()
+}
+
+func (app *application) deleteRule(id int) error {
+	for i, c := range app.u3.Rules {
+		if c.ID == id {
+			app.u3.Rules = append(app.u3.Rules[:i], app.u3.Rules[i+1:]...)
+			return app.saveRules()
+		}
+	}
+	return fmt.Errorf("there is no rule %d", id)
+}
+
+//the rules are kept in app.ruleFile (when given) so they survive a restart.
+func (app *application) loadRules() error {
+	if app.ruleFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(app.ruleFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rules := []*ControlRule{}
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return fmt.Errorf("%s: %v", app.ruleFile, err)
+	}
+	for _, c := range rules {
+		if err := c.check(app.u3); err != nil {
+			return fmt.Errorf("%s: rule %d: %v", app.ruleFile, c.ID, err)
+		}
+	}
+	app.u3.Rules = rules
+	return nil
+}
+
+func (app *application) saveRules() error {
+	if app.ruleFile == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(app.u3.Rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(app.ruleFile, b, 0644)
+}
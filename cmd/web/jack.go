@@ -1,34 +1,54 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"time"
 
-//Jack file is a set of LabJack helper frunctions.
-
-const (
-	configJack     = "Config U3"
-	configIO       = "Config IO"
-	ain            = "AIN"
-	led            = "LED"
-	portStateRead  = "Port State Read"
-	portStateWrite = "Port State Write"
-	portDirRead    = "Port Direction Read"
-	portDirWrite   = "Port Direction Write"
-	tempSense      = "Temperature Sense"
-	vReg           = "VReg"
+	"github.com/Saied74/labjack/pkg/jack"
 )
 
+//Jack file holds the model of the U3 used by the web pages and the functions
+//that map the recieve buffers into it.  The protocol itself is in package jack.
+
 /*
 Pin is the model for each of the U3 device pins.  It can either be loaded
 from the flash device memory or from the voltaile device memory.  It is not
 instanciated directly.  It is a component of the U3 type.
 */
 type Pin struct {
-	AD            string //Analog or digital
-	IO            string //Input or Output
-	AnalogRead    uint16 //A/D convertor raw read
-	AnalogVoltage string //Analog read convergted to voltage
-	DigitalRead   int    //only one and zero allowed
-	DigitalWrite  int    //only one and zero allowed
+	AD              string  //Analog or digital
+	IO              string  //Input or Output
+	AnalogRead      uint16  //A/D convertor raw read
+	AnalogVoltage   string  //Analog read convergted to voltage
+	DigitalRead     int     //only one and zero allowed
+	DigitalWrite    int     //only one and zero allowed
+	NegChannel      int     //negative channel for analog reads, 31 is single ended
+	LongSettling    bool    //adds settling time before an analog read, see ainOptions
+	QuickSample     bool    //shortens the analog conversion, see ainOptions
+	Samples         int     //number of analog reads per acquisition, see filter.go
+	Filter          string  //None, Mean, Median or EMA
+	EMAWeight       float64 //weight of each new read in the EMA, 0 to 1
+	FilteredRead    float64 //AnalogRead after filtering
+	FilteredVoltage string  //FilteredRead converted to voltage
+	Label           string  //name on the device, FIO0 for example
+	Name            string  //user given name, see scale.go
+	Unit            string  //engineering unit of Value
+	Scale           Scale   //converts the filtered voltage to Value
+	Value           float64 //filtered voltage in engineering units
+	ValueText       string  //Value formatted for the pages
+	OutOfRange      bool    //no voltage could be worked out or the scale gave no number, Value is 0
+	ReadFailed      bool    //the last acquisition could not read the pin, the values are older
+	Edge            string  //None, Rising, Falling or Both, edges logged, see edges.go
+	Debounce        float64 //milliseconds a new level has to last to be an edge
+	Rising          int     //rising edges counted
+	Falling         int     //falling edges counted
+	LastEdge        time.Time
+	samples         []uint16
+	ema             float64
+	emaValid        bool
+	edgeStarted     bool      //edgeLevel has been read
+	edgeLevel       int       //level after the last edge
+	edgeSince       time.Time //when a level other than edgeLevel was first read
 }
 
 /*
@@ -54,8 +74,40 @@ type U3 struct {
 	ProductID         string
 	LocalID           string
 	DeviceName        string
+	Temperature       float64    //of the U3 in degrees C, read with every acquisition
+	DAC               [2]float64 //volts last written to DAC0 and DAC1 by this program
 	Message           string
+	LED               string         //On or Off, as last written by this program
+	DeviceNumber      int            //the U3 this program is talking to, starting at 1
+	Devices           []*DeviceEntry //all U3s found on the USB bus by the last scan
+	Alarms            []*AlarmRule   //see alarm.go
+	Events            []AlarmEvent   //last alarm events, oldest first
+	ActiveAlarms      int            //number of alarms raised right now
+	Rules             []*ControlRule //see interlock.go
+	ControlEvents     []ControlEvent //last changes made by the rules, oldest first
+	Running           string         //sequence being run, if any
+	Runs              []*SequenceRun //last runs, oldest first, see sequence.go
+	EdgeEvents        []EdgeEvent    //last edges of the digital inputs, oldest first
+	Patterns          []*Pattern     //see pattern.go
+	PatternRuns       []*PatternRun  //last plays, oldest first
+	Playing           *PatternRun    //pattern being played by the host, if any
+	Waves             [2]*WaveRun    //waveform playing or last played on each DAC, see waveform.go
 	open              bool
+	temperatureRead   bool             //Temperature was read by the last acquisition
+	cal               jack.Calibration //see package jack
+	config            *jack.Config     //last ConfigU3 read, nil until one is, see hv
+}
+
+/*
+DeviceEntry is what is known about one of several U3s on the USB bus.  It is
+used to tell the devices apart on the devices page before one of them is
+selected.
+*/
+type DeviceEntry struct {
+	Number       int //device number passed to the driver, starting at 1
+	SerialNumber string
+	DeviceName   string
+	LocalID      string
 }
 
 /*
@@ -63,393 +115,100 @@ functions newPin and newU3 are constructed in the hope that refrence to the
 results will not cause nil pointer refrence panic.
 */
 
-//Builds a blank instance of the Pin type.
+// Builds a blank instance of the Pin type.
 func newPin() *Pin {
-	return &Pin{}
+	return &Pin{NegChannel: jack.SENeg, LongSettling: true, Samples: 1,
+		Filter: "None", EMAWeight: defaultEMAWeight, Scale: Scale{Kind: "None"}, Edge: "None"}
+}
+
+/*
+ainOptions returns the positive channel byte of the AIN command for channel ch
+(0-7 for FIO, 8-15 for EIO) with the pin's acquisition options.  Per the low
+level function reference, bit 6 is LongSettling and bit 7 is QuickSample.
+
+LongSettling adds a settling delay of a few milliseconds before the conversion.
+It is needed when the source impedance is high (over about 10k) and makes each
+read that much slower.  QuickSample shortens the conversion so each read takes
+less time, at the price of more noise in the reading.  See section 3.1 of the U3
+user's guide for the measured times and noise at each setting.
+*/
+func (p *Pin) ainOptions(ch int) byte {
+	b := byte(ch)
+	if p.LongSettling {
+		b |= 0x40
+	}
+	if p.QuickSample {
+		b |= 0x80
+	}
+	return b
 }
 
-//builds a blank instance of the U3 type.
+// builds a blank instance of the U3 type.
 func newU3() *U3 {
 	u3 := U3{}
 	for i := 0; i < 8; i++ {
 		u3.EIO = append(u3.EIO, newPin())
 		u3.FIO = append(u3.FIO, newPin())
 		u3.CIO = append(u3.CIO, newPin())
+		u3.EIO[i].Label = fmt.Sprintf("EIO%d", i)
+		u3.FIO[i].Label = fmt.Sprintf("FIO%d", i)
+		u3.CIO[i].Label = fmt.Sprintf("CIO%d", i)
 	}
 	u3.Message = "No Message"
+	u3.LED = "On"
+	u3.DeviceNumber = 1
+	u3.cal = jack.NewCalibration()
 	return &u3
 }
 
-/*
-u3srData type is the model for each individual U3 command.  The send and recieved
-lengths for each command are different.  Also, the meaning of each byte is different
-for each commmand and commmand type.  For this reason, they are documented inline
-in the buildU3SRData function for each command.  The names of the commands are
-the same as the names of the commands in the documentation.
-*/
-type u3srElement struct {
-	sendLength  int
-	recLength   int
-	byte1       byte
-	byte2       byte
-	byte3       byte
-	byte6       byte
-	byte7       byte
-	byte8       byte
-	byte9       byte
-	byte10      byte
-	byte11      byte
-	byte12      byte
-	byte13      byte
-	checkReturn func(*u3srElement, []byte) error
-	buildBytes  func(*u3srElement, []byte, byte)
-}
-
-//u3srData type is the collection of all the commands available for the U3 device
-type u3srData map[string]*u3srElement
-
-/*
-
- */
-func buildU3srData() u3srData {
-	return u3srData{
-		configJack: &u3srElement{ //ConfigU3 changed to configJack (older naming conflict)
-			sendLength:  26,                  //to make the sendBuffer in u3SendRec function.
-			recLength:   38,                  //to make the recBuffer in the u3SendRec function
-			byte1:       0xF8,                //per device low level function reference
-			byte2:       0x0A,                //per device low level function refrence
-			byte3:       0x08,                //per device low level function refrence
-			byte6:       0x00,                //per device low level function refrence
-			byte7:       0x00,                //per device low level function refrence
-			checkReturn: checkJack,           //call to check the validity of the returned bytes
-			buildBytes:  buildJackSendBuffer, //call to form the send bytes.
-		},
-		//Analog or digital nature of pins is set with this command, it does not impact flash
-		configIO: &u3srElement{ //all fields the same as configJack fields.
-			sendLength:  12,
-			recLength:   12,
-			byte1:       0xF8,
-			byte2:       0x03,
-			byte3:       0x0B,
-			byte6:       0x00,
-			byte7:       0x00,
-			checkReturn: checkIO,
-			buildBytes:  buildJackSendBuffer,
-		},
-		//the following are all subcommands of the "feedback" command.
-		ain: &u3srElement{ //read analog pin voltage
-			sendLength:  10,
-			recLength:   12,
-			byte1:       0xF8, //per device low level function refrence
-			byte2:       2,    //number of words (two byte pairs) startying with byte 6
-			byte3:       0x00,
-			byte6:       0,
-			byte7:       1, //feedback subcommand
-			checkReturn: checkFeedback,
-			buildBytes:  buildAINReadBuffer,
-		},
-		led: &u3srElement{ //set led state (on or off)
-			sendLength: 9,
-			recLength:  9,
-			byte1:      0xF8,
-			byte2:      2, //number of words (two byte pairs) startying with byte 6
-			byte3:      0x00,
-			byte6:      0,
-			byte7:      9, //feedback subcommand
-		},
-		portStateRead: &u3srElement{ //read the state of digital input pins, high or low
-			sendLength:  8,
-			recLength:   12,
-			byte1:       0xF8,
-			byte2:       1, //number of words (two byte pairs) startying with byte 6
-			byte3:       0x00,
-			byte6:       0,
-			byte7:       26, //feedback subcommand
-			checkReturn: checkFeedback,
-			buildBytes:  buildPortStateReadBuffer,
-		},
-		portStateWrite: &u3srElement{ //write the state of digital output pins (high or low)
-			sendLength:  14,
-			recLength:   10,
-			byte1:       0xF8,
-			byte2:       4, //number of words (two byte pairs) startying with byte 6
-			byte3:       0x00,
-			byte6:       0,
-			byte7:       27, //feedback subcommand
-			checkReturn: checkFeedback,
-			buildBytes:  buildPortStateWriteBuffer,
-		},
-		portDirRead: &u3srElement{ //read the direction of the digital pins, input or output
-			sendLength:  8,
-			recLength:   12,
-			byte1:       0xF8,
-			byte2:       1, //number of words (two byte pairs) startying with byte 6
-			byte3:       0x00,
-			byte6:       0,
-			byte7:       28, //feedback subcommand
-			checkReturn: checkFeedback,
-			buildBytes:  buildPortDirReadBuffer,
-		},
-		portDirWrite: &u3srElement{ //write the direction of digital pins, input or output
-			sendLength:  14,
-			recLength:   10,
-			byte1:       0xF8,
-			byte2:       4, //number of words (two byte pairs) startying with byte 6
-			byte3:       0x00,
-			byte6:       0,
-			byte7:       29, //feedback subcommand
-			checkReturn: checkFeedback,
-			buildBytes:  buildPortDirWriteBuffer,
-		},
-		tempSense: &u3srElement{ // read temperature
-			sendLength: 8,
-			recLength:  11,
-			byte1:      0xF8,
-			byte2:      1, //number of words (two byte pairs) startying with byte 6
-			byte3:      0x00,
-			byte6:      0,
-			byte7:      30, //feedback subcommand
-		},
-		vReg: &u3srElement{ //I don't know what this reads, we will find out.
-			sendLength: 8,
-			recLength:  11,
-			byte1:      0xF8,
-			byte2:      1, //number of words (two byte pairs) startying with byte 6
-			byte3:      0x00,
-			byte6:      0,
-			byte7:      31, //feedback subcommand
-		},
-	}
-}
-
-//<+++++++++++++++++++ Check Methods for Commands to the Device +++++++++++++++>
-
-func checkJack(sr *u3srElement, recBuffer []byte) error {
-	if recBuffer[0] == 0xB8 && recBuffer[1] == 0xB8 {
-		return fmt.Errorf("The U3 detected a bad checksum. Double check your checksum calculations and try again")
-	} else {
-		if recBuffer[1] != 0xF8 || recBuffer[2] != 0x10 || recBuffer[3] != 0x08 {
-			// Make sure the command bytes match what we expect.
-			return fmt.Errorf("Got the wrong command bytes back from the U3")
-		}
-
-		checksum16 := calculateChecksum16(recBuffer, sr.recLength)
-		checksum8 := calculateChecksum8(recBuffer)
-		if checksum8 != recBuffer[0] || int(recBuffer[4]) != checksum16&0xff || int(recBuffer[5]) != ((checksum16/256)&0xff) {
-			return fmt.Errorf("Response had invalid checksum.\n%d != %d, %d != %d, %d != %d", checksum8, recBuffer[0], checksum16&0xff, recBuffer[4], ((checksum16 / 256) & 0xff), recBuffer[5])
-		} else {
-			if recBuffer[6] != 0 { // Check the error code in the packet. See section 5.3 of the U3
-				return fmt.Errorf("Command returned with an errorcode = %d", recBuffer[6])
-			}
-			return nil
-		}
-	}
-}
-
-func checkIO(sr *u3srElement, recBuffer []byte) error {
-	if recBuffer[0] == 0xB8 && recBuffer[1] == 0xB8 {
-		return fmt.Errorf("The U3 detected a bad checksum. Double check your checksum calculations and try again")
-	} else {
-		if recBuffer[1] != sr.byte1 || recBuffer[2] != sr.byte2 || recBuffer[3] != sr.byte3 {
-			return fmt.Errorf("Got the wrong command bytes back from the U3")
-		}
-
-		checksum16 := calculateChecksum16(recBuffer, sr.recLength)
-		checksum8 := calculateChecksum8(recBuffer)
-		if checksum8 != recBuffer[0] || int(recBuffer[4]) != checksum16&0xff || int(recBuffer[5]) != ((checksum16/256)&0xff) {
-			return fmt.Errorf("Response had invalid checksum.\n%d != %d, %d != %d, %d != %d", checksum8, recBuffer[0], checksum16&0xff, recBuffer[4], ((checksum16 / 256) & 0xff), recBuffer[5])
-		} else {
-			if recBuffer[6] != 0 { // Check the error code in the packet. See section 5.3 of the U3
-				return fmt.Errorf("Command returned with an errorcode = %d", recBuffer[6])
-			}
-			return nil
-		}
-	}
-}
-
-func checkFeedback(sr *u3srElement, recBuffer []byte) error {
-	if recBuffer[0] == 0xB8 && recBuffer[1] == 0xB8 {
-		return fmt.Errorf("The U3 detected a bad checksum. Double check your checksum calculations and try again")
-	}
-	if recBuffer[1] != sr.byte1 {
-		return fmt.Errorf("Got the wrong command bytes back from the U3")
-	}
-	checksum16 := calculateChecksum16(recBuffer, sr.recLength)
-	checksum8 := calculateChecksum8(recBuffer)
-	if checksum8 != recBuffer[0] || int(recBuffer[4]) != checksum16&0xff || int(recBuffer[5]) != ((checksum16/256)&0xff) {
-		return fmt.Errorf("Response had invalid checksum.\n%d != %d, %d != %d, %d != %d", checksum8, recBuffer[0], checksum16&0xff, recBuffer[4], ((checksum16 / 256) & 0xff), recBuffer[5])
-	}
-	if recBuffer[6] != 0 { // Check the error code in the packet. See section 5.3 of the U3
-		return fmt.Errorf("Command returned with an errorcode = %d", recBuffer[6])
-	}
-	return nil
-}
-
-//<+++++++++++++ Build Methods for sendBuffer for the commands ++++++++++++++++>
-
-//builds the configU3 command send buffer templated after the vendor C example
-func buildJackSendBuffer(sr *u3srElement, sendBuffer []byte, writeMask byte) {
-	copyHead(sr, sendBuffer)
-	sendBuffer[6] = writeMask
-	for i := 7; i < sr.sendLength; i++ {
-		sendBuffer[i] = 0
-	}
-	sendBuffer[10] = sr.byte10
-	sendBuffer[11] = sr.byte11
-	addChecksum(sr, sendBuffer)
-}
-
-//templated after the configU3 buffer build provided by the vendor (in C)
-func buildPortDirReadBuffer(sr *u3srElement, sendBuffer []byte, writeMask byte) {
-	copyHead(sr, sendBuffer)
-	sendBuffer[6] = writeMask
-	sendBuffer[7] = sr.byte7
-	addChecksum(sr, sendBuffer)
-}
-
-func buildPortStateReadBuffer(sr *u3srElement, sendBuffer []byte, writeMask byte) {
-	copyHead(sr, sendBuffer)
-	sendBuffer[6] = writeMask
-	sendBuffer[7] = sr.byte7
-	addChecksum(sr, sendBuffer)
-}
-
-func buildAINReadBuffer(sr *u3srElement, sendBuffer []byte, writeMask byte) {
-	copyHead(sr, sendBuffer)
-	sendBuffer[6] = writeMask
-	sendBuffer[7] = sr.byte7
-	sendBuffer[8] = sr.byte8
-	sendBuffer[9] = 0x00
-	addChecksum(sr, sendBuffer)
+// pins returns all the pins that are on the device, FIO then EIO then CIO.
+func (u *U3) pins() []*Pin {
+	pins := append([]*Pin{}, u.FIO...)
+	pins = append(pins, u.EIO...)
+	return append(pins, u.CIO[:4]...)
 }
 
 /*
-The feedback functions all have a different send and recieve buffer templetaes.
-Hence all send buffer builds will be different.
+pinByName returns the pin named like on the device label, FIO0-7, EIO0-7 or
+CIO0-3, along with its analog channel number (0-15, FIO first).  The channel
+number is meaningless for CIO pins since they are digital only.  See
+jack.Channel.
 */
-func buildPortDirWriteBuffer(sr *u3srElement, sendBuffer []byte, writeMask byte) {
-	copyHead(sr, sendBuffer)
-	for i := 8; i < sr.sendLength; i++ {
-		sendBuffer[i] = 0x00
-	}
-	sendBuffer[8] = 0xff
-	sendBuffer[9] = 0xff
-	sendBuffer[10] = 0xff
-	sendBuffer[11] = sr.byte11
-	sendBuffer[12] = sr.byte12
-	sendBuffer[13] = sr.byte13
-	addChecksum(sr, sendBuffer)
-}
-
-func buildPortStateWriteBuffer(sr *u3srElement, sendBuffer []byte, writeMask byte) {
-	copyHead(sr, sendBuffer)
-	for i := 8; i < sr.sendLength; i++ {
-		sendBuffer[i] = 0x00
+func (u *U3) pinByName(name string) (*Pin, int, error) {
+	ch, err := jack.Channel(name)
+	if err != nil {
+		return nil, 0, err
 	}
-	sendBuffer[8] = sr.byte8
-	sendBuffer[9] = sr.byte9
-	sendBuffer[10] = sr.byte10
-	sendBuffer[11] = sr.byte11
-	sendBuffer[12] = sr.byte12
-	sendBuffer[13] = sr.byte13
-	addChecksum(sr, sendBuffer)
+	return u.pins()[ch], ch, nil
 }
 
-//<++++++++++++++++++++++++  Helper Functions ++++++++++++++++++++++++++++++++>
-//helper function for building sendBuffer
-func copyHead(sr *u3srElement, sendBuffer []byte) {
-	sendBuffer[1] = sr.byte1
-	sendBuffer[2] = sr.byte2
-	sendBuffer[3] = sr.byte3
-	sendBuffer[6] = sr.byte6
-	sendBuffer[7] = sr.byte7
-}
-
-//helper function for building sendbuffer
-func addChecksum(sr *u3srElement, sendBuffer []byte) {
-	checksum := 0
-	checksum = calculateChecksum16(sendBuffer, sr.sendLength)
-	sendBuffer[4] = byte(checksum & 0xff)
-	sendBuffer[5] = byte((checksum / 256) & 0xff)
-	sendBuffer[0] = calculateChecksum8(sendBuffer)
-}
-
-//helper function for building send and checking recieve buffers.
-func calculateChecksum16(buffer []byte, len int) int {
-	checksum := 0
-	for i := 6; i < len; i++ {
-		checksum += int(buffer[i])
+//hv is jack.Config.HV of the device, which is only known once its ConfigU3
+//has been read.
+func (u *U3) hv() (bool, error) {
+	if u.config == nil {
+		return false, fmt.Errorf("the ConfigU3 of the device has not been read, it is not known if it is a U3-HV")
 	}
-
-	return checksum
-}
-
-//helper function for building the send and checking the recive buffers.
-func calculateChecksum8(buffer []byte) byte {
-	var temp int // For holding a value while we working.
-	checksum := 0
-
-	for i := 1; i < 6; i++ {
-		checksum += int(buffer[i])
-	}
-
-	temp = checksum / 256
-	checksum = (checksum - 256*temp) + temp
-	temp = checksum / 256
-
-	return byte((checksum - 256*temp) + temp)
-}
-
-// Takes a buffer and an offset, and turns into an 32-bit integer
-func makeInt(buffer []byte, offset int) int {
-	return int((buffer[offset+3] << 24) + (buffer[offset+2] << 16) + (buffer[offset+1] << 8) + buffer[offset])
-}
-
-// Takes a buffer and an offset, and turns into an 16-bit integer
-func makeShort(buffer []byte, offset int) int {
-	return int((buffer[offset+1] << 8) + buffer[offset])
+	return u.config.HV(), nil
 }
 
 //<++++++++  Functions for mapping the recieve buffer to app.u3 +++++++++++++++>
 
 // Parses the ConfigU3 recBuffer and put them into app.u3.
 func (u *U3) parseConfigU3Bytes(recBuffer []byte) {
-
-	u.FirmwareVersion = fmt.Sprintf("%d.%02d", int(recBuffer[10]), int(recBuffer[9]))
-	u.BootLoaderVersion = fmt.Sprintf("%d.%02d", recBuffer[12], recBuffer[11])
-	u.HardwareVersion = fmt.Sprintf("%d.%02d", recBuffer[14], recBuffer[13])
-	u.SerialNumber = fmt.Sprintf("%d", makeInt(recBuffer, 15))
-	u.ProductID = fmt.Sprintf("%d", makeShort(recBuffer, 19))
-	u.LocalID = fmt.Sprintf("%d", recBuffer[21])
+	c := jack.ParseConfig(recBuffer)
+	u.FirmwareVersion = c.FirmwareVersion
+	u.BootLoaderVersion = c.BootLoaderVersion
+	u.HardwareVersion = c.HardwareVersion
+	u.SerialNumber = c.SerialNumber
+	u.ProductID = c.ProductID
+	u.LocalID = c.LocalID
+	u.DeviceName = c.DeviceName
+	u.config = &c
 
 	u.parseFlashBytes(recBuffer)
-
-	// fmt.Printf("  TimerCounterMask = %d\n", recBuffer[22])
-	// fmt.Printf("  DAC1Enable = %d\n", recBuffer[31])
-	// fmt.Printf("  DAC0 = %d\n", recBuffer[32])
-	// fmt.Printf("  DAC1 = %d\n", recBuffer[33])
-	// fmt.Printf("  TimerClockConfig = %d\n", recBuffer[34])
-	// fmt.Printf("  TimerClockDivisor = %d\n", recBuffer[35])
-	// fmt.Printf("  CompatibilityOptions = %d\n", recBuffer[36])
-	// fmt.Printf("  VersionInfo = %d\n", recBuffer[37])
-
-	buf37 := int(recBuffer[37])
-	switch buf37 {
-	case 0:
-		u.DeviceName = fmt.Sprintf("U3A")
-	case 1:
-		u.DeviceName = fmt.Sprintf("U3B")
-	case 2:
-		u.DeviceName = fmt.Sprintf("U3-LV")
-	case 18:
-		u.DeviceName = fmt.Sprintf("U3-HV")
-	default:
-		u.DeviceName = fmt.Sprintf("Not recognized")
-	}
-
 }
 
-//parse the configIO recieve buffer and map into app.u3
+// parse the configIO recieve buffer and map into app.u3
 func (u *U3) parseBitBytes(recBuffer []byte) {
 
 	for i := 0; i < 8; i++ {
@@ -467,7 +226,7 @@ func (u *U3) parseBitBytes(recBuffer []byte) {
 	}
 }
 
-//parse the portDirRead recBuffer and map into app.u3
+// parse the portDirRead recBuffer and map into app.u3
 func (u *U3) parseDirBits(recBuffer []byte) {
 	for i := 0; i < 8; i++ {
 		if i > 3 {
@@ -489,6 +248,23 @@ func (u *U3) parseDirBits(recBuffer []byte) {
 	}
 }
 
+//parseBit puts the response of a Bit State Read or Bit Direction Read of
+//channel ch into its pin.
+func (u *U3) parseBit(op string, ch int, recBuffer []byte) {
+	pins := u.pins()
+	if ch >= len(pins) {
+		return
+	}
+	if op == jack.BitStateRead {
+		pins[ch].DigitalRead = jack.BitValue(recBuffer)
+		return
+	}
+	pins[ch].IO = "Input"
+	if jack.BitValue(recBuffer) == 1 {
+		pins[ch].IO = "Output"
+	}
+}
+
 func (u *U3) parseStateBits(recBuffer []byte) {
 	for i := 0; i < 8; i++ {
 		if i > 3 {
@@ -510,41 +286,30 @@ func (u *U3) parseStateBits(recBuffer []byte) {
 	}
 }
 
-const (
-	max      = 65535.0
-	hvSlope  = 10.3 / max
-	slope    = 2.44 / max
-	hvOffset = -5.0
-	offset   = -0.527
-)
-
-func (u *U3) parseAINBits(b byte, recBuffer []byte) {
-	ch := int(b & 0x1F)
-	fmt.Println("Channel: ", ch)
-	if ch < 8 {
-		if u.FIO[ch].AD == "Analog" {
-			read := uint16(recBuffer[9]) + uint16(recBuffer[10])*256
-			u.FIO[ch].AnalogRead = read
-			if ch < 4 {
-				u.FIO[ch].AnalogVoltage = fmt.Sprintf("%0.3f",
-					(float64(read)*hvSlope+hvOffset)*2)
-				return
-			}
-			u.FIO[ch].AnalogVoltage = fmt.Sprintf("%0.3f",
-				(float64(read)*slope+offset)*2)
-			return
-		}
+// pos is the positive channel byte of the AIN send buffer (with the settling
+// and quick sample bits).  The read is added to the pin samples, filterAIN turns
+// them into the values shown.
+func (u *U3) parseAINBits(pos byte, recBuffer []byte) {
+	ch := int(pos & 0x1F)
+	pin := u.FIO[ch%8]
+	if ch > 7 {
+		pin = u.EIO[ch%8]
+	}
+	if pin.AD != "Analog" {
+		return
 	}
-	ch -= 8
-	if u.EIO[ch].AD == "Analog" {
-		read := uint16(recBuffer[9]) + uint16(recBuffer[10])*256
-		u.EIO[ch].AnalogRead = read
-		u.EIO[ch].AnalogVoltage = fmt.Sprintf("%0.3f",
-			(float64(read)*slope+offset)*2)
+	read := jack.AINBits(recBuffer, 0)
+	pin.samples = append(pin.samples, read)
+}
+
+// same as parseAINBits for the ainBatch command, all reads are of one channel.
+func (u *U3) parseAINBatch(count int, pos byte, recBuffer []byte) {
+	for k := 0; k < count; k++ {
+		u.parseAINBits(pos, recBuffer[2*k:])
 	}
 }
 
-//helper function for processing FIO, EIO, and CIO bits when reading from flash.
+// helper function for processing FIO, EIO, and CIO bits when reading from flash.
 func (u *U3) parseFlashBytes(recBuffer []byte) {
 	for i := 0; i < 8; i++ {
 		u.FIO[i].AD = "Digital"
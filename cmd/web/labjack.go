@@ -1,114 +1,33 @@
 package main
 
-// #cgo CFLAGS: -g -Wall
-// #cgo amd64 386 CFLAGS: -DX86=1
-// #cgo LDFLAGS: -llabjackusb
-// #include <stdlib.h>
-//#include <stdio.h>
-//#include <errno.h>
-//#include <../../pkg/labjackusb/labjackusb.h>
-//#include <../../pkg/libusb/libusb.h>
-import "C"
 import (
 	"fmt"
-	"unsafe"
-)
-
-//All C dependencies are confined to this file.
+	"time"
 
-/*
-These constants are defined for constructing buffers in the C envirnment.
-C does not deal with variables for array construction.
-*/
-const (
-	eight       = 8
-	nine        = 9
-	ten         = 10
-	eleven      = 11
-	twelve      = 12
-	fourteen    = 14
-	fifteen = 15
-	twentysix   = 26
-	thirtyeight = 38
+	"github.com/Saied74/labjack/pkg/jack"
 )
 
-//This is a generic function for writing to the Labjack U3 and getting
-//the results back
-func (app *application) u3SendRec(op string, mask byte) {
-	sendBuffer := make([]byte, app.srData[op].sendLength)
-	recBuffer := make([]byte, app.srData[op].recLength)
-	//see labjackusb.h for documentation.
-	devHandle := C.LJUSB_OpenDevice(1, 0, C.U3_PRODUCT_ID)
-	if devHandle == nil {
-		app.u3.Message = fmt.Sprintf("Couldn't open U3. Please connect one and try again %v", devHandle)
-		fmt.Println("1: ", app.u3.Message)
-		return
-	}
-
-	app.srData[op].buildBytes(app.srData[op], sendBuffer, mask)
-
-	// Write the command to the device.
-	// LJUSB_Write( handle, sendBuffer, length of sendBuffer )
+//Labjack file connects the web program to the device through package jack,
+//which holds the protocol and all the C dependencies.
 
-	//pointer to the first byte of the sendBuffer, the way that C likes it.
-	sBuff := (*C.uchar)(unsafe.Pointer(&sendBuffer[0]))
-	//cast go int to C unsighed long
-	sBuffLength := C.ulong(app.srData[op].sendLength)
-	//write to the device.
-	r := C.LJUSB_Write(devHandle, sBuff, sBuffLength) //CONFIGU3_COMMAND_LENGTH)
-
-	// sendBuffer = (*[sBuffLength]byte)(unsafe.Pointer(sBuff))[:sBuffLength:sBuffLength]
-	if r != sBuffLength {
-		app.u3.Message = fmt.Sprintf("An error occurred when trying to write the buffer")
-		fmt.Println("2: ", app.u3.Message)
-		C.LJUSB_CloseDevice(devHandle)
-		return
-	}
-	// Read the result from the device.
-	// LJUSB_Read( handle, recBuffer, number of bytes to read)
-	rBuff := (*C.uchar)(unsafe.Pointer(&recBuffer[0]))
-	rBuffLength := C.ulong(app.srData[op].recLength)
-	r = C.LJUSB_Read(devHandle, rBuff, rBuffLength)
+//This is a generic function for writing to the Labjack U3 and getting
+//the results back.  The returned error is the same one put in app.u3.Message
+func (app *application) u3SendRec(op string, mask byte) error {
+	return app.u3SendRecDev(app.u3.DeviceNumber, op, mask)
+}
 
-	switch op {
-	case configJack:
-		recBuffer = (*[C.ulong(thirtyeight)]byte)(unsafe.Pointer(rBuff))[:C.ulong(thirtyeight):C.ulong(thirtyeight)]
-	case configIO:
-		recBuffer = (*[C.ulong(twelve)]byte)(unsafe.Pointer(rBuff))[:C.ulong(twelve):C.ulong(twelve)]
-	case ain:
-		recBuffer = (*[C.ulong(twelve)]byte)(unsafe.Pointer(rBuff))[:C.ulong(twelve):C.ulong(twelve)]
-	case led:
-		recBuffer = (*[C.ulong(nine)]byte)(unsafe.Pointer(rBuff))[:C.ulong(nine):C.ulong(nine)]
-	case portStateRead:
-		recBuffer = (*[C.ulong(twelve)]byte)(unsafe.Pointer(rBuff))[:C.ulong(twelve):C.ulong(twelve)]
-	case portStateWrite:
-		recBuffer = (*[C.ulong(ten)]byte)(unsafe.Pointer(rBuff))[:C.ulong(ten):C.ulong(ten)]
-	case portDirRead:
-		recBuffer = (*[C.ulong(twelve)]byte)(unsafe.Pointer(rBuff))[:C.ulong(twelve):C.ulong(twelve)]
-	case portDirWrite:
-		recBuffer = (*[C.ulong(ten)]byte)(unsafe.Pointer(rBuff))[:C.ulong(ten):C.ulong(ten)]
-	case tempSense:
-		recBuffer = (*[C.ulong(eight)]byte)(unsafe.Pointer(rBuff))[:C.ulong(eight):C.ulong(eight)]
-	case vReg:
-		recBuffer = (*[C.ulong(eight)]byte)(unsafe.Pointer(rBuff))[:C.ulong(eight):C.ulong(eight)]
-	}
+//returns the number of U3 devices connected to the USB bus.
+func u3DevCount() int {
+	return jack.DevCount()
+}
 
-	// recBuffer = (*[rBuffLength]byte)(unsafe.Pointer(rBuff))[:rBuffLength:rBuffLength]
-	if r != rBuffLength {
-		app.u3.Message = fmt.Sprintf("An error occurred when trying to read from the U3 r: %v, rBuffLength: %v", r, rBuffLength)
-		fmt.Println("3: ", app.u3.Message, recBuffer)
-		C.LJUSB_CloseDevice(devHandle)
-		return
-	}
-	fmt.Printf("Send Buffer (op: %s): %v\n", op, sendBuffer)
-	fmt.Printf("Rec Buffer (op: %s): %v\n", op, recBuffer)
-	// Check the command for errors
-	if err := app.srData[op].checkReturn(app.srData[op], recBuffer); err != nil {
-		// if err := app.checkResponse(op, recBuffer); err != nil {
-		C.LJUSB_CloseDevice(devHandle)
+//same as u3SendRec but talks to device number devNum (starting at 1) rather
+//than the selected device.
+func (app *application) u3SendRecDev(devNum int, op string, mask byte) error {
+	sendBuffer, recBuffer, err := app.exchange(devNum, op, mask)
+	if err != nil {
 		app.u3.Message = fmt.Sprintf("%v", err)
-		fmt.Println("4: ", app.u3.Message)
-		return
+		return err
 	}
 	/*
 		Parsing the return bytes and putting the results into the U3 structure were
@@ -119,19 +38,64 @@ func (app *application) u3SendRec(op string, mask byte) {
 	*/
 
 	switch op {
-	case configJack:
+	case jack.ConfigJack:
 		app.u3.parseConfigU3Bytes(recBuffer)
-	case configIO:
+	case jack.ConfigIO:
 		app.u3.parseBitBytes(recBuffer)
-	case portDirRead:
+	case jack.PortDirRead:
 		app.u3.parseDirBits(recBuffer)
-	case portStateRead:
+	case jack.PortStateRead:
 		app.u3.parseStateBits(recBuffer)
-	case ain:
+	case jack.BitStateRead, jack.BitDirRead:
+		app.u3.parseBit(op, int(sendBuffer[8]&0x1F), recBuffer)
+	case jack.AIN:
 		app.u3.parseAINBits(sendBuffer[8], recBuffer)
+	case jack.AINBatch:
+		app.u3.parseAINBatch(app.srData[op].Count, sendBuffer[8], recBuffer)
+	case jack.TempSense:
+		app.u3.Temperature = app.u3.cal.Temperature(float64(jack.AINBits(recBuffer, 0))) - 273.15
+		app.u3.temperatureRead = true
+	case jack.ReadMem:
+		app.u3.cal.ParseBlock(sendBuffer[7], recBuffer)
 	}
-	//Close the device.
-	C.LJUSB_CloseDevice(devHandle)
 	app.u3.Message = "No Message"
+	return nil
+}
+
+//exchange sends op to device devNum and returns the buffers without parsing
+//the response into app.u3, for reading a device other than the selected one.
+//Every send goes in the transaction log, see txlog.go.  The buffers are only
+//printed with the -d option.
+func (app *application) exchange(devNum int, op string, mask byte) ([]byte, []byte, error) {
+	start := time.Now()
+	sendBuffer, recBuffer, err := jack.SendRec(devNum, app.srData[op], mask)
+	tx := Transaction{Time: start, Device: devNum, Op: op, WriteMask: int(mask),
+		Request: jack.DecodeRequest(op, sendBuffer), Latency: time.Since(start)}
+	if err != nil {
+		tx.Error = err.Error()
+		app.logTransaction(tx)
+		app.metrics.record(devNum, op, tx.Latency, errorCode(err, recBuffer))
+		app.errorLog.Printf("%s on device %d: %v", op, devNum, err)
+		return sendBuffer, recBuffer, err
+	}
+	tx.Response = jack.DecodeResponse(op, recBuffer)
+	app.logTransaction(tx)
+	app.metrics.record(devNum, op, tx.Latency, "")
+	if app.debugOption {
+		fmt.Printf("Send Buffer (op: %s): %v\n", op, sendBuffer)
+		fmt.Printf("Rec Buffer (op: %s): %v\n", op, recBuffer)
+	}
+	if op == jack.ConfigJack {
+		app.metrics.serials[devNum] = jack.ParseConfig(recBuffer).SerialNumber
+	}
+	return sendBuffer, recBuffer, nil
+}
 
+//readConfig reads the ConfigU3 of device devNum, leaving app.u3 alone.
+func (app *application) readConfig(devNum int) (jack.Config, error) {
+	_, recBuffer, err := app.exchange(devNum, jack.ConfigJack, 0x00)
+	if err != nil {
+		return jack.Config{}, err
+	}
+	return jack.ParseConfig(recBuffer), nil
 }
@@ -23,6 +23,10 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"sync"
+	"time"
+
+	"github.com/Saied74/labjack/pkg/jack"
 )
 
 /*
@@ -38,7 +42,26 @@ srData (short for send/recieve data) Is the collection of the models for the
 device commands.  Additionally, it holds fields for forming and testing the sent
 and recieved byte slices for each individual command.
 
-u3 and srData fields are described in the jack.go file.
+u3 is described in the jack.go file and srData in package jack.
+
+mu serializes access to the device, u3 and srData.  Handlers, the goroutine
+that blinks the LED for identifying a device and the background poll all go
+through it.
+
+alarmFile, notifiers and events are for the alarms, see alarm.go and notify.go.
+ruleFile is for the interlocks, see interlock.go, and patternFile and
+patternStop for the output patterns, see pattern.go.  waveStop is for the
+DAC waveforms, see waveform.go.  sequenceDir and reportDir are
+for the test sequences, see sequence.go.  users is nil unless the -users file
+is given, see auth.go, and audit gets the changes made by each user.  origin,
+transactions, lastTx, txPath, txFile and txSize are the transaction log, see
+txlog.go.  metrics counts the transactions and acquisitions for /metrics, see
+metrics.go.  mqtt is nil unless -mqtt is given, see mqtt.go, and influx unless
+-influx is, see influx.go.  history keeps the samples on disk when -history is
+given, see history.go.
+
+The templates and static files are built into the binary (see ui/efs.go) so it
+can be run from any directory.
 */
 
 //for injecting data into handlers
@@ -48,20 +71,136 @@ type application struct {
 	debugOption   bool
 	templateCache map[string]*template.Template
 	u3            *U3
-	srData        u3srData
+	srData        jack.Commands
+	mu            sync.Mutex
+	alarmFile     string
+	notifiers     []notifier
+	events        chan AlarmEvent
+	ruleFile      string
+	patternFile   string
+	patternStop   chan struct{}    //closed to stop the pattern playing, see pattern.go
+	waveStop      [2]chan struct{} //closed to stop the waveform on each DAC, see waveform.go
+	sequenceDir   string
+	reportDir     string
+	users         *userStore
+	audit         *log.Logger
+	tls           bool
+	origin        string
+	transactions  []Transaction
+	lastTx        int
+	txPath        string
+	txFile        *os.File
+	txSize        int64
+	metrics       metrics
+	polling       bool
+	mqtt          *mqttClient
+	influx        *influxSink
+	history       *history
 }
 
 func main() {
 	var err error
 
 	optionDebug := flag.Bool("d", false, "true turns on debug option")
+	devNum := flag.Int("n", 1, "device number of the U3 to talk to, starting at 1")
+	pollEvery := flag.Duration("poll", 0, "acquire in the background this often, 0 for only on the measurement page")
+	alarmFile := flag.String("alarms", "", "file to keep the alarm rules in")
+	alarmLog := flag.String("alarmlog", "", "file to append alarm events to")
+	webhook := flag.String("webhook", "", "url to POST alarm events to")
+	alarmExec := flag.String("alarmexec", "", "command to run for each alarm event")
+	ruleFile := flag.String("rules", "", "file to keep the interlock rules in")
+	patternFile := flag.String("patterns", "", "file to keep the digital output patterns in")
+	sequenceDir := flag.String("sequences", "", "directory to keep the test sequences in")
+	reportDir := flag.String("reports", "", "directory to write a report of each sequence run to")
+	addr := flag.String("addr", ":4000", "address to listen on")
+	tlsCert := flag.String("tlscert", "", "TLS certificate file, serves https when given with -tlskey")
+	tlsKey := flag.String("tlskey", "", "TLS key file")
+	uiDir := flag.String("ui", "", "directory to load templates and static files from instead of the built in copy")
+	flag.String("config", "", "file of flag settings, one name = value per line")
+	usersFile := flag.String("users", "", "file of user accounts, anyone can do anything without it")
+	sessionLife := flag.Duration("session", 12*time.Hour, "how long a login lasts")
+	addUserSpec := flag.String("adduser", "", "name:role, adds the user to the -users file with the password read from stdin and exits")
+	auditFile := flag.String("auditlog", "", "file to append the audit log to, standard output without it")
+	txFile := flag.String("txlog", "", "file to append every command sent to the device to")
+	traceFile := flag.String("trace", "", "file to record the raw buffers sent to and read from the device to")
+	replayFile := flag.String("replay", "", "trace to play back instead of talking to the device")
+	replayStrict := flag.Bool("replaystrict", false, "play the -replay trace back strictly in order")
+	mqttBroker := flag.String("mqtt", "", "MQTT broker host:port to publish the readings to and take commands from")
+	mqttClientID := flag.String("mqttclient", "u3web", "MQTT client id")
+	mqttUser := flag.String("mqttuser", "", "MQTT user name")
+	mqttPassword := flag.String("mqttpassword", "", "MQTT password, better set in the environment or the config file")
+	mqttTopic := flag.String("mqtttopic", "u3/{device}/{pin}", "MQTT topic of the readings, {device}, {serial}, {pin} and {name} are filled in")
+	mqttSet := flag.String("mqttset", "u3/{device}/set/{pin}", "MQTT topic of the commands, {pin} is the pin or DAC to set")
+	mqttRetain := flag.Bool("mqttretain", false, "publish the readings retained")
+	modbusAddr := flag.String("modbus", "", "address to serve Modbus TCP on, :502 for example")
+	unauthenticated := flag.Bool("unauthenticated", false, "serve -modbus and -scpi and take -mqtt commands with -users anyway, they have no login so anyone who can reach them can write")
+	scpiAddr := flag.String("scpi", "", "address to take SCPI commands on, :5025 for example")
+	influxURL := flag.String("influx", "", "InfluxDB write url to send every acquisition to as line protocol, with the db or bucket in it")
+	influxToken := flag.String("influxtoken", "", "InfluxDB 2.x api token, better set in the environment or the config file")
+	influxMeasurement := flag.String("influxmeasurement", "u3", "InfluxDB measurement name")
+	influxBatch := flag.Int("influxbatch", 500, "lines to send to InfluxDB at once")
+	influxFlush := flag.Duration("influxflush", 10*time.Second, "longest time lines wait for a batch to fill")
+	influxSpool := flag.String("influxspool", "", "directory to keep the batches InfluxDB could not be reached for until it can")
+	historyDir := flag.String("history", "", "directory to keep the samples of every acquisition in, for the charts page")
+	historyRaw := flag.Duration("historyraw", 24*time.Hour, "how long every sample is kept")
+	historyMin := flag.Duration("historymin", 30*24*time.Hour, "how long the one minute aggregates are kept")
+	grpcAddr := flag.String("grpc", "", "address to serve the gRPC api on, :50051 for example")
 	flag.Parse()
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime|log.LUTC)
 	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.LUTC|log.Llongfile)
 
-	//note, this requires the run command be issues from the project base
-	templateCache, err := newTemplateCache("./ui/html/")
+	//flags can also come from the environment and a config file, see config.go
+	if err := applyConfig(flag.CommandLine); err != nil {
+		errorLog.Fatal(err)
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		errorLog.Fatal("-tlscert and -tlskey go together")
+	}
+
+	audit := log.New(os.Stdout, "AUDIT\t", log.Ldate|log.Ltime|log.LUTC)
+	if *auditFile != "" {
+		f, err := os.OpenFile(*auditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		defer f.Close()
+		audit.SetOutput(f)
+	}
+	var users *userStore
+	if *usersFile != "" {
+		users = newUserStore(*usersFile, *sessionLife)
+		if err := users.load(); err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+	if *addUserSpec != "" {
+		if users == nil {
+			errorLog.Fatal("-adduser needs -users")
+		}
+		if err := addUser(users, *addUserSpec, audit); err != nil {
+			errorLog.Fatal(err)
+		}
+		return
+	}
+	if users == nil {
+		infoLog.Printf("no -users file, anyone who can reach the server can control the device")
+	} else if len(users.list()) == 0 {
+		errorLog.Fatalf("%s has no users, add one with -adduser name:admin", *usersFile)
+	}
+
+	//see trace.go in package jack for the trace format
+	closeTrace, err := jack.UseTrace(*traceFile, *replayFile, *replayStrict)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	defer closeTrace()
+	if *replayFile != "" {
+		infoLog.Printf("playing back %s, no device is used", *replayFile)
+	}
+
+	files := uiFiles(*uiDir)
+	templateCache, err := newTemplateCache(files)
 	if err != nil {
 		errorLog.Fatal(err)
 	}
@@ -72,35 +211,178 @@ func main() {
 		debugOption:   *optionDebug,
 		templateCache: templateCache,
 		u3:            newU3(),
-		srData:        buildU3srData(),
+		srData:        jack.NewCommands(),
+		users:         users,
+		audit:         audit,
+		tls:           *tlsCert != "",
+	}
+	app.u3.DeviceNumber = *devNum
+	if *txFile != "" {
+		if err := app.openTxLog(*txFile); err != nil {
+			errorLog.Fatal(err)
+		}
+		defer app.txFile.Close()
+	}
+	app.alarmFile = *alarmFile
+	if err := app.loadAlarms(); err != nil {
+		errorLog.Fatal(err)
+	}
+	app.ruleFile = *ruleFile
+	if err := app.loadRules(); err != nil {
+		errorLog.Fatal(err)
+	}
+	app.patternFile = *patternFile
+	if err := app.loadPatterns(); err != nil {
+		errorLog.Fatal(err)
+	}
+	app.sequenceDir = *sequenceDir
+	app.reportDir = *reportDir
+	sinks := []notifier{}
+	if *alarmLog != "" {
+		sinks = append(sinks, &logFileSink{path: *alarmLog})
+	}
+	if *webhook != "" {
+		sinks = append(sinks, newWebhookSink(*webhook))
+	}
+	if *alarmExec != "" {
+		sinks = append(sinks, &execSink{command: *alarmExec})
+	}
+	app.startNotifiers(sinks)
+	if *mqttBroker != "" {
+		if app.users != nil && !*unauthenticated {
+			errorLog.Fatal("-mqtt commands have no login and would get around -users, give -unauthenticated too to connect anyway")
+		}
+		app.mqtt, err = newMQTTClient(*mqttBroker, *mqttClientID, *mqttUser, *mqttPassword,
+			*mqttTopic, *mqttSet, *mqttRetain)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		go app.runMQTT()
+	}
+	if *historyDir != "" {
+		if app.history, err = openHistory(*historyDir, *historyRaw, *historyMin); err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+	if *influxURL != "" {
+		app.influx, err = newInfluxSink(*influxURL, *influxToken, *influxMeasurement, *influxSpool,
+			*influxBatch, *influxFlush)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		go app.influx.run(errorLog)
+	}
+	if *modbusAddr != "" {
+		if app.users != nil && !*unauthenticated {
+			errorLog.Fatal("-modbus has no login and would get around -users, give -unauthenticated too to serve it anyway")
+		}
+		go func() { errorLog.Fatal(app.serveModbus(*modbusAddr)) }()
+	}
+	if *scpiAddr != "" {
+		if app.users != nil && !*unauthenticated {
+			errorLog.Fatal("-scpi has no login and would get around -users, give -unauthenticated too to serve it anyway")
+		}
+		go func() { errorLog.Fatal(app.serveSCPI(*scpiAddr)) }()
+	}
+	if *grpcAddr != "" {
+		go func() { errorLog.Fatal(app.serveGRPC(*grpcAddr, *tlsCert, *tlsKey)) }()
+	}
+	if *pollEvery > 0 {
+		infoLog.Printf("acquiring every %v", *pollEvery)
+		app.polling = true
+		go app.poll(*pollEvery)
 	}
 
 	mux := app.routes()
 	srv := &http.Server{
-		Addr:     ":4000",
+		Addr:     *addr,
 		ErrorLog: errorLog,
 		Handler:  mux,
 	}
-	fileServer := http.FileServer(http.Dir("./ui/static/"))
-	mux.Handle("/static/", http.StripPrefix("/static", fileServer))
-	infoLog.Printf("starting server on :4000")
-	err = srv.ListenAndServe()
+	fileServer := http.FileServer(http.FS(files))
+	mux.Handle("/static/", fileServer) //not behind a login
+	if *tlsCert != "" {
+		infoLog.Printf("starting server on %s with TLS", *addr)
+		err = srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		infoLog.Printf("starting server on %s", *addr)
+		err = srv.ListenAndServe()
+	}
 	errorLog.Fatal(err)
 }
 
 /*
 clicking on each link on a web page invokes the corresponding function
-The functions are located in the handlers file.
+The functions are located in the handlers file.  Each one is wrapped in
+require with the least role that may use it, see auth.go.  The api handlers
+are viewer since anything but a GET needs operator anyway, the page forms are
+postOnly.
 */
 func (app *application) routes() *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/home", app.home)
-	mux.HandleFunc("/flash", app.flash)
-	mux.HandleFunc("/getConfig", app.getConfig)
-	mux.HandleFunc("/configure", app.configure)
-	mux.HandleFunc("/measure", app.measure)
-	mux.HandleFunc("/updateDigital", app.updateDigital)
-	mux.HandleFunc("/adjustments", app.notImplemented)
-	mux.HandleFunc("/readjust", app.notImplemented)
+	mux.HandleFunc("/home", app.require(roleViewer, app.home))
+	mux.HandleFunc("/flash", app.require(roleViewer, app.flash))
+	mux.HandleFunc("/getConfig", app.require(roleViewer, app.getConfig))
+	mux.HandleFunc("/configure", app.require(roleOperator, postOnly(app.configure)))
+	mux.HandleFunc("/measure", app.require(roleViewer, app.measure))
+	mux.HandleFunc("/updateDigital", app.require(roleOperator, postOnly(app.updateDigital)))
+	mux.HandleFunc("/devices", app.require(roleViewer, app.devices))
+	mux.HandleFunc("/selectDevice", app.require(roleOperator, postOnly(app.selectDevice)))
+	mux.HandleFunc("/setLED", app.require(roleOperator, postOnly(app.setLED)))
+	mux.HandleFunc("/identify", app.require(roleOperator, postOnly(app.identify)))
+	mux.HandleFunc("/channels", app.require(roleViewer, app.channels))
+	mux.HandleFunc("/updateChannels", app.require(roleOperator, postOnly(app.updateChannels)))
+	mux.HandleFunc("/export", app.require(roleViewer, app.export))
+	mux.HandleFunc("/alarms", app.require(roleViewer, app.alarms))
+	mux.HandleFunc("/addAlarm", app.require(roleOperator, postOnly(app.addAlarmForm)))
+	mux.HandleFunc("/deleteAlarm", app.require(roleOperator, postOnly(app.deleteAlarmForm)))
+	mux.HandleFunc("/ackAlarm", app.require(roleOperator, postOnly(app.ackAlarmForm)))
+	mux.HandleFunc("/edges", app.require(roleViewer, app.edges))
+	mux.HandleFunc("/setEdge", app.require(roleOperator, postOnly(app.setEdgeForm)))
+	mux.HandleFunc("/resetEdges", app.require(roleOperator, postOnly(app.resetEdgesForm)))
+	mux.HandleFunc("/patterns", app.require(roleViewer, app.patterns))
+	mux.HandleFunc("/addPattern", app.require(roleOperator, postOnly(app.addPatternForm)))
+	mux.HandleFunc("/deletePattern", app.require(roleOperator, postOnly(app.deletePatternForm)))
+	mux.HandleFunc("/playPattern", app.require(roleOperator, postOnly(app.playPatternForm)))
+	mux.HandleFunc("/stopPattern", app.require(roleOperator, postOnly(app.stopPatternForm)))
+	mux.HandleFunc("/waves", app.require(roleViewer, app.waves))
+	mux.HandleFunc("/playWave", app.require(roleOperator, postOnly(app.playWaveForm)))
+	mux.HandleFunc("/stopWave", app.require(roleOperator, postOnly(app.stopWaveForm)))
+	mux.HandleFunc("/rules", app.require(roleViewer, app.rules))
+	mux.HandleFunc("/addRule", app.require(roleOperator, postOnly(app.addRuleForm)))
+	mux.HandleFunc("/deleteRule", app.require(roleOperator, postOnly(app.deleteRuleForm)))
+	mux.HandleFunc("/sequences", app.require(roleViewer, app.sequences))
+	mux.HandleFunc("/saveSequence", app.require(roleOperator, postOnly(app.saveSequenceForm)))
+	mux.HandleFunc("/runSequence", app.require(roleOperator, postOnly(app.runSequenceForm)))
+	mux.HandleFunc("/report", app.require(roleViewer, app.report))
+	mux.HandleFunc("/api/u3", app.require(roleViewer, app.apiU3))
+	mux.HandleFunc("/api/pin", app.require(roleViewer, app.apiPin))
+	mux.HandleFunc("/api/bit", app.require(roleViewer, app.apiBit))
+	mux.HandleFunc("/api/alarms", app.require(roleViewer, app.apiAlarms))
+	mux.HandleFunc("/api/alarms/ack", app.require(roleViewer, app.apiAckAlarm))
+	mux.HandleFunc("/api/edges", app.require(roleViewer, app.apiEdges))
+	mux.HandleFunc("/api/edges/reset", app.require(roleViewer, app.apiResetEdges))
+	mux.HandleFunc("/api/patterns", app.require(roleViewer, app.apiPatterns))
+	mux.HandleFunc("/api/patterns/play", app.require(roleViewer, app.apiPlayPattern))
+	mux.HandleFunc("/api/patterns/stop", app.require(roleViewer, app.apiStopPattern))
+	mux.HandleFunc("/api/waves", app.require(roleViewer, app.apiWaves))
+	mux.HandleFunc("/api/waves/stop", app.require(roleViewer, app.apiStopWave))
+	mux.HandleFunc("/api/rules", app.require(roleViewer, app.apiRules))
+	mux.HandleFunc("/api/sequences", app.require(roleViewer, app.apiSequences))
+	mux.HandleFunc("/api/sequences/run", app.require(roleViewer, app.apiRunSequence))
+	mux.HandleFunc("/transactions", app.require(roleViewer, app.transactionsPage))
+	mux.HandleFunc("/transactions/export", app.require(roleViewer, app.exportTransactions))
+	mux.HandleFunc("/api/transactions", app.require(roleViewer, app.apiTransactions))
+	mux.HandleFunc("/decode", app.require(roleViewer, app.decodePage))
+	mux.HandleFunc("/charts", app.require(roleViewer, app.chartsPage))
+	mux.HandleFunc("/api/history", app.require(roleViewer, app.apiHistory))
+	mux.HandleFunc("/metrics", app.require(roleViewer, app.metricsPage))
+	mux.HandleFunc("/login", app.login)
+	mux.HandleFunc("/logout", app.logout)
+	mux.HandleFunc("/users", app.require(roleAdmin, app.usersPage))
+	mux.HandleFunc("/setUser", app.require(roleAdmin, postOnly(app.setUserForm)))
+	mux.HandleFunc("/deleteUser", app.require(roleAdmin, postOnly(app.deleteUserForm)))
+	mux.HandleFunc("/adjustments", app.require(roleViewer, app.notImplemented))
+	mux.HandleFunc("/readjust", app.require(roleViewer, app.notImplemented))
 	return mux
 }
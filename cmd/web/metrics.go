@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Saied74/labjack/pkg/jack"
+)
+
+/*
+Metrics file serves /metrics in the Prometheus text format, written by hand
+rather than with the client library.  It has the last acquisition of the
+selected device (volts, engineering units and digital states by channel, and
+the temperature of the U3) and, for every device talked to, the number of
+transactions, the failed ones by error code and a histogram of the latencies.
+Everything is labelled with the device number and serial number, the serial
+being the last one read from that device (empty until then).
+
+A scrape does not touch the device, it serves the last acquisition with its
+time and the count of the acquisitions that failed.  Without -poll that is
+whenever a page last measured, so run with -poll for values that keep up.
+With a -users file Prometheus logs in with basic auth as a viewer.
+*/
+
+//latencyBuckets are the upper bounds of the latency histogram, in seconds.
+var latencyBuckets = []float64{0.0005, 0.001, 0.002, 0.005, 0.01, 0.02, 0.05, 0.1, 0.25, 0.5, 1}
+
+type txKey struct {
+	device int
+	op     string
+}
+
+type errorKey struct {
+	device int
+	op     string
+	code   string
+}
+
+type histogram struct {
+	buckets []uint64 //count in each bucket, not cumulative
+	sum     float64
+	count   uint64
+}
+
+//metrics are the counters of the transactions and acquisitions.  They are
+//kept under app.mu.
+type metrics struct {
+	serials       map[int]string //device number to serial number
+	latency       map[txKey]*histogram
+	errors        map[errorKey]uint64
+	acquired      time.Time //last acquisition that worked
+	acquireFailed uint64
+}
+
+//record counts a transaction with device devNum.  code is "" when it worked.
+func (m *metrics) record(devNum int, op string, latency time.Duration, code string) {
+	if m.latency == nil {
+		m.serials = map[int]string{}
+		m.latency = map[txKey]*histogram{}
+		m.errors = map[errorKey]uint64{}
+	}
+	h := m.latency[txKey{devNum, op}]
+	if h == nil {
+		h = &histogram{buckets: make([]uint64, len(latencyBuckets))}
+		m.latency[txKey{devNum, op}] = h
+	}
+	s := latency.Seconds()
+	for i, le := range latencyBuckets {
+		if s <= le {
+			h.buckets[i]++
+			break
+		}
+	}
+	h.sum += s
+	h.count++
+	if code != "" {
+		m.errors[errorKey{devNum, op, code}]++
+	}
+}
+
+//errorCode labels a failed transaction: the errorcode the U3 returned,
+//"transport" when the exchange itself failed or "response" for a response
+//that failed the checks.
+func errorCode(err error, recBuffer []byte) string {
+	var te *jack.TransportError
+	switch {
+	case errors.As(err, &te):
+		return "transport"
+	case len(recBuffer) > 6 && recBuffer[6] != 0:
+		return strconv.Itoa(int(recBuffer[6]))
+	}
+	return "response"
+}
+
+func (app *application) metricsPage(w http.ResponseWriter, r *http.Request) {
+	app.lock(origin(r))
+	defer app.unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	app.writeMetrics(w)
+}
+
+//label escapes a label value.
+func label(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func number(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+//writeMetrics writes everything in the text format.  Must be called with
+//app.mu held.
+func (app *application) writeMetrics(w io.Writer) {
+	m := &app.metrics
+	serial := func(devNum int) string {
+		if devNum == app.u3.DeviceNumber && app.u3.SerialNumber != "" {
+			return app.u3.SerialNumber
+		}
+		return m.serials[devNum]
+	}
+	dev := fmt.Sprintf(`device="%d",serial="%s"`, app.u3.DeviceNumber, label(serial(app.u3.DeviceNumber)))
+	pin := func(p *Pin) string {
+		return fmt.Sprintf(`%s,channel="%s",name="%s"`, dev, label(p.Label), label(p.Name))
+	}
+
+	header(w, "u3_channel_volts", "gauge", "Filtered voltage of an analog channel.")
+	for _, p := range app.u3.pins() {
+		if v, err := strconv.ParseFloat(p.FilteredVoltage, 64); p.AD == "Analog" && err == nil {
+			fmt.Fprintf(w, "u3_channel_volts{%s} %s\n", pin(p), number(v))
+		}
+	}
+	header(w, "u3_channel_value", "gauge", "Analog channel in its engineering unit, see the channels page.")
+	for _, p := range app.u3.pins() {
+		if v, analog, ok := p.pinValue(); ok && analog {
+			fmt.Fprintf(w, "u3_channel_value{%s,unit=\"%s\"} %s\n", pin(p), label(p.Unit), number(v))
+		}
+	}
+	header(w, "u3_digital_state", "gauge", "State of a digital channel, read for inputs and written for outputs.")
+	for _, p := range app.u3.pins() {
+		if v, analog, ok := p.pinValue(); ok && !analog {
+			fmt.Fprintf(w, "u3_digital_state{%s,direction=\"%s\"} %s\n", pin(p), label(p.IO), number(v))
+		}
+	}
+	if app.u3.temperatureRead {
+		header(w, "u3_temperature_celsius", "gauge", "Internal temperature of the U3.")
+		fmt.Fprintf(w, "u3_temperature_celsius{%s} %s\n", dev, number(app.u3.Temperature))
+	}
+	if !m.acquired.IsZero() {
+		header(w, "u3_last_acquisition_timestamp_seconds", "gauge", "When the values above were acquired.")
+		fmt.Fprintf(w, "u3_last_acquisition_timestamp_seconds{%s} %s\n", dev,
+			number(float64(m.acquired.UnixNano())/1e9))
+	}
+	header(w, "u3_acquisition_errors_total", "counter", "Acquisitions that failed, by the poll or a page.")
+	fmt.Fprintf(w, "u3_acquisition_errors_total{%s} %d\n", dev, m.acquireFailed)
+
+	keys := []txKey{}
+	for k := range m.latency {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].device != keys[j].device {
+			return keys[i].device < keys[j].device
+		}
+		return keys[i].op < keys[j].op
+	})
+	txLabels := func(devNum int, op string) string {
+		return fmt.Sprintf(`device="%d",serial="%s",op="%s"`, devNum, label(serial(devNum)), label(op))
+	}
+	header(w, "u3_transactions_total", "counter", "Commands sent to the device.")
+	for _, k := range keys {
+		fmt.Fprintf(w, "u3_transactions_total{%s} %d\n", txLabels(k.device, k.op), m.latency[k].count)
+	}
+	header(w, "u3_transaction_errors_total", "counter",
+		"Failed commands by error code: the errorcode of the U3, transport or response.")
+	errKeys := []errorKey{}
+	for k := range m.errors {
+		errKeys = append(errKeys, k)
+	}
+	sort.Slice(errKeys, func(i, j int) bool {
+		a, b := errKeys[i], errKeys[j]
+		if a.device != b.device {
+			return a.device < b.device
+		}
+		if a.op != b.op {
+			return a.op < b.op
+		}
+		return a.code < b.code
+	})
+	for _, k := range errKeys {
+		fmt.Fprintf(w, "u3_transaction_errors_total{%s,code=\"%s\"} %d\n",
+			txLabels(k.device, k.op), label(k.code), m.errors[k])
+	}
+	header(w, "u3_transaction_duration_seconds", "histogram", "Time from sending a command to having its response.")
+	for _, k := range keys {
+		h := m.latency[k]
+		l := txLabels(k.device, k.op)
+		cum := uint64(0)
+		for i, le := range latencyBuckets {
+			cum += h.buckets[i]
+			fmt.Fprintf(w, "u3_transaction_duration_seconds_bucket{%s,le=\"%s\"} %d\n", l, number(le), cum)
+		}
+		fmt.Fprintf(w, "u3_transaction_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", l, h.count)
+		fmt.Fprintf(w, "u3_transaction_duration_seconds_sum{%s} %s\n", l, number(h.sum))
+		fmt.Fprintf(w, "u3_transaction_duration_seconds_count{%s} %d\n", l, h.count)
+	}
+}
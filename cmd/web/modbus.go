@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"time"
+
+	"github.com/Saied74/labjack/pkg/jack"
+)
+
+/*
+Modbus file holds a Modbus TCP server, turned on with -modbus and an address
+such as :502, for PLC and SCADA software.  It goes through the same app.u3 and
+write functions as the pages, holding app.mu for each request.  Any unit id is
+answered.  Addresses start at 0, the channel numbers are the ones of
+jack.Channel (FIO0-7 are 0-7, EIO0-7 are 8-15 and CIO0-3 are 16-19).
+
+	discrete inputs  0-19    state of the digital inputs, 0 for other pins
+	coils            0-19    state of the digital outputs, writing one that is
+	                         not a digital output is an illegal data address
+	input registers  0-15    raw read of analog channel 0-15
+	                 100-131 value of analog channel 0-15 in its engineering
+	                         unit, a 32 bit float in two registers each
+	                 200-201 temperature of the U3, degrees C, 32 bit float
+	holding registers 0-3    DAC0 and DAC1 in volts, 32 bit floats, written
+	                         with function 16 as whole pairs
+	                 10-11   DAC0 and DAC1 in millivolts
+
+Floats are IEEE 754 with the high word first.  Registers in the gaps read as
+0.  Functions 1, 2, 3, 4, 5, 6, 15 and 16 are served.  Without -poll the
+discrete inputs and input registers are read from the device for each request
+that asks for them, like the measurement page.  Every write goes in the audit
+log.
+
+Modbus has no login, so whoever reaches the port can write the outputs and
+DACs whatever the -users file says.  With -users the program will not serve it
+unless -unauthenticated is given too, and then the port should only be
+reachable from the PLC, by the address it listens on or a firewall.
+*/
+
+const (
+	modbusTimeout = 5 * time.Minute //idle connections are dropped after this
+
+	modbusPins        = 20
+	modbusRawRegs     = 0
+	modbusValueRegs   = 100
+	modbusTempRegs    = 200
+	modbusInputRegs   = 202 //size of the input register table
+	modbusDACRegs     = 0
+	modbusDACmVRegs   = 10
+	modbusHoldingRegs = 12 //size of the holding register table
+)
+
+//Modbus exception codes.
+const (
+	modbusIllegalFunction = 1
+	modbusIllegalAddress  = 2
+	modbusIllegalValue    = 3
+	modbusDeviceFailure   = 4
+)
+
+//modbusError is an exception response.
+type modbusError struct {
+	code byte
+	err  error
+}
+
+func (e *modbusError) Error() string { return e.err.Error() }
+
+func modbusErr(code byte, format string, a ...interface{}) *modbusError {
+	return &modbusError{code, fmt.Errorf(format, a...)}
+}
+
+//serveModbus listens on addr and serves each connection, it only returns
+//when the listener fails.
+func (app *application) serveModbus(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	app.infoLog.Printf("modbus server on %s", addr)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go app.modbusConn(conn)
+	}
+}
+
+//modbusConn serves the requests on conn one after the other.
+func (app *application) modbusConn(conn net.Conn) {
+	defer conn.Close()
+	from := "modbus " + conn.RemoteAddr().String()
+	header := make([]byte, 7)
+	for {
+		conn.SetReadDeadline(time.Now().Add(modbusTimeout))
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		//transaction id, protocol id (0), length (unit id and PDU), unit id
+		n := int(binary.BigEndian.Uint16(header[4:]))
+		if binary.BigEndian.Uint16(header[2:]) != 0 || n < 2 || n > 254 {
+			app.errorLog.Printf("%s: bad MBAP header % x", from, header)
+			return
+		}
+		pdu := make([]byte, n-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+		resp := app.modbusRequest(from, pdu)
+		out := make([]byte, 7, 7+len(resp))
+		copy(out, header)
+		binary.BigEndian.PutUint16(out[4:], uint16(len(resp)+1))
+		if _, err := conn.Write(append(out, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+//modbusRequest answers the request pdu (function code and data) with the
+//response pdu.
+func (app *application) modbusRequest(from string, pdu []byte) []byte {
+	app.lock(from)
+	defer app.unlock()
+	resp, err := app.modbusFunction(from, pdu)
+	if err != nil {
+		e, ok := err.(*modbusError)
+		if !ok {
+			e = &modbusError{modbusDeviceFailure, err}
+		}
+		app.errorLog.Printf("%s: function %d: %v", from, pdu[0], e.err)
+		return []byte{pdu[0] | 0x80, e.code}
+	}
+	return resp
+}
+
+//modbusFunction carries out one request.  Must be called with app.mu held.
+func (app *application) modbusFunction(from string, pdu []byte) ([]byte, error) {
+	fc := pdu[0]
+	if len(pdu) < 5 {
+		return nil, modbusErr(modbusIllegalValue, "request too short")
+	}
+	addr := int(binary.BigEndian.Uint16(pdu[1:]))
+	count := int(binary.BigEndian.Uint16(pdu[3:]))
+	switch fc {
+	case 1, 2:
+		if count < 1 || count > 2000 {
+			return nil, modbusErr(modbusIllegalValue, "%d bits", count)
+		}
+		if addr+count > modbusPins {
+			return nil, modbusErr(modbusIllegalAddress, "bits %d to %d", addr, addr+count-1)
+		}
+		if fc == 2 && !app.polling {
+			if err := app.acquire(); err != nil {
+				return nil, err
+			}
+		}
+		out := make([]byte, 2+(count+7)/8)
+		out[0], out[1] = fc, byte(len(out)-2)
+		for i := 0; i < count; i++ {
+			pin := app.u3.pins()[addr+i]
+			on := pin.AD == "Digital" && pin.IO == "Output" && pin.DigitalWrite == 1
+			if fc == 2 {
+				on = pin.AD == "Digital" && pin.IO == "Input" && pin.DigitalRead == 1
+			}
+			if on {
+				out[2+i/8] |= 1 << (i % 8)
+			}
+		}
+		return out, nil
+	case 3, 4:
+		if count < 1 || count > 125 {
+			return nil, modbusErr(modbusIllegalValue, "%d registers", count)
+		}
+		regs := app.holdingRegisters()
+		if fc == 4 {
+			if !app.polling {
+				if err := app.acquire(); err != nil {
+					return nil, err
+				}
+			}
+			regs = app.inputRegisters()
+		}
+		if addr+count > len(regs) {
+			return nil, modbusErr(modbusIllegalAddress, "registers %d to %d", addr, addr+count-1)
+		}
+		out := []byte{fc, byte(2 * count)}
+		for _, r := range regs[addr : addr+count] {
+			out = append(out, byte(r>>8), byte(r))
+		}
+		return out, nil
+	case 5:
+		if count != 0xFF00 && count != 0 {
+			return nil, modbusErr(modbusIllegalValue, "coil value %#04x", count)
+		}
+		if err := app.writeCoils(from, addr, []int{count >> 15}); err != nil {
+			return nil, err
+		}
+		return pdu[:5], nil
+	case 6:
+		if err := app.writeHolding(from, addr, []uint16{uint16(count)}); err != nil {
+			return nil, err
+		}
+		return pdu[:5], nil
+	case 15, 16:
+		if len(pdu) < 6 || len(pdu) != 6+int(pdu[5]) {
+			return nil, modbusErr(modbusIllegalValue, "byte count does not match")
+		}
+		data := pdu[6:]
+		var err error
+		if fc == 15 {
+			if count < 1 || count > 1968 || len(data) != (count+7)/8 {
+				return nil, modbusErr(modbusIllegalValue, "%d coils in %d bytes", count, len(data))
+			}
+			levels := make([]int, count)
+			for i := range levels {
+				levels[i] = int(data[i/8]>>(i%8)) & 1
+			}
+			err = app.writeCoils(from, addr, levels)
+		} else {
+			if count < 1 || count > 123 || len(data) != 2*count {
+				return nil, modbusErr(modbusIllegalValue, "%d registers in %d bytes", count, len(data))
+			}
+			values := make([]uint16, count)
+			for i := range values {
+				values[i] = binary.BigEndian.Uint16(data[2*i:])
+			}
+			err = app.writeHolding(from, addr, values)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return pdu[:5], nil
+	}
+	return nil, modbusErr(modbusIllegalFunction, "function %d is not served", fc)
+}
+
+//putFloat puts v in regs at i and i+1, high word first.
+func putFloat(regs []uint16, i int, v float64) {
+	b := math.Float32bits(float32(v))
+	regs[i], regs[i+1] = uint16(b>>16), uint16(b)
+}
+
+//inputRegisters is the input register table, see the top of the file.
+func (app *application) inputRegisters() []uint16 {
+	regs := make([]uint16, modbusInputRegs)
+	for ch, pin := range app.u3.pins()[:16] {
+		if v, analog, ok := pin.pinValue(); ok && analog {
+			regs[modbusRawRegs+ch] = pin.AnalogRead
+			putFloat(regs, modbusValueRegs+2*ch, v)
+		}
+	}
+	if app.u3.temperatureRead {
+		putFloat(regs, modbusTempRegs, app.u3.Temperature)
+	}
+	return regs
+}
+
+//holdingRegisters is the holding register table, see the top of the file.
+func (app *application) holdingRegisters() []uint16 {
+	regs := make([]uint16, modbusHoldingRegs)
+	for dac, v := range app.u3.DAC {
+		putFloat(regs, modbusDACRegs+2*dac, v)
+		regs[modbusDACmVRegs+dac] = uint16(math.Round(v * 1000))
+	}
+	return regs
+}
+
+//writeCoils sets the digital outputs from channel addr on to levels with one
+//Port State Write.  Nothing is written unless all of them are outputs.
+func (app *application) writeCoils(from string, addr int, levels []int) error {
+	if addr+len(levels) > modbusPins {
+		return modbusErr(modbusIllegalAddress, "coils %d to %d", addr, addr+len(levels)-1)
+	}
+	pins := app.u3.pins()
+	for i := range levels {
+		if pin := pins[addr+i]; pin.AD != "Digital" || pin.IO != "Output" {
+			return modbusErr(modbusIllegalAddress, "%s is not a digital output", jack.ChannelName(addr+i))
+		}
+	}
+	write := map[*Pin]int{}
+	for i, level := range levels {
+		write[pins[addr+i]] = level
+	}
+	if err := app.writePort(write); err != nil {
+		app.audit.Printf("%s could not set %d outputs from %s: %v", from, len(levels), jack.ChannelName(addr), err)
+		return err
+	}
+	for i, level := range levels {
+		app.audit.Printf("%s set %s to %d", from, jack.ChannelName(addr+i), level)
+	}
+	return nil
+}
+
+//writeHolding writes values to the holding registers from addr on.  A DAC
+//float has to be written as a whole pair.
+func (app *application) writeHolding(from string, addr int, values []uint16) error {
+	if addr+len(values) > modbusHoldingRegs {
+		return modbusErr(modbusIllegalAddress, "registers %d to %d", addr, addr+len(values)-1)
+	}
+	regs := app.holdingRegisters()
+	written := make([]bool, len(regs))
+	for i, v := range values {
+		regs[addr+i] = v
+		written[addr+i] = true
+	}
+	for dac := 0; dac < 2; dac++ {
+		hi, lo := modbusDACRegs+2*dac, modbusDACRegs+2*dac+1
+		volts := 0.0
+		switch {
+		case written[hi] != written[lo]:
+			return modbusErr(modbusIllegalAddress, "DAC%d is a float in registers %d and %d, write both", dac, hi, lo)
+		case written[hi]:
+			volts = float64(math.Float32frombits(uint32(regs[hi])<<16 | uint32(regs[lo])))
+		case written[modbusDACmVRegs+dac]:
+			volts = float64(regs[modbusDACmVRegs+dac]) / 1000
+		default:
+			continue
+		}
+		if math.IsNaN(volts) || volts < 0 || volts > 5 {
+			return modbusErr(modbusIllegalValue, "%g volts for DAC%d", volts, dac)
+		}
+		if err := app.setDAC(dac, volts); err != nil {
+			return err
+		}
+		app.audit.Printf("%s set DAC%d to %g V", from, dac, volts)
+	}
+	return nil
+}
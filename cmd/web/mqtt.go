@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+MQTT file holds a small MQTT 3.1.1 client, written here rather than pulled in,
+that publishes the readings and takes commands.  It is turned on with -mqtt
+host:port, a local broker such as mosquitto on localhost:1883 for example.
+
+After every acquisition the value of every pin in use (the same value the
+alarms look at: scaled value for analog pins, state for digital pins) is
+published to -mqtttopic with QoS 0, as a plain number, retained with
+-mqttretain.  So is the temperature, with {pin} set to "temperature".  The
+topic can have {device}, {serial}, {pin} and {name} (the user given name, the
+pin label when there is none) in it.
+
+The client subscribes to -mqttset, which must have {pin} in it and can have
+{device}.  A message to it with FIO0-7, EIO0-7 or CIO0-3 for the pin and 0 or 1
+as the payload sets that digital output, like the measurement page does, and
+with DAC0 or DAC1 and volts as the payload sets the DAC.  The new value is
+published back to the reading topic.  Every command is written to the audit
+log.  Commands to pins that are not digital outputs are refused and logged.
+
+The commands have no login, whoever can publish to the broker can write the
+outputs and DACs whatever the -users file says.  With -users the program will
+not connect unless -unauthenticated is given too, and then the broker should
+only take publishes to -mqttset from trusted clients, by its own ACLs.
+
+When the broker goes away the client connects again every mqttRetry, readings
+made in the meantime are dropped.
+*/
+
+const (
+	mqttQueue     = 500
+	mqttRetry     = 5 * time.Second
+	mqttKeepAlive = 60 * time.Second
+	mqttTimeout   = 10 * time.Second
+)
+
+//the MQTT control packet types used, already shifted to the top four bits.
+const (
+	mqttConnect   = 0x10
+	mqttConnAck   = 0x20
+	mqttPublish   = 0x30
+	mqttPubAck    = 0x40
+	mqttSubscribe = 0x82 //with the reserved flags 0010
+	mqttSubAck    = 0x90
+	mqttPingReq   = 0xC0
+)
+
+type mqttMessage struct {
+	topic   string
+	payload string
+}
+
+type mqttClient struct {
+	broker   string
+	clientID string
+	user     string
+	password string
+	topic    string //for the readings
+	setTopic string //for the commands
+	retain   bool
+	out      chan mqttMessage
+	up       int32 //1 while connected, readings are only queued then
+}
+
+func newMQTTClient(broker, clientID, user, password, topic, setTopic string, retain bool) (*mqttClient, error) {
+	if !strings.Contains(setTopic, "{pin}") {
+		return nil, fmt.Errorf("-mqttset %q has no {pin} in it", setTopic)
+	}
+	broker = strings.TrimPrefix(broker, "tcp://")
+	if _, _, err := net.SplitHostPort(broker); err != nil {
+		broker = net.JoinHostPort(broker, "1883")
+	}
+	return &mqttClient{broker: broker, clientID: clientID, user: user, password: password,
+		topic: topic, setTopic: setTopic, retain: retain, out: make(chan mqttMessage, mqttQueue)}, nil
+}
+
+//queue sends the message once the connection gets to it, it does not wait.
+func (c *mqttClient) queue(m mqttMessage) {
+	if atomic.LoadInt32(&c.up) == 0 {
+		return
+	}
+	select {
+	case c.out <- m:
+	default:
+	}
+}
+
+//<++++++++++++++++++++++++++   the app side   ++++++++++++++++++++++++++++++++>
+
+//readingTopic fills in the reading topic for pin, which is nil for the
+//temperature.
+func (app *application) readingTopic(label string, pin *Pin) string {
+	name := label
+	if pin != nil {
+		name = pin.DisplayName()
+	}
+	return strings.NewReplacer("{device}", strconv.Itoa(app.u3.DeviceNumber),
+		"{serial}", app.u3.SerialNumber, "{pin}", label, "{name}", name).Replace(app.mqtt.topic)
+}
+
+//publishReadings queues the value of every pin in use and the temperature.
+//Must be called with app.mu held.
+func (app *application) publishReadings() {
+	if app.mqtt == nil {
+		return
+	}
+	for _, pin := range app.u3.pins() {
+		if v, _, ok := pin.pinValue(); ok {
+			app.mqtt.queue(mqttMessage{app.readingTopic(pin.Label, pin), number(v)})
+		}
+	}
+	if app.u3.temperatureRead {
+		app.mqtt.queue(mqttMessage{app.readingTopic("temperature", nil), number(app.u3.Temperature)})
+	}
+}
+
+//mqttCommand carries out a message to the command topic.
+func (app *application) mqttCommand(topic, payload string) {
+	app.lock("mqtt " + topic)
+	defer app.unlock()
+	prefix := strings.SplitN(app.mqtt.setTopic, "{pin}", 2)
+	device := strconv.Itoa(app.u3.DeviceNumber)
+	before := strings.ReplaceAll(prefix[0], "{device}", device)
+	after := strings.ReplaceAll(prefix[1], "{device}", device)
+	if !strings.HasPrefix(topic, before) || !strings.HasSuffix(topic, after) || len(topic) < len(before)+len(after) {
+		return
+	}
+	name := strings.ToUpper(topic[len(before) : len(topic)-len(after)])
+	payload = strings.TrimSpace(payload)
+
+	var err error
+	var value float64
+	if name == "DAC0" || name == "DAC1" {
+		if value, err = strconv.ParseFloat(payload, 64); err == nil {
+			err = app.setDAC(int(name[3]-'0'), value)
+		}
+	} else {
+		var level int
+		if level, err = strconv.Atoi(payload); err == nil {
+			err = app.setDigital(name, level)
+			value = float64(level)
+		}
+	}
+	if err != nil {
+		app.audit.Printf("mqtt %s %q refused: %v", topic, payload, err)
+		return
+	}
+	app.audit.Printf("mqtt %s %s", topic, payload)
+	pin, _, _ := app.u3.pinByName(name)
+	app.mqtt.queue(mqttMessage{app.readingTopic(name, pin), number(value)})
+}
+
+//runMQTT keeps the client connected, it does not return.
+func (app *application) runMQTT() {
+	c := app.mqtt
+	app.lock("mqtt")
+	device := app.u3.DeviceNumber
+	app.unlock()
+	sub := strings.NewReplacer("{device}", strconv.Itoa(device), "{pin}", "+").Replace(c.setTopic)
+	for {
+		err := c.session(sub, app.mqttCommand, func() {
+			app.infoLog.Printf("mqtt connected to %s, commands on %s", c.broker, sub)
+		})
+		app.errorLog.Printf("mqtt %s: %v, connecting again in %v", c.broker, err, mqttRetry)
+		time.Sleep(mqttRetry)
+	}
+}
+
+//<+++++++++++++++++++++++++++   the protocol   ++++++++++++++++++++++++++++++++>
+
+//session connects, subscribes to sub and sends the queued messages until the
+//connection fails.  Each message to sub is handed to command.
+func (c *mqttClient) session(sub string, command func(topic, payload string), connected func()) error {
+	conn, err := net.DialTimeout("tcp", c.broker, mqttTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	flags := byte(0x02) //clean session
+	payload := mqttString(c.clientID)
+	if c.user != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(c.user)...)
+	}
+	if c.password != "" {
+		flags |= 0x40
+		payload = append(payload, mqttString(c.password)...)
+	}
+	body := append(mqttString("MQTT"), 4, flags,
+		byte(mqttKeepAlive/time.Second>>8), byte(mqttKeepAlive/time.Second&0xff))
+	conn.SetDeadline(time.Now().Add(mqttTimeout))
+	if _, err := conn.Write(mqttPacket(mqttConnect, append(body, payload...))); err != nil {
+		return err
+	}
+	typ, body, err := readMQTTPacket(r)
+	if err != nil {
+		return err
+	}
+	if typ != mqttConnAck || len(body) != 2 {
+		return fmt.Errorf("expected CONNACK, got packet type %d", typ>>4)
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("connection refused, return code %d", body[1])
+	}
+	//packet id 1, one topic filter at QoS 0
+	if _, err := conn.Write(mqttPacket(mqttSubscribe, append([]byte{0, 1}, append(mqttString(sub), 0)...))); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	//the reader goroutine hands the packets that need an answer to the writer
+	//below, so only one goroutine writes to conn.
+	acks := make(chan []byte, 10)
+	done := make(chan error, 1)
+	go func() {
+		for {
+			typ, body, err := readMQTTPacket(r)
+			if err != nil {
+				done <- err
+				return
+			}
+			switch typ & 0xF0 {
+			case mqttPublish:
+				topic, msg, id, err := parsePublish(typ, body)
+				if err != nil {
+					done <- err
+					return
+				}
+				if typ&0x06 == 0x02 { //QoS 1, the subscription is QoS 0 so no 2
+					select {
+					case acks <- mqttPacket(mqttPubAck, []byte{byte(id >> 8), byte(id)}):
+					default:
+					}
+				}
+				command(topic, msg)
+			case mqttSubAck:
+				if len(body) > 2 && body[2] == 0x80 {
+					done <- fmt.Errorf("the broker refused the subscription to %s", sub)
+					return
+				}
+			}
+		}
+	}()
+
+	atomic.StoreInt32(&c.up, 1)
+	defer atomic.StoreInt32(&c.up, 0)
+	connected()
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+	for {
+		var p []byte
+		select {
+		case err := <-done:
+			return err
+		case m := <-c.out:
+			flags := byte(mqttPublish)
+			if c.retain {
+				flags |= 0x01
+			}
+			p = mqttPacket(flags, append(mqttString(m.topic), m.payload...))
+		case p = <-acks:
+		case <-ping.C:
+			p = mqttPacket(mqttPingReq, nil)
+		}
+		conn.SetWriteDeadline(time.Now().Add(mqttTimeout))
+		if _, err := conn.Write(p); err != nil {
+			return err
+		}
+	}
+}
+
+//mqttString is s with its two byte length in front.
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+//mqttPacket puts the fixed header, typ and the remaining length, on body.
+func mqttPacket(typ byte, body []byte) []byte {
+	p := []byte{typ}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		p = append(p, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(p, body...)
+}
+
+//readMQTTPacket returns the first byte of the next packet and the rest of it
+//after the remaining length.
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(b&0x7F) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		if mult *= 128; i == 3 {
+			return 0, nil, errors.New("bad remaining length")
+		}
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return typ, body, err
+}
+
+//parsePublish returns the topic, payload and packet id (0 for QoS 0) of a
+//PUBLISH, typ being its first byte.
+func parsePublish(typ byte, body []byte) (string, string, int, error) {
+	if len(body) < 2 {
+		return "", "", 0, errors.New("short PUBLISH")
+	}
+	n := int(body[0])<<8 | int(body[1])
+	if len(body) < 2+n {
+		return "", "", 0, errors.New("short PUBLISH")
+	}
+	topic, rest := string(body[2:2+n]), body[2+n:]
+	id := 0
+	if typ&0x06 != 0 { //QoS 1 or 2
+		if len(rest) < 2 {
+			return "", "", 0, errors.New("short PUBLISH")
+		}
+		id = int(rest[0])<<8 | int(rest[1])
+		rest = rest[2:]
+	}
+	return topic, string(rest), id, nil
+}
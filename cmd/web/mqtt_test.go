@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+//the remaining length is 1 to 4 bytes, 7 bits each, low first.
+func TestMQTTPacket(t *testing.T) {
+	tests := []struct {
+		n      int
+		header []byte
+	}{
+		{0, []byte{mqttPingReq, 0x00}},
+		{1, []byte{mqttPingReq, 0x01}},
+		{127, []byte{mqttPingReq, 0x7F}},
+		{128, []byte{mqttPingReq, 0x80, 0x01}},
+		{321, []byte{mqttPingReq, 0xC1, 0x02}},
+		{16383, []byte{mqttPingReq, 0xFF, 0x7F}},
+		{16384, []byte{mqttPingReq, 0x80, 0x80, 0x01}},
+		{2097151, []byte{mqttPingReq, 0xFF, 0xFF, 0x7F}},
+		{2097152, []byte{mqttPingReq, 0x80, 0x80, 0x80, 0x01}},
+	}
+	for _, tt := range tests {
+		body := bytes.Repeat([]byte{0xA5}, tt.n)
+		p := mqttPacket(mqttPingReq, body)
+		if !bytes.Equal(p[:len(tt.header)], tt.header) || len(p) != len(tt.header)+tt.n {
+			t.Errorf("%d: header % x, length %d", tt.n, p[:len(tt.header)], len(p))
+			continue
+		}
+		typ, got, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(p)))
+		if err != nil || typ != mqttPingReq || !bytes.Equal(got, body) {
+			t.Errorf("%d: read back %x, %d bytes, %v", tt.n, typ, len(got), err)
+		}
+	}
+}
+
+func TestReadMQTTPacketErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"empty", nil},
+		{"no length", []byte{mqttConnAck}},
+		{"length cut", []byte{mqttConnAck, 0x80}},
+		{"five length bytes", []byte{mqttPublish, 0x80, 0x80, 0x80, 0x80, 0x01}},
+		{"body cut", []byte{mqttConnAck, 0x02, 0x00}},
+	}
+	for _, tt := range tests {
+		if _, _, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(tt.in))); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+//two packets back to back are read one at a time.
+func TestReadMQTTPackets(t *testing.T) {
+	in := append(mqttPacket(mqttConnAck, []byte{0, 0}), mqttPacket(mqttSubAck, []byte{0, 1, 0})...)
+	r := bufio.NewReader(bytes.NewReader(in))
+	for _, want := range []struct {
+		typ  byte
+		body []byte
+	}{{mqttConnAck, []byte{0, 0}}, {mqttSubAck, []byte{0, 1, 0}}} {
+		typ, body, err := readMQTTPacket(r)
+		if err != nil || typ != want.typ || !bytes.Equal(body, want.body) {
+			t.Fatalf("got %x % x %v, want %x % x", typ, body, err, want.typ, want.body)
+		}
+	}
+}
+
+func TestParsePublish(t *testing.T) {
+	tests := []struct {
+		name    string
+		typ     byte
+		body    []byte
+		topic   string
+		payload string
+		id      int
+		err     bool
+	}{
+		{"qos 0", mqttPublish, append(mqttString("lab/1/set/DAC0"), "2.5"...), "lab/1/set/DAC0", "2.5", 0, false},
+		{"qos 0 empty payload", mqttPublish, mqttString("a/b"), "a/b", "", 0, false},
+		{"qos 1", mqttPublish | 0x02, append(append(mqttString("lab/1/set/CIO2"), 0x12, 0x34), "1"...), "lab/1/set/CIO2", "1", 0x1234, false},
+		{"qos 2", mqttPublish | 0x04, append(mqttString("t"), 0x00, 0x07), "t", "", 7, false},
+		{"retain and dup ignored", mqttPublish | 0x09, append(mqttString("t"), "x"...), "t", "x", 0, false},
+		{"empty", mqttPublish, nil, "", "", 0, true},
+		{"topic cut", mqttPublish, []byte{0x00, 0x05, 'a', 'b'}, "", "", 0, true},
+		{"qos 1 no id", mqttPublish | 0x02, append(mqttString("t"), 0x01), "", "", 0, true},
+	}
+	for _, tt := range tests {
+		topic, payload, id, err := parsePublish(tt.typ, tt.body)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.name, err)
+			continue
+		}
+		if topic != tt.topic || payload != tt.payload || id != tt.id {
+			t.Errorf("%s: got %q %q %d, want %q %q %d", tt.name, topic, payload, id, tt.topic, tt.payload, tt.id)
+		}
+	}
+}
+
+//a retained reading, made the way session sends it, parses back.
+func TestMQTTPublishRoundTrip(t *testing.T) {
+	body := append(mqttString("lab/320012345/FIO4/tank"), "1.234"...)
+	p := mqttPacket(mqttPublish|0x01, body)
+	typ, got, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(p)))
+	if err != nil || typ&0xF0 != mqttPublish {
+		t.Fatal(typ, err)
+	}
+	topic, payload, id, err := parsePublish(typ, got)
+	if err != nil || topic != "lab/320012345/FIO4/tank" || payload != "1.234" || id != 0 {
+		t.Fatal(topic, payload, id, err)
+	}
+}
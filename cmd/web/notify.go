@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+/*
+Notify file holds the sinks alarm events are sent to.  Each sink is turned on
+with its own command line flag and any number of them can be on at once:
+
+-alarmlog file: appends each event to file as one line of JSON.
+-webhook url:   POSTs each event as JSON to url, meant for a local endpoint.
+-alarmexec cmd: runs cmd with the event as JSON on its standard input and in
+                the ALARM_* environment variables.
+
+Events are queued and sent by a single goroutine so a slow sink never holds up
+an acquisition.  When the queue is full the event is only logged.
+*/
+
+const (
+	notifyQueue   = 100
+	notifyTimeout = 10 * time.Second
+)
+
+type notifier interface {
+	notify(e AlarmEvent) error
+	String() string
+}
+
+//starts the goroutine that sends the events to the sinks.
+func (app *application) startNotifiers(sinks []notifier) {
+	app.notifiers = sinks
+	app.events = make(chan AlarmEvent, notifyQueue)
+	go func() {
+		for e := range app.events {
+			for _, s := range app.notifiers {
+				if err := s.notify(e); err != nil {
+					app.errorLog.Printf("alarm notification to %s: %v", s, err)
+				}
+			}
+		}
+	}()
+}
+
+//queues the event for the sinks, it does not wait for them.
+func (app *application) notify(e AlarmEvent) {
+	app.infoLog.Printf("alarm: %s", e.Message)
+	if app.events == nil {
+		return
+	}
+	select {
+	case app.events <- e:
+	default:
+		app.errorLog.Printf("alarm notification queue full, dropped: %s", e.Message)
+	}
+}
+
+type logFileSink struct {
+	path string
+}
+
+func (s *logFileSink) notify(e AlarmEvent) error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(e)
+}
+
+func (s *logFileSink) String() string { return "log file " + s.path }
+
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookSink(url string) *webhookSink {
+	return &webhookSink{url: url, client: &http.Client{Timeout: notifyTimeout}}
+}
+
+func (s *webhookSink) notify(e AlarmEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookSink) String() string { return "webhook " + s.url }
+
+type execSink struct {
+	command string
+}
+
+func (s *execSink) notify(e AlarmEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", s.command)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Env = append(os.Environ(),
+		"ALARM_TIME="+e.Time.Format(time.RFC3339),
+		fmt.Sprintf("ALARM_RULE=%d", e.RuleID),
+		"ALARM_PIN="+e.Pin,
+		"ALARM_NAME="+e.Name,
+		"ALARM_KIND="+e.Kind,
+		"ALARM_STATE="+e.State,
+		fmt.Sprintf("ALARM_VALUE=%g", e.Value),
+		"ALARM_MESSAGE="+e.Message,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
+
+func (s *execSink) String() string { return "command " + s.command }
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Saied74/labjack/pkg/jack"
+)
+
+/*
+Pattern file holds the timed patterns on the digital outputs.  They are written
+as text, one pattern per line, with the pins driven together as a comma list
+and the times as Go durations (500us, 1.5ms, 2s):
+
+	pulse EIO0 500us                  EIO0 high for 500us, then low
+	burst EIO0 1ms 5ms 10             10 pulses 1ms high, one every 5ms
+	bits EIO0,EIO1,EIO2 2ms 3 100 010 001
+	                                  each word held 2ms, the whole list 3 times
+
+A count or repeat of 0 plays the pattern until it is stopped.  Pulses start
+from and end on low, bits end on the last word, and the pins keep that level.
+
+A pattern is played one of two ways.  When it fits in one Feedback packet
+(jack.MaxFeedback bytes of IOTypes, a Bit State Write for each pin that changes
+and WaitShort/WaitLong for each hold) and its waits add up to less than
+packetMaxWait, the whole pattern is sent as one packet and the U3 times it, to
+the 128us of a WaitShort.  Anything longer is played by the host, one Port
+State Write of the pattern's pins per step, which is only good to a few
+milliseconds, so it is refused when it has a step shorter than hostMinHold.
+No time in a pattern can be over maxPatternHold.  Only one pattern plays at a
+time and the other pins are left alone.
+
+Every play is kept as a PatternRun, the last maxPatternRuns of them.  With
+-patterns the patterns are kept in that file as JSON so they survive a restart.
+*/
+
+const (
+	packetMaxWait  = 250 * time.Millisecond //well under the time a USB read waits
+	hostMinHold    = 5 * time.Millisecond
+	maxPatternHold = 10 * time.Minute //longest width, period or step
+	maxPatternPin  = 8
+	maxPatternRuns = 100
+)
+
+type Pattern struct {
+	ID     int
+	Kind   string        //Pulse, Burst or Bits
+	Pins   []string      //digital outputs, driven together
+	Width  time.Duration //high time of a Pulse or Burst
+	Period time.Duration //from one pulse of a Burst to the next
+	Step   time.Duration //each word of Bits is held this long
+	Count  int           //pulses of a Burst, or times Bits is played, 0 for until stopped
+	Words  []string      //of Bits, a 0 or 1 for each pin
+	Runs   int           //number of times it was played
+}
+
+type PatternRun struct {
+	ID       int
+	Pattern  int
+	Text     string
+	Mode     string //Packet or Host
+	Started  time.Time
+	Finished time.Time
+	Steps    int //steps written, in Host mode
+	Stopped  bool
+	Error    string
+}
+
+//patternState is one step of a pattern, the level of each pin and how long it
+//is held before the next step.
+type patternState struct {
+	levels []int
+	hold   time.Duration
+}
+
+/*
+parsePattern parses the text form of a pattern, see the top of the file.
+*/
+func parsePattern(text string) (*Pattern, error) {
+	f := strings.Fields(strings.ToUpper(text))
+	bad := fmt.Errorf("%q is not a pattern, see the examples on the page", text)
+	if len(f) < 3 {
+		return nil, bad
+	}
+	p := &Pattern{Pins: strings.Split(f[1], ",")}
+	durations := func(f []string, d ...*time.Duration) error {
+		for i, x := range f {
+			v, err := time.ParseDuration(strings.ToLower(x))
+			if err != nil {
+				return fmt.Errorf("%q is not a duration, use one such as 500us or 2ms", x)
+			}
+			*d[i] = v
+		}
+		return nil
+	}
+	count := func(x string) error {
+		n, err := strconv.Atoi(x)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%q is not a count, use 0 for until stopped", x)
+		}
+		p.Count = n
+		return nil
+	}
+	switch {
+	case f[0] == "PULSE" && len(f) == 3:
+		p.Kind, p.Count = "Pulse", 1
+		if err := durations(f[2:], &p.Width); err != nil {
+			return nil, err
+		}
+		p.Period = p.Width
+	case f[0] == "BURST" && len(f) == 5:
+		p.Kind = "Burst"
+		if err := durations(f[2:4], &p.Width, &p.Period); err != nil {
+			return nil, err
+		}
+		if err := count(f[4]); err != nil {
+			return nil, err
+		}
+	case f[0] == "BITS" && len(f) >= 5:
+		p.Kind = "Bits"
+		if err := durations(f[2:3], &p.Step); err != nil {
+			return nil, err
+		}
+		if err := count(f[3]); err != nil {
+			return nil, err
+		}
+		p.Words = f[4:]
+	default:
+		return nil, bad
+	}
+	return p, nil
+}
+
+func (p *Pattern) String() string {
+	pins := strings.Join(p.Pins, ",")
+	switch p.Kind {
+	case "Pulse":
+		return fmt.Sprintf("pulse %s %v", pins, p.Width)
+	case "Burst":
+		return fmt.Sprintf("burst %s %v %v %d", pins, p.Width, p.Period, p.Count)
+	}
+	return fmt.Sprintf("bits %s %v %d %s", pins, p.Step, p.Count, strings.Join(p.Words, " "))
+}
+
+//check checks the pattern on its own and its pins against u, it returns the
+//channels of the pins.  The pins have to be digital outputs to be played,
+//that is checked when it is.
+func (p *Pattern) check(u *U3) ([]int, error) {
+	if len(p.Pins) == 0 || len(p.Pins) > maxPatternPin {
+		return nil, fmt.Errorf("a pattern drives 1 to %d pins", maxPatternPin)
+	}
+	chs := []int{}
+	seen := map[int]bool{}
+	for _, name := range p.Pins {
+		_, ch, err := u.pinByName(name)
+		if err != nil {
+			return nil, err
+		}
+		if seen[ch] {
+			return nil, fmt.Errorf("%s is in the pattern twice", name)
+		}
+		seen[ch] = true
+		chs = append(chs, ch)
+	}
+	short := func(d time.Duration) bool { return d < jack.WaitShortUnit || d > maxPatternHold }
+	switch p.Kind {
+	case "Pulse", "Burst":
+		if short(p.Width) || short(p.Period) {
+			return nil, fmt.Errorf("times must be from %v to %v", jack.WaitShortUnit, maxPatternHold)
+		}
+		if p.Kind == "Burst" && p.Width >= p.Period {
+			return nil, fmt.Errorf("the width %v has to be shorter than the period %v", p.Width, p.Period)
+		}
+	case "Bits":
+		if short(p.Step) {
+			return nil, fmt.Errorf("times must be from %v to %v", jack.WaitShortUnit, maxPatternHold)
+		}
+		if len(p.Words) == 0 {
+			return nil, fmt.Errorf("bits needs at least one word")
+		}
+		for _, w := range p.Words {
+			if len(w) != len(p.Pins) || strings.Trim(w, "01") != "" {
+				return nil, fmt.Errorf("%q is not a word of 0s and 1s, one for each of the %d pins", w, len(p.Pins))
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%q is not a pattern kind, use Pulse, Burst or Bits", p.Kind)
+	}
+	if p.Count < 0 {
+		return nil, fmt.Errorf("the count can not be negative")
+	}
+	return chs, nil
+}
+
+//cycle is the steps of the pattern played once, a Burst cycle being one pulse.
+//Every step has its hold, play leaves the hold of the very last one out.
+func (p *Pattern) cycle() []patternState {
+	same := func(level int) []int {
+		l := make([]int, len(p.Pins))
+		for i := range l {
+			l[i] = level
+		}
+		return l
+	}
+	if p.Kind != "Bits" {
+		return []patternState{{same(1), p.Width}, {same(0), p.Period - p.Width}}
+	}
+	states := []patternState{}
+	for _, w := range p.Words {
+		l := make([]int, len(w))
+		for i, c := range w {
+			l[i] = int(c - '0')
+		}
+		states = append(states, patternState{l, p.Step})
+	}
+	return states
+}
+
+/*
+packet returns the IOTypes of the whole pattern as one Feedback packet, a Bit
+State Write for each pin that changes and the waits for each hold, rounded to
+WaitShortUnit.  It returns nil when the pattern plays until stopped, does not
+fit in one packet or waits longer than packetMaxWait.
+*/
+func (p *Pattern) packet(chs []int) []byte {
+	if p.Count == 0 {
+		return nil
+	}
+	cycle := p.cycle()
+	data := []byte{}
+	var waited time.Duration
+	last := make([]int, len(chs))
+	for k := 0; k < p.Count; k++ {
+		for i, s := range cycle {
+			for j, ch := range chs {
+				if (k == 0 && i == 0) || s.levels[j] != last[j] {
+					data = append(data, jack.IOBitStateWrite, byte(ch)|byte(s.levels[j])<<7)
+				}
+			}
+			copy(last, s.levels)
+			if k == p.Count-1 && i == len(cycle)-1 {
+				break
+			}
+			units := int((s.hold + jack.WaitShortUnit/2) / jack.WaitShortUnit)
+			waited += time.Duration(units) * jack.WaitShortUnit
+			perLong := int(jack.WaitLongUnit / jack.WaitShortUnit)
+			for long := units / perLong; long > 0; long -= 255 {
+				n := long
+				if n > 255 {
+					n = 255
+				}
+				data = append(data, jack.IOWaitLong, byte(n))
+			}
+			if units%perLong > 0 {
+				data = append(data, jack.IOWaitShort, byte(units%perLong))
+			}
+			if len(data) > jack.MaxFeedback || waited > packetMaxWait {
+				return nil
+			}
+		}
+	}
+	if len(data) > jack.MaxFeedback {
+		return nil
+	}
+	return data
+}
+
+//<++++++++++++++++++++++++++   playing patterns   +++++++++++++++++++++++++++>
+
+/*
+playPattern plays pattern id, see the top of the file.  A pattern that fits in
+one packet is played before it returns, a longer one is played by a goroutine
+and app.u3.Playing is its run until it is over.  Must be called with app.mu
+held, the goroutine takes it for each step.  from is who started it, for the
+transaction log.
+*/
+func (app *application) playPattern(from string, id int) (*PatternRun, error) {
+	p := app.findPattern(id)
+	if p == nil {
+		return nil, fmt.Errorf("there is no pattern %d", id)
+	}
+	if app.u3.Playing != nil {
+		return nil, fmt.Errorf("pattern %d is already playing", app.u3.Playing.Pattern)
+	}
+	chs, err := p.check(app.u3)
+	if err != nil {
+		return nil, err
+	}
+	if err := app.patternPins(chs); err != nil {
+		return nil, err
+	}
+	run := &PatternRun{Pattern: id, Text: p.String(), Started: time.Now()}
+	p.Runs++
+	if data := p.packet(chs); data != nil {
+		run.Mode = "Packet"
+		err := app.srData[jack.Feedback].SetFeedback(data, 0)
+		if err == nil {
+			err = app.u3SendRec(jack.Feedback, 0x00)
+		}
+		if err == nil {
+			final := p.cycle()[len(p.cycle())-1].levels
+			for j, ch := range chs {
+				app.u3.pins()[ch].DigitalWrite = final[j]
+			}
+		}
+		app.finishPattern(run, err)
+		return run, nil
+	}
+	for i, s := range p.cycle() {
+		if s.hold < hostMinHold && !(p.Count == 1 && i == len(p.cycle())-1) {
+			return nil, fmt.Errorf("pattern %d does not fit in one packet and the host can not time steps under %v",
+				id, hostMinHold)
+		}
+	}
+	run.Mode = "Host"
+	stop := make(chan struct{})
+	app.u3.Playing = run
+	app.patternStop = stop
+	go app.hostPattern(from, p, chs, run, stop)
+	return run, nil
+}
+
+//hostPattern plays the steps of p one Port State Write at a time until it is
+//over or stop is closed.  Must be called without app.mu held.
+func (app *application) hostPattern(from string, p *Pattern, chs []int, run *PatternRun, stop chan struct{}) {
+	from = fmt.Sprintf("pattern %d, %s", p.ID, from)
+	cycle := p.cycle()
+	start := time.Now()
+	var at time.Duration
+	var err error
+	stopped := false
+play:
+	for k := 0; p.Count == 0 || k < p.Count; k++ {
+		for i, s := range cycle {
+			app.lock(from)
+			err = app.patternPins(chs)
+			if err == nil {
+				err = app.writePins(chs, s.levels)
+				run.Steps++
+			}
+			app.unlock()
+			if err != nil || (k == p.Count-1 && i == len(cycle)-1) {
+				break play
+			}
+			at += s.hold
+			select {
+			case <-stop:
+				stopped = true
+				break play
+			case <-time.After(time.Until(start.Add(at))):
+			}
+		}
+	}
+	app.lock(from)
+	defer app.unlock()
+	run.Stopped = stopped
+	app.u3.Playing = nil
+	app.patternStop = nil
+	app.finishPattern(run, err)
+}
+
+//stopPattern stops the pattern being played by the host, if any.  Must be
+//called with app.mu held.
+func (app *application) stopPattern() error {
+	if app.patternStop == nil {
+		return fmt.Errorf("no pattern is playing")
+	}
+	close(app.patternStop)
+	app.patternStop = nil
+	return nil
+}
+
+//finishPattern keeps the run.  Must be called with app.mu held.
+func (app *application) finishPattern(run *PatternRun, err error) {
+	run.Finished = time.Now()
+	if err != nil {
+		run.Error = err.Error()
+	}
+	run.ID = 1
+	if n := len(app.u3.PatternRuns); n > 0 {
+		run.ID = app.u3.PatternRuns[n-1].ID + 1
+	}
+	app.u3.PatternRuns = append(app.u3.PatternRuns, run)
+	if len(app.u3.PatternRuns) > maxPatternRuns {
+		app.u3.PatternRuns = app.u3.PatternRuns[len(app.u3.PatternRuns)-maxPatternRuns:]
+	}
+	app.infoLog.Printf("pattern %d (%s) played in %v, %s mode, stopped: %v, error: %q",
+		run.Pattern, run.Text, run.Finished.Sub(run.Started), run.Mode, run.Stopped, run.Error)
+}
+
+//patternPins checks the pins on channels chs are digital outputs.
+func (app *application) patternPins(chs []int) error {
+	for _, ch := range chs {
+		if pin := app.u3.pins()[ch]; pin.AD != "Digital" || pin.IO != "Output" {
+			return fmt.Errorf("%s is not a digital output", pin.Label)
+		}
+	}
+	return nil
+}
+
+//writePins sets the pins on channels chs to levels with one Port State Write
+//masked to them, the other pins are left alone.  Must be called with app.mu
+//held.
+func (app *application) writePins(chs []int, levels []int) error {
+	var mask, state [3]byte
+	for j, ch := range chs {
+		mask[ch/8] |= 1 << (ch % 8)
+		state[ch/8] |= byte(levels[j]) << (ch % 8)
+	}
+	sr := app.srData[jack.PortStateWrite]
+	sr.Byte8, sr.Byte9, sr.Byte10 = mask[0], mask[1], mask[2]
+	sr.Byte11, sr.Byte12, sr.Byte13 = state[0], state[1], state[2]
+	if err := app.u3SendRec(jack.PortStateWrite, 0x01); err != nil {
+		return err
+	}
+	for j, ch := range chs {
+		app.u3.pins()[ch].DigitalWrite = levels[j]
+	}
+	return nil
+}
+
+//<++++++++++++++++++++++++++   the pattern list   +++++++++++++++++++++++++++>
+
+//must be called with app.mu held.
+func (app *application) findPattern(id int) *Pattern {
+	for _, p := range app.u3.Patterns {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+//addPattern checks the pattern and adds it with the next free ID.
+func (app *application) addPattern(p *Pattern) error {
+	if _, err := p.check(app.u3); err != nil {
+		return err
+	}
+	p.ID = 1
+	for _, b := range app.u3.Patterns {
+		if b.ID >= p.ID {
+			p.ID = b.ID + 1
+		}
+	}
+	p.Runs = 0
+	app.u3.Patterns = append(app.u3.Patterns, p)
+	return app.savePatterns()
+}
+
+func (app *application) deletePattern(id int) error {
+	for i, p := range app.u3.Patterns {
+		if p.ID == id {
+			app.u3.Patterns = append(app.u3.Patterns[:i], app.u3.Patterns[i+1:]...)
+			return app.savePatterns()
+		}
+	}
+	return fmt.Errorf("there is no pattern %d", id)
+}
+
+//the patterns are kept in app.patternFile (when given) so they survive a
+//restart.
+func (app *application) loadPatterns() error {
+	if app.patternFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(app.patternFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	patterns := []*Pattern{}
+	if err := json.Unmarshal(b, &patterns); err != nil {
+		return fmt.Errorf("%s: %v", app.patternFile, err)
+	}
+	for _, p := range patterns {
+		if _, err := p.check(app.u3); err != nil {
+			return fmt.Errorf("%s: pattern %d: %v", app.patternFile, p.ID, err)
+		}
+	}
+	app.u3.Patterns = patterns
+	return nil
+}
+
+func (app *application) savePatterns() error {
+	if app.patternFile == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(app.u3.Patterns, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(app.patternFile, b, 0644)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/Saied74/labjack/pkg/jack"
+)
+
+//the IOTypes of a packet as the transaction log shows them.
+func bitWrite(ch, level int) string {
+	return fmt.Sprintf("BitStateWrite IONumber=%d (%s) State=%d", ch, jack.ChannelName(ch), level)
+}
+
+func waitShort(n int) string { return fmt.Sprintf("WaitShort Time=%d (x128us)", n) }
+
+func waitLong(n int) string { return fmt.Sprintf("WaitLong Time=%d (x32ms)", n) }
+
+//repeat is the IOTypes in s n times over, the last wait left off the end.
+func repeat(n int, s ...string) []string {
+	r := []string{}
+	for i := 0; i < n; i++ {
+		r = append(r, s...)
+	}
+	return r[:len(r)-1]
+}
+
+func TestPatternPacket(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		want    []string //nil when it does not fit and the host plays it
+	}{
+		{"pulse", "pulse EIO0 500us", []string{bitWrite(8, 1), waitShort(4), bitWrite(8, 0)}},
+		{"pulse rounded to 128us", "pulse EIO0 200us", []string{bitWrite(8, 1), waitShort(2), bitWrite(8, 0)}},
+		{"pulse over a WaitLong", "pulse EIO0 100ms", []string{bitWrite(8, 1), waitLong(3), waitShort(31), bitWrite(8, 0)}},
+		{"burst", "burst EIO0 1ms 5ms 3", repeat(3, bitWrite(8, 1), waitShort(8), bitWrite(8, 0), waitShort(31))},
+		{"burst of WaitLongs", "burst EIO0 64ms 96ms 2", repeat(2, bitWrite(8, 1), waitLong(2), bitWrite(8, 0), waitLong(1))},
+		{"burst filling the packet", "burst EIO0 1ms 2ms 7", repeat(7, bitWrite(8, 1), waitShort(8), bitWrite(8, 0), waitShort(8))},
+		{"bits only writes the pins that change", "bits EIO0,EIO1 2ms 2 10 11 01", []string{
+			bitWrite(8, 1), bitWrite(9, 0), waitShort(16),
+			bitWrite(9, 1), waitShort(16),
+			bitWrite(8, 0), waitShort(16),
+			bitWrite(8, 1), bitWrite(9, 0), waitShort(16),
+			bitWrite(9, 1), waitShort(16),
+			bitWrite(8, 0),
+		}},
+		{"bits held at the end", "bits EIO0 1ms 1 1 1 0", []string{bitWrite(8, 1), waitShort(8), waitShort(8), bitWrite(8, 0)}},
+
+		//anything else is left to the host
+		{"burst one pulse over the packet", "burst EIO0 1ms 2ms 8", nil},
+		{"burst over the packet", "burst EIO0 1ms 2ms 20", nil},
+		{"bits over the packet", "bits EIO0,EIO1,EIO2,EIO3 1ms 4 1000 0100 0010 0001", nil},
+		{"pulse waits too long", "pulse EIO0 300ms", nil},
+		{"burst waits too long", "burst EIO0 64ms 96ms 3", nil},
+		{"burst until stopped", "burst EIO0 1ms 5ms 0", nil},
+		{"bits until stopped", "bits EIO0 1ms 0 1 0", nil},
+	}
+	cmds := jack.NewCommands()
+	for _, tt := range tests {
+		p, err := parsePattern(tt.pattern)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		chs, err := p.check(newU3())
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		data := p.packet(chs)
+		if tt.want == nil {
+			if data != nil {
+				t.Errorf("%s: a packet of %d bytes, want the host to play it", tt.name, len(data))
+			}
+			continue
+		}
+		sr := cmds[jack.Feedback]
+		if err := sr.SetFeedback(data, 0); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		send := make([]byte, sr.SendLength)
+		sr.Build(send, 0)
+		got := jack.DecodeRequest(jack.Feedback, send)["IOTypes"]
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
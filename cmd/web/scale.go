@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Scale file holds the conversion of analog voltages to engineering units.  Each
+pin carries a Scale, which is applied to the filtered voltage (the raw voltage
+when the filter is None) on every acquisition.  The kinds are:
+
+None:         the value is the voltage, in volts.
+Linear:       Slope * volts + Offset.
+Polynomial:   Coefficients[0] + Coefficients[1] * volts + ... (any order).
+Table:        Table is a list of (volts, value) pairs sorted by volts.  Values
+              between pairs are interpolated and outside the table are clamped.
+Thermistor:   an NTC thermistor on the low side of a divider with RFixed on the
+              high side, fed from Supply volts.  Beta model with R0 at T0 in
+              degrees C.  The value is in degrees C.
+Thermocouple: type K, J or T after an amplifier of Gain, with the cold junction
+              at ColdJunction degrees C.  Uses the NIST inverse polynomials for
+              0 degrees C and up and a linear cold junction correction, which is
+              good to a degree or two near room temperature.
+
+On the web form the parameters of each kind are entered as one comma separated
+list, see parseScaleParams.
+*/
+
+var scaleKinds = []string{"None", "Linear", "Polynomial", "Table", "Thermistor", "Thermocouple"}
+
+type Scale struct {
+	Kind         string
+	Slope        float64      `json:",omitempty"` //Linear
+	Offset       float64      `json:",omitempty"` //Linear
+	Coefficients []float64    `json:",omitempty"` //Polynomial
+	Table        [][2]float64 `json:",omitempty"` //Table
+	Beta         float64      `json:",omitempty"` //Thermistor
+	R0           float64      `json:",omitempty"` //Thermistor
+	T0           float64      `json:",omitempty"` //Thermistor
+	RFixed       float64      `json:",omitempty"` //Thermistor
+	Supply       float64      `json:",omitempty"` //Thermistor
+	TCType       string       `json:",omitempty"` //Thermocouple
+	ColdJunction float64      `json:",omitempty"` //Thermocouple
+	Gain         float64      `json:",omitempty"` //Thermocouple
+}
+
+//NIST inverse polynomials (microvolts to degrees C, 0 degrees C and up) and the
+//Seebeck coefficient near room temperature in microvolts per degree C.
+var thermocouples = map[string]struct {
+	inverse []float64
+	seebeck float64
+}{
+	"K": {[]float64{0, 2.508355e-2, 7.860106e-8, -2.503131e-10, 8.315270e-14,
+		-1.228034e-17, 9.804036e-22, -4.413030e-26, 1.057734e-30, -1.052755e-35}, 40.6},
+	"J": {[]float64{0, 1.978425e-2, -2.001204e-7, 1.036969e-11, -2.549687e-16,
+		3.585153e-21, -5.344285e-26, 5.099890e-31}, 51.7},
+	"T": {[]float64{0, 2.592800e-2, -7.602961e-7, 4.637791e-11, -2.165394e-15,
+		6.048144e-20, -7.293422e-25}, 40.7},
+}
+
+//check makes sure the scale has what its kind needs.
+func (s *Scale) check() error {
+	switch s.Kind {
+	case "None", "Linear":
+	case "Polynomial":
+		if len(s.Coefficients) == 0 {
+			return fmt.Errorf("a polynomial needs at least one coefficient")
+		}
+	case "Table":
+		if len(s.Table) < 2 {
+			return fmt.Errorf("a table needs at least two points")
+		}
+		if !sort.SliceIsSorted(s.Table, func(i, j int) bool { return s.Table[i][0] < s.Table[j][0] }) {
+			return fmt.Errorf("table points must be sorted by volts")
+		}
+	case "Thermistor":
+		if s.Beta <= 0 || s.R0 <= 0 || s.RFixed <= 0 || s.Supply <= 0 {
+			return fmt.Errorf("thermistor beta, R0, fixed resistor and supply must be over zero")
+		}
+	case "Thermocouple":
+		if _, ok := thermocouples[s.TCType]; !ok {
+			return fmt.Errorf("%q is not a thermocouple type, use K, J or T", s.TCType)
+		}
+		if s.Gain == 0 {
+			return fmt.Errorf("thermocouple gain can not be zero")
+		}
+	default:
+		return fmt.Errorf("%q is not a scale, use one of %v", s.Kind, scaleKinds)
+	}
+	return nil
+}
+
+//apply converts volts to the engineering unit of the scale.
+func (s *Scale) apply(volts float64) float64 {
+	switch s.Kind {
+	case "Linear":
+		return s.Slope*volts + s.Offset
+	case "Polynomial":
+		return polynomial(s.Coefficients, volts)
+	case "Table":
+		t := s.Table
+		if volts <= t[0][0] {
+			return t[0][1]
+		}
+		for i := 1; i < len(t); i++ {
+			if volts <= t[i][0] {
+				f := (volts - t[i-1][0]) / (t[i][0] - t[i-1][0])
+				return t[i-1][1] + f*(t[i][1]-t[i-1][1])
+			}
+		}
+		return t[len(t)-1][1]
+	case "Thermistor":
+		if volts <= 0 || volts >= s.Supply {
+			return math.NaN()
+		}
+		r := s.RFixed * volts / (s.Supply - volts)
+		return 1/(1/(s.T0+273.15)+math.Log(r/s.R0)/s.Beta) - 273.15
+	case "Thermocouple":
+		tc := thermocouples[s.TCType]
+		uv := volts/s.Gain*1e6 + s.ColdJunction*tc.seebeck
+		return polynomial(tc.inverse, uv)
+	}
+	return volts
+}
+
+func polynomial(c []float64, x float64) float64 {
+	y := 0.0
+	for i := len(c) - 1; i >= 0; i-- {
+		y = y*x + c[i]
+	}
+	return y
+}
+
+/*
+parseScaleParams builds a scale of kind from the comma separated parameters
+entered on the web form:
+
+Linear:       slope, offset
+Polynomial:   c0, c1, c2, ...
+Table:        volts:value, volts:value, ...
+Thermistor:   beta, R0, T0, fixed resistor, supply
+Thermocouple: type, cold junction, gain
+*/
+func parseScaleParams(kind, params string) (Scale, error) {
+	s := Scale{Kind: kind}
+	fields := []string{}
+	for _, f := range strings.Split(params, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	nums := func(n int) ([]float64, error) {
+		if n > 0 && len(fields) != n {
+			return nil, fmt.Errorf("%s needs %d parameters, got %d", kind, n, len(fields))
+		}
+		v := make([]float64, len(fields))
+		for i, f := range fields {
+			x, err := strconv.ParseFloat(f, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %q is not a number", kind, f)
+			}
+			v[i] = x
+		}
+		return v, nil
+	}
+	switch kind {
+	case "Linear":
+		v, err := nums(2)
+		if err != nil {
+			return s, err
+		}
+		s.Slope, s.Offset = v[0], v[1]
+	case "Polynomial":
+		v, err := nums(0)
+		if err != nil {
+			return s, err
+		}
+		s.Coefficients = v
+	case "Table":
+		for _, f := range fields {
+			var p [2]float64
+			if _, err := fmt.Sscanf(f, "%g:%g", &p[0], &p[1]); err != nil {
+				return s, fmt.Errorf("Table: %q is not volts:value", f)
+			}
+			s.Table = append(s.Table, p)
+		}
+	case "Thermistor":
+		v, err := nums(5)
+		if err != nil {
+			return s, err
+		}
+		s.Beta, s.R0, s.T0, s.RFixed, s.Supply = v[0], v[1], v[2], v[3], v[4]
+	case "Thermocouple":
+		if len(fields) != 3 {
+			return s, fmt.Errorf("Thermocouple needs 3 parameters, got %d", len(fields))
+		}
+		s.TCType = strings.ToUpper(fields[0])
+		fields = fields[1:]
+		v, err := nums(2)
+		if err != nil {
+			return s, err
+		}
+		s.ColdJunction, s.Gain = v[0], v[1]
+	}
+	return s, s.check()
+}
+
+//Params is the reverse of parseScaleParams, for filling in the web form.
+func (s Scale) Params() string {
+	g := func(v ...float64) string {
+		p := make([]string, len(v))
+		for i, x := range v {
+			p[i] = strconv.FormatFloat(x, 'g', -1, 64)
+		}
+		return strings.Join(p, ", ")
+	}
+	switch s.Kind {
+	case "Linear":
+		return g(s.Slope, s.Offset)
+	case "Polynomial":
+		return g(s.Coefficients...)
+	case "Table":
+		p := make([]string, len(s.Table))
+		for i, t := range s.Table {
+			p[i] = g(t[0]) + ":" + g(t[1])
+		}
+		return strings.Join(p, ", ")
+	case "Thermistor":
+		return g(s.Beta, s.R0, s.T0, s.RFixed, s.Supply)
+	case "Thermocouple":
+		return s.TCType + ", " + g(s.ColdJunction, s.Gain)
+	}
+	return ""
+}
+
+//DisplayName is the user given name of the pin, or its label when it has none.
+func (p *Pin) DisplayName() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.Label
+}
+
+//scales the filtered voltage of pin into its engineering unit.
+func (p *Pin) scale(volts float64) {
+	p.Value = p.Scale.apply(volts)
+	p.ValueText = strconv.FormatFloat(p.Value, 'f', 3, 64)
+	p.OutOfRange = math.IsNaN(p.Value) || math.IsInf(p.Value, 0)
+	if p.OutOfRange {
+		p.Value = 0 //JSON has no NaN
+		p.ValueText = "out of range"
+	}
+}
+
+//ValueUnit is the scaled value with its unit, for the pages and exports.
+func (p *Pin) ValueUnit() string {
+	if p.Scale.Kind == "None" && p.Unit == "" {
+		return p.ValueText + " V"
+	}
+	return strings.TrimSpace(p.ValueText + " " + p.Unit)
+}
+
+//writeCSV writes the last measurement of every pin, named and scaled.
+func (u *U3) writeCSV(w io.Writer) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"pin", "name", "type", "raw", "volts", "value", "unit"})
+	for _, pin := range u.pins() {
+		switch pin.AD {
+		case "Analog":
+			c.Write([]string{pin.Label, pin.DisplayName(), "Analog",
+				strconv.Itoa(int(pin.AnalogRead)), pin.FilteredVoltage, pin.ValueText, pin.Unit})
+		case "Digital":
+			state := pin.DigitalRead
+			if pin.IO == "Output" {
+				state = pin.DigitalWrite
+			}
+			c.Write([]string{pin.Label, pin.DisplayName(), pin.IO,
+				strconv.Itoa(state), "", strconv.Itoa(state), pin.Unit})
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Saied74/labjack/pkg/jack"
+)
+
+/*
+SCPI file holds a raw socket server, turned on with -scpi and an address such
+as :5025 (the usual SCPI port), that takes SCPI like commands so the U3 can be
+driven by test frameworks that already talk to other instruments that way.
+Commands are lines of text, more than one to a line separated by semicolons,
+and the answers of the queries on a line go back as one line separated by
+semicolons.  Headers can be long or short (MEASure is MEAS or MEASURE) in any
+case, and each one is taken from the root, the relative paths after a
+semicolon are not kept.
+
+	*IDN?                                 LabJack,name,serial,firmware
+	*CLS                                  empties the error queue
+	*OPC?                                 1, every command is done when it returns
+	SYSTem:ERRor[:NEXT]?                  oldest error, 0,"No error" when none
+	SYSTem:ERRor:COUNt?                   number of errors in the queue
+	MEASure:VOLTage[:DC]? (@FIO4,EIO0:3)  acquires, filtered volts of analog pins
+	MEASure:DIGital? (@list)              acquires, state of digital pins
+	MEASure:TEMPerature?                  acquires, temperature of the U3 in C
+	CONFigure:ANALog (@list)              makes the pins analog inputs
+	CONFigure:DIGital:DIRection OUT,(@list)  makes the pins digital, IN or OUT
+	CONFigure:DIGital:DIRection? (@list)  IN, OUT or ANAL for each pin
+	OUTPut:DIGital 1,(@CIO2)              sets digital outputs, 0, 1, ON or OFF
+	OUTPut:DIGital? (@list)               level written to digital outputs
+	SOURce:VOLTage 2.5,(@DAC0)            sets DAC0 or DAC1 in volts
+	SOURce:VOLTage? (@DAC0,DAC1)          volts last written to the DACs
+
+A channel list is pins named like on the device, and ranges like EIO0:3 or
+EIO0:EIO3.  Configuring goes through the same writes as the configure page and
+outputs through the same write as the measurement page, each command holding
+app.mu.  Errors go on the queue of the connection with the standard numbers
+(-113 Undefined header, -221 Settings conflict, -240 Hardware error and so on),
+at most scpiQueue of them.  Every command that changes something goes in the
+audit log.
+
+SCPI has no login, so whoever reaches the port can drive the outputs and DACs
+whatever the -users file says.  With -users the program will not take SCPI
+unless -unauthenticated is given too, and then the port should only be
+reachable from the test stations, by the address it listens on or a firewall.
+*/
+
+const (
+	scpiQueue   = 20
+	scpiTimeout = 30 * time.Minute //idle connections are dropped after this
+)
+
+type scpiError struct {
+	code int
+	msg  string
+}
+
+func (e *scpiError) Error() string {
+	return fmt.Sprintf("%d,%q", e.code, e.msg)
+}
+
+func scpiErr(code int, msg string, a ...interface{}) *scpiError {
+	return &scpiError{code, fmt.Sprintf(msg, a...)}
+}
+
+var scpiErrorNames = map[int]string{
+	-102: "Syntax error",
+	-108: "Parameter not allowed",
+	-109: "Missing parameter",
+	-113: "Undefined header",
+	-221: "Settings conflict",
+	-222: "Data out of range",
+	-224: "Illegal parameter value",
+	-240: "Hardware error",
+	-350: "Queue overflow",
+}
+
+//scpiSession is one connection with its error queue.
+type scpiSession struct {
+	app  *application
+	from string
+	errs []*scpiError
+}
+
+//push puts err on the queue, with its standard name in front of the message.
+func (s *scpiSession) push(err *scpiError) {
+	if name := scpiErrorNames[err.code]; name != "" && !strings.HasPrefix(err.msg, name) {
+		err = &scpiError{err.code, name + ";" + err.msg}
+	}
+	if len(s.errs) >= scpiQueue {
+		s.errs[scpiQueue-1] = &scpiError{-350, scpiErrorNames[-350]}
+		return
+	}
+	s.errs = append(s.errs, err)
+}
+
+type scpiCommand struct {
+	pattern string //nodes separated by colons, [] around optional ones
+	params  int    //number of parameters before the channel list, -1 for any
+	run     func(s *scpiSession, params []string, chans []string) (string, error)
+}
+
+var scpiCommands = []scpiCommand{
+	{"*IDN?", 0, (*scpiSession).idn},
+	{"*CLS", 0, func(s *scpiSession, _, _ []string) (string, error) { s.errs = nil; return "", nil }},
+	{"*OPC?", 0, func(*scpiSession, []string, []string) (string, error) { return "1", nil }},
+	{"SYSTem:ERRor:[NEXT]?", 0, (*scpiSession).nextError},
+	{"SYSTem:ERRor:COUNt?", 0, func(s *scpiSession, _, _ []string) (string, error) {
+		return strconv.Itoa(len(s.errs)), nil
+	}},
+	{"MEASure:VOLTage:[DC]?", 0, (*scpiSession).measureVolts},
+	{"MEASure:DIGital?", 0, (*scpiSession).measureDigital},
+	{"MEASure:TEMPerature?", 0, (*scpiSession).measureTemperature},
+	{"CONFigure:ANALog", 0, (*scpiSession).configureAnalog},
+	{"CONFigure:DIGital:DIRection", 1, (*scpiSession).configureDirection},
+	{"CONFigure:DIGital:DIRection?", 0, (*scpiSession).direction},
+	{"OUTPut:DIGital", 1, (*scpiSession).outputDigital},
+	{"OUTPut:DIGital?", 0, (*scpiSession).outputLevels},
+	{"SOURce:VOLTage", 1, (*scpiSession).sourceVolts},
+	{"SOURce:VOLTage?", 0, (*scpiSession).sourceLevels},
+}
+
+//scpiNode matches one node of a header against the long form node, whose
+//upper case letters are the short form.
+func scpiNode(node, long string) bool {
+	short := strings.TrimRightFunc(long, func(r rune) bool { return r >= 'a' && r <= 'z' })
+	node = strings.ToUpper(node)
+	return node == strings.ToUpper(long) || node == short
+}
+
+//scpiMatch is true when header (upper or lower case, query mark and all) is
+//a form of pattern.
+func scpiMatch(pattern, header string) bool {
+	if strings.HasSuffix(pattern, "?") != strings.HasSuffix(header, "?") {
+		return false
+	}
+	want := strings.Split(strings.TrimSuffix(pattern, "?"), ":")
+	got := strings.Split(strings.TrimPrefix(strings.TrimSuffix(header, "?"), ":"), ":")
+	var match func(w, g []string) bool
+	match = func(w, g []string) bool {
+		if len(w) == 0 {
+			return len(g) == 0
+		}
+		long := strings.Trim(w[0], "[]")
+		if long != w[0] && match(w[1:], g) {
+			return true
+		}
+		return len(g) > 0 && scpiNode(g[0], long) && match(w[1:], g[1:])
+	}
+	return match(want, got)
+}
+
+//scpiSplit splits s at sep outside of parentheses and quotes.
+func scpiSplit(s string, sep rune) []string {
+	parts := []string{}
+	depth, quoted, start := 0, false, 0
+	for i, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+//scpiChannels reads a channel list such as (@FIO4,EIO0:3).
+func scpiChannels(list string) ([]string, error) {
+	list = strings.TrimSpace(list)
+	if !strings.HasPrefix(list, "(@") || !strings.HasSuffix(list, ")") {
+		return nil, scpiErr(-224, "%s is not a channel list like (@FIO4)", list)
+	}
+	names := []string{}
+	for _, item := range strings.Split(list[2:len(list)-1], ",") {
+		item = strings.ToUpper(strings.TrimSpace(item))
+		if item == "DAC0" || item == "DAC1" {
+			names = append(names, item)
+			continue
+		}
+		ends := strings.SplitN(item, ":", 2)
+		first, err := jack.Channel(ends[0])
+		if err != nil {
+			return nil, scpiErr(-224, "%v", err)
+		}
+		last := first
+		if len(ends) == 2 {
+			end := ends[1]
+			if _, err := strconv.Atoi(end); err == nil {
+				end = ends[0][:3] + end
+			}
+			if last, err = jack.Channel(end); err != nil || last < first || last/8 != first/8 {
+				return nil, scpiErr(-224, "%s is not a range of one port", item)
+			}
+		}
+		for ch := first; ch <= last; ch++ {
+			names = append(names, jack.ChannelName(ch))
+		}
+	}
+	return names, nil
+}
+
+//serveSCPI listens on addr and serves each connection, it only returns when
+//the listener fails.
+func (app *application) serveSCPI(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	app.infoLog.Printf("SCPI server on %s", addr)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go app.scpiConn(conn)
+	}
+}
+
+func (app *application) scpiConn(conn net.Conn) {
+	defer conn.Close()
+	s := &scpiSession{app: app, from: "scpi " + conn.RemoteAddr().String()}
+	scanner := bufio.NewScanner(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(scpiTimeout))
+		if !scanner.Scan() {
+			return
+		}
+		if out := s.line(scanner.Text()); out != "" {
+			if _, err := fmt.Fprintln(conn, out); err != nil {
+				return
+			}
+		}
+	}
+}
+
+//line runs the commands on one line and returns the answers of its queries.
+func (s *scpiSession) line(text string) string {
+	answers := []string{}
+	for _, cmd := range scpiSplit(strings.TrimSpace(text), ';') {
+		cmd = strings.TrimSpace(cmd)
+		if cmd == "" {
+			continue
+		}
+		answer, err := s.run(cmd)
+		if err != nil {
+			e, ok := err.(*scpiError)
+			if !ok {
+				e = scpiErr(-240, "%v", err)
+			}
+			s.push(e)
+			continue
+		}
+		if strings.HasSuffix(strings.Fields(cmd)[0], "?") {
+			answers = append(answers, answer)
+		}
+	}
+	return strings.Join(answers, ";")
+}
+
+//run runs one command, header and parameters.
+func (s *scpiSession) run(cmd string) (string, error) {
+	header, rest := cmd, ""
+	if i := strings.IndexAny(cmd, " \t"); i >= 0 {
+		header, rest = cmd[:i], strings.TrimSpace(cmd[i:])
+	}
+	for _, c := range scpiCommands {
+		if !scpiMatch(c.pattern, header) {
+			continue
+		}
+		params := []string{}
+		if rest != "" {
+			params = scpiSplit(rest, ',')
+		}
+		var chans []string
+		if n := len(params); n > 0 && strings.HasPrefix(strings.TrimSpace(params[n-1]), "(") {
+			var err error
+			if chans, err = scpiChannels(params[n-1]); err != nil {
+				return "", err
+			}
+			params = params[:n-1]
+		}
+		for i := range params {
+			params[i] = strings.TrimSpace(params[i])
+		}
+		switch {
+		case len(params) > c.params:
+			return "", scpiErr(-108, "%s takes %d parameters", header, c.params)
+		case len(params) < c.params:
+			return "", scpiErr(-109, "%s", header)
+		}
+		s.app.lock(s.from)
+		defer s.app.unlock()
+		return c.run(s, params, chans)
+	}
+	return "", scpiErr(-113, "%s", header)
+}
+
+//needChannels is the error for a command given no channel list.
+func needChannels(chans []string) error {
+	if len(chans) == 0 {
+		return scpiErr(-109, "a channel list like (@FIO4) is needed")
+	}
+	return nil
+}
+
+//pins returns the pins of chans, which must not have the DACs in them.
+func (s *scpiSession) pins(chans []string) ([]*Pin, error) {
+	if err := needChannels(chans); err != nil {
+		return nil, err
+	}
+	pins := []*Pin{}
+	for _, name := range chans {
+		pin, _, err := s.app.u3.pinByName(name)
+		if err != nil {
+			return nil, scpiErr(-224, "%s is not a pin", name)
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+//idn reads the ConfigU3 of the selected device into a scratch U3, as
+//scanDevices does, so the pin settings in app.u3 are not touched.
+func (s *scpiSession) idn(_, _ []string) (string, error) {
+	c, err := s.app.readConfig(s.app.u3.DeviceNumber)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("LabJack,%s,%s,%s", c.DeviceName, c.SerialNumber, c.FirmwareVersion), nil
+}
+
+func (s *scpiSession) nextError(_, _ []string) (string, error) {
+	if len(s.errs) == 0 {
+		return `0,"No error"`, nil
+	}
+	e := s.errs[0]
+	s.errs = s.errs[1:]
+	return e.Error(), nil
+}
+
+func (s *scpiSession) measureVolts(_, chans []string) (string, error) {
+	pins, err := s.pins(chans)
+	if err != nil {
+		return "", err
+	}
+	for i, pin := range pins {
+		if pin.AD != "Analog" {
+			return "", scpiErr(-221, "%s is not analog", chans[i])
+		}
+	}
+	if err := s.app.acquire(); err != nil {
+		return "", err
+	}
+	out := []string{}
+	for i, pin := range pins {
+		if _, err := strconv.ParseFloat(pin.FilteredVoltage, 64); err != nil {
+			return "", scpiErr(-221, "%s: %s", chans[i], pin.FilteredVoltage)
+		}
+		out = append(out, pin.FilteredVoltage)
+	}
+	return strings.Join(out, ","), nil
+}
+
+func (s *scpiSession) measureDigital(_, chans []string) (string, error) {
+	pins, err := s.pins(chans)
+	if err != nil {
+		return "", err
+	}
+	for i, pin := range pins {
+		if pin.AD != "Digital" {
+			return "", scpiErr(-221, "%s is not digital", chans[i])
+		}
+	}
+	if err := s.app.acquire(); err != nil {
+		return "", err
+	}
+	out := []string{}
+	for _, pin := range pins {
+		v, _, _ := pin.pinValue()
+		out = append(out, number(v))
+	}
+	return strings.Join(out, ","), nil
+}
+
+func (s *scpiSession) measureTemperature(_, _ []string) (string, error) {
+	if err := s.app.acquire(); err != nil {
+		return "", err
+	}
+	if !s.app.u3.temperatureRead {
+		return "", scpiErr(-240, "the temperature could not be read")
+	}
+	return strconv.FormatFloat(s.app.u3.Temperature, 'f', 2, 64), nil
+}
+
+//settable is the error for a pin whose analog/digital setting can not be
+//changed: FIO0-3 are taken as analog only as on the U3-HV, see copyToWriteJack.
+func settable(name string, ch int) error {
+	switch {
+	case ch < 4:
+		return scpiErr(-221, "%s is analog only", name)
+	case ch >= 16:
+		return scpiErr(-221, "%s is digital only", name)
+	}
+	return nil
+}
+
+func (s *scpiSession) configureAnalog(_, chans []string) (string, error) {
+	pins, err := s.pins(chans)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range chans {
+		ch, _ := jack.Channel(name)
+		if err := settable(name, ch); err != nil {
+			return "", err
+		}
+	}
+	for _, pin := range pins {
+		pin.AD = "Analog"
+	}
+	s.app.audit.Printf("%s configured %s analog", s.from, strings.Join(chans, ","))
+	return "", s.app.writeConfig()
+}
+
+func (s *scpiSession) configureDirection(params, chans []string) (string, error) {
+	pins, err := s.pins(chans)
+	if err != nil {
+		return "", err
+	}
+	var io string
+	switch p := strings.ToUpper(params[0]); {
+	case scpiNode(p, "INput"):
+		io = "Input"
+	case scpiNode(p, "OUTput"):
+		io = "Output"
+	default:
+		return "", scpiErr(-224, "%s is not IN or OUT", params[0])
+	}
+	for i, pin := range pins {
+		ch, _ := jack.Channel(chans[i])
+		if err := settable(chans[i], ch); pin.AD != "Digital" && err != nil {
+			return "", err
+		}
+	}
+	for _, pin := range pins {
+		pin.AD = "Digital"
+		pin.IO = io
+	}
+	s.app.audit.Printf("%s configured %s digital %s", s.from, strings.Join(chans, ","), strings.ToLower(io))
+	return "", s.app.writeConfig()
+}
+
+func (s *scpiSession) direction(_, chans []string) (string, error) {
+	pins, err := s.pins(chans)
+	if err != nil {
+		return "", err
+	}
+	out := []string{}
+	for _, pin := range pins {
+		switch {
+		case pin.AD == "Analog":
+			out = append(out, "ANAL")
+		case pin.IO == "Output":
+			out = append(out, "OUT")
+		default:
+			out = append(out, "IN")
+		}
+	}
+	return strings.Join(out, ","), nil
+}
+
+func (s *scpiSession) outputDigital(params, chans []string) (string, error) {
+	pins, err := s.pins(chans)
+	if err != nil {
+		return "", err
+	}
+	level := 0
+	switch strings.ToUpper(params[0]) {
+	case "1", "ON":
+		level = 1
+	case "0", "OFF":
+	default:
+		return "", scpiErr(-224, "%s is not 0, 1, ON or OFF", params[0])
+	}
+	for i, pin := range pins {
+		if pin.AD != "Digital" || pin.IO != "Output" {
+			return "", scpiErr(-221, "%s is not a digital output", chans[i])
+		}
+	}
+	for _, pin := range pins {
+		pin.DigitalWrite = level
+	}
+	s.app.audit.Printf("%s set %s to %d", s.from, strings.Join(chans, ","), level)
+	s.app.copyToWirteDigitalOutput(jack.PortStateWrite)
+	return "", s.app.u3SendRec(jack.PortStateWrite, 0x01)
+}
+
+func (s *scpiSession) outputLevels(_, chans []string) (string, error) {
+	pins, err := s.pins(chans)
+	if err != nil {
+		return "", err
+	}
+	out := []string{}
+	for i, pin := range pins {
+		if pin.AD != "Digital" || pin.IO != "Output" {
+			return "", scpiErr(-221, "%s is not a digital output", chans[i])
+		}
+		out = append(out, strconv.Itoa(pin.DigitalWrite))
+	}
+	return strings.Join(out, ","), nil
+}
+
+//dacs returns the DAC numbers of chans, which must only have DAC0 and DAC1.
+func dacs(chans []string) ([]int, error) {
+	if err := needChannels(chans); err != nil {
+		return nil, err
+	}
+	out := []int{}
+	for _, name := range chans {
+		if name != "DAC0" && name != "DAC1" {
+			return nil, scpiErr(-224, "%s is not DAC0 or DAC1", name)
+		}
+		out = append(out, int(name[3]-'0'))
+	}
+	return out, nil
+}
+
+func (s *scpiSession) sourceVolts(params, chans []string) (string, error) {
+	list, err := dacs(chans)
+	if err != nil {
+		return "", err
+	}
+	volts, err := strconv.ParseFloat(params[0], 64)
+	if err != nil {
+		return "", scpiErr(-224, "%s is not a number", params[0])
+	}
+	if math.IsNaN(volts) || volts < 0 || volts > 5 {
+		return "", scpiErr(-222, "%g volts, the DACs go from 0 to 5", volts)
+	}
+	for _, dac := range list {
+		if err := s.app.setDAC(dac, volts); err != nil {
+			return "", err
+		}
+		s.app.audit.Printf("%s set DAC%d to %g V", s.from, dac, volts)
+	}
+	return "", nil
+}
+
+func (s *scpiSession) sourceLevels(_, chans []string) (string, error) {
+	list, err := dacs(chans)
+	if err != nil {
+		return "", err
+	}
+	out := []string{}
+	for _, dac := range list {
+		out = append(out, number(s.app.u3.DAC[dac]))
+	}
+	return strings.Join(out, ","), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSCPIMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		header  string
+		want    bool
+	}{
+		{"*IDN?", "*IDN?", true},
+		{"*IDN?", "*idn?", true},
+		{"*IDN?", "*IDN", false},
+		{"*CLS", "*CLS?", false},
+		{"MEASure:VOLTage:[DC]?", "MEAS:VOLT?", true},
+		{"MEASure:VOLTage:[DC]?", "MEASURE:VOLTAGE:DC?", true},
+		{"MEASure:VOLTage:[DC]?", "meas:volt:dc?", true},
+		{"MEASure:VOLTage:[DC]?", ":MEAS:VOLT?", true},
+		{"MEASure:VOLTage:[DC]?", "MEAS:VOLT:AC?", false},
+		{"MEASure:VOLTage:[DC]?", "MEASU:VOLT?", false},
+		{"MEASure:VOLTage:[DC]?", "MEA:VOLT?", false},
+		{"MEASure:VOLTage:[DC]?", "MEAS?", false},
+		{"MEASure:VOLTage:[DC]?", "MEAS:VOLT:DC:DC?", false},
+		{"SYSTem:ERRor:[NEXT]?", "SYST:ERR?", true},
+		{"SYSTem:ERRor:[NEXT]?", "SYSTEM:ERROR:NEXT?", true},
+		{"SYSTem:ERRor:[NEXT]?", "SYST:ERR:COUN?", false},
+		{"SYSTem:ERRor:COUNt?", "SYST:ERR:COUN?", true},
+		{"SYSTem:ERRor:COUNt?", "SYST:ERR?", false},
+		{"CONFigure:DIGital:DIRection", "CONF:DIG:DIR", true},
+		{"CONFigure:DIGital:DIRection", "CONF:DIG:DIR?", false},
+		{"CONFigure:DIGital:DIRection?", "conf:dig:dir?", true},
+		{"SOURce:VOLTage", "SOUR:VOLT", true},
+		{"SOURce:VOLTage", "SOURCE:VOLT", true},
+		{"SOURce:VOLTage", "SOURC:VOLT", false},
+		{"SOURce:VOLTage", "", false},
+	}
+	for _, tt := range tests {
+		if got := scpiMatch(tt.pattern, tt.header); got != tt.want {
+			t.Errorf("scpiMatch(%q, %q) = %v, want %v", tt.pattern, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestSCPISplit(t *testing.T) {
+	tests := []struct {
+		in   string
+		sep  rune
+		want []string
+	}{
+		{"*IDN?", ';', []string{"*IDN?"}},
+		{"*CLS;*OPC?", ';', []string{"*CLS", "*OPC?"}},
+		{"OUTP:DIG 1,(@FIO4,EIO0:3)", ',', []string{"OUTP:DIG 1", "(@FIO4,EIO0:3)"}},
+		{`DISP:TEXT "a;b";*OPC?`, ';', []string{`DISP:TEXT "a;b"`, "*OPC?"}},
+		{"", ';', []string{""}},
+	}
+	for _, tt := range tests {
+		if got := scpiSplit(tt.in, tt.sep); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("scpiSplit(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSCPIChannels(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+		code int //of the error, 0 for none
+	}{
+		{"(@FIO4)", []string{"FIO4"}, 0},
+		{" (@fio4, eio0) ", []string{"FIO4", "EIO0"}, 0},
+		{"(@EIO0:3)", []string{"EIO0", "EIO1", "EIO2", "EIO3"}, 0},
+		{"(@EIO0:EIO2)", []string{"EIO0", "EIO1", "EIO2"}, 0},
+		{"(@CIO0:3,FIO7)", []string{"CIO0", "CIO1", "CIO2", "CIO3", "FIO7"}, 0},
+		{"(@FIO5:5)", []string{"FIO5"}, 0},
+		{"(@DAC0,dac1)", []string{"DAC0", "DAC1"}, 0},
+		{"FIO4", nil, -224},
+		{"(FIO4)", nil, -224},
+		{"(@FIO4", nil, -224},
+		{"(@FIO8)", nil, -224},
+		{"(@CIO4)", nil, -224},
+		{"(@AIN0)", nil, -224},
+		{"(@)", nil, -224},
+		{"(@FIO4:2)", nil, -224},
+		{"(@FIO7:EIO0)", nil, -224},
+		{"(@EIO0:CIO1)", nil, -224},
+		{"(@EIO0:9)", nil, -224},
+		{"(@DAC0:1)", nil, -224},
+	}
+	for _, tt := range tests {
+		got, err := scpiChannels(tt.list)
+		if tt.code != 0 {
+			e, ok := err.(*scpiError)
+			if !ok || e.code != tt.code {
+				t.Errorf("scpiChannels(%q) = %q, %v, want error %d", tt.list, got, err, tt.code)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("scpiChannels(%q) = %q, %v, want %q", tt.list, got, err, tt.want)
+		}
+	}
+}
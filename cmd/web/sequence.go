@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Sequence file holds the test sequences, small scripts of steps run by the server
+against the U3, one step per line:
+
+	# power board test
+	set CIO0 1          set a digital output high (or 0 for low)
+	wait 200ms          wait, any Go duration such as 1s or 1.5s
+	read FIO4           acquire and record the value of FIO4
+	assert FIO4 1.1 1.3 pass when the last read of FIO4 is within 1.1 to 1.3
+	assert EIO1 1       pass when the last read of EIO1 is exactly 1
+
+Blank lines and lines starting with # are skipped.  Pins are compared by their
+pin value, the same one the alarms and interlocks use (scaled value for analog
+pins, state for digital pins), so the pins must be configured before the run.
+A failed assert fails the run but the rest of the steps still run.  An error
+from the device or a set on a pin that is not a digital output stops the run.
+
+Every run is kept as a SequenceRun with the result of each step.  The last
+maxRuns of them are in app.u3.Runs for the pages, and when the program runs
+with -reports each one is also written there as a JSON file.  With -sequences
+the sequences themselves are kept in a directory as name.seq files.
+*/
+
+const (
+	maxRuns = 100
+	maxWait = 10 * time.Minute //longest single wait step
+)
+
+var sequenceName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+type step struct {
+	line int
+	text string
+	op   string //set, wait, read or assert
+	pin  string
+	vals []float64 //level for set, low and high for assert
+	wait time.Duration
+}
+
+type StepResult struct {
+	Line   int
+	Step   string
+	Value  string //value read or asserted, if any
+	Passed bool
+	Error  string
+	Time   time.Time
+}
+
+type SequenceRun struct {
+	ID       int
+	Sequence string
+	Started  time.Time
+	Finished time.Time
+	Passed   bool
+	Steps    []StepResult
+	Report   string //file the run was written to, if any
+}
+
+/*
+parseSequence parses the text of a sequence into steps, see the top of the file.
+All the errors are reported with their line numbers before anything runs.
+*/
+func parseSequence(u *U3, text string) ([]step, error) {
+	steps := []step{}
+	errs := []string{}
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s, err := parseStep(u, line)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("line %d: %v", i+1, err))
+			continue
+		}
+		s.line = i + 1
+		steps = append(steps, s)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("the sequence has no steps")
+	}
+	return steps, nil
+}
+
+func parseStep(u *U3, line string) (step, error) {
+	f := strings.Fields(line)
+	s := step{text: line, op: strings.ToLower(f[0])}
+	nums := func(f []string) error {
+		for _, x := range f {
+			v, err := strconv.ParseFloat(x, 64)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", x)
+			}
+			s.vals = append(s.vals, v)
+		}
+		return nil
+	}
+	if s.op != "wait" && len(f) > 1 {
+		s.pin = strings.ToUpper(f[1])
+		if _, _, err := u.pinByName(s.pin); err != nil {
+			return s, err
+		}
+	}
+	switch {
+	case s.op == "set" && len(f) == 3:
+		if f[2] != "0" && f[2] != "1" {
+			return s, fmt.Errorf("set needs a level of 0 or 1")
+		}
+		return s, nums(f[2:])
+	case s.op == "wait" && len(f) == 2:
+		d, err := time.ParseDuration(f[1])
+		if err != nil || d < 0 || d > maxWait {
+			return s, fmt.Errorf("%q is not a wait, use a duration such as 200ms up to %v", f[1], maxWait)
+		}
+		s.wait = d
+		return s, nil
+	case s.op == "read" && len(f) == 2:
+		return s, nil
+	case s.op == "assert" && (len(f) == 3 || len(f) == 4):
+		if err := nums(f[2:]); err != nil {
+			return s, err
+		}
+		if len(s.vals) == 1 {
+			s.vals = append(s.vals, s.vals[0])
+		}
+		if s.vals[0] > s.vals[1] {
+			return s, fmt.Errorf("assert low is over high")
+		}
+		return s, nil
+	}
+	return s, fmt.Errorf("%q is not a step, use set, wait, read or assert", line)
+}
+
+/*
+runSequence runs the steps of the sequence called name and keeps the run.  It
+must be called without app.mu held, it takes it for each step and lets it go
+during waits so the pages and the poll keep working.  from is who started it,
+for the transaction log.
+*/
+func (app *application) runSequence(from, name, text string) (*SequenceRun, error) {
+	//the name goes in the report file name
+	if !sequenceName.MatchString(name) {
+		return nil, fmt.Errorf("%q is not a sequence name, use letters, digits, - and _", name)
+	}
+	app.mu.Lock()
+	steps, err := parseSequence(app.u3, text)
+	if err == nil && app.u3.Running != "" {
+		err = fmt.Errorf("sequence %s is already running", app.u3.Running)
+	}
+	if err != nil {
+		app.mu.Unlock()
+		return nil, err
+	}
+	app.u3.Running = name
+	app.mu.Unlock()
+	defer func() {
+		app.mu.Lock()
+		app.u3.Running = ""
+		app.mu.Unlock()
+	}()
+
+	run := &SequenceRun{Sequence: name, Started: time.Now(), Passed: true}
+	read := map[string]float64{} //last value read of each pin in this run
+	for _, s := range steps {
+		res := StepResult{Line: s.line, Step: s.text, Passed: true}
+		if s.op == "wait" {
+			time.Sleep(s.wait)
+		} else {
+			app.lock("sequence " + name + ", " + from)
+			err = app.runStep(s, read, &res)
+			app.unlock()
+		}
+		res.Time = time.Now()
+		if !res.Passed {
+			run.Passed = false
+		}
+		run.Steps = append(run.Steps, res)
+		if err != nil {
+			break
+		}
+	}
+	run.Finished = time.Now()
+
+	app.mu.Lock()
+	run.ID = 1
+	if n := len(app.u3.Runs); n > 0 {
+		run.ID = app.u3.Runs[n-1].ID + 1
+	}
+	if err := app.saveReport(run); err != nil {
+		app.errorLog.Printf("sequence report: %v", err)
+	}
+	app.u3.Runs = append(app.u3.Runs, run)
+	if len(app.u3.Runs) > maxRuns {
+		app.u3.Runs = app.u3.Runs[len(app.u3.Runs)-maxRuns:]
+	}
+	app.mu.Unlock()
+	app.infoLog.Printf("sequence %s run %d passed: %v", name, run.ID, run.Passed)
+	return run, nil
+}
+
+//runStep runs one step other than a wait.  It fills in the result and returns
+//an error when the run has to stop.  Must be called with app.mu held.
+func (app *application) runStep(s step, read map[string]float64, res *StepResult) error {
+	fail := func(err error) error {
+		res.Passed = false
+		res.Error = err.Error()
+		return err
+	}
+	pin, _, _ := app.u3.pinByName(s.pin)
+	switch s.op {
+	case "set":
+		if err := app.setDigital(s.pin, int(s.vals[0])); err != nil {
+			return fail(err)
+		}
+		res.Value = strconv.Itoa(pin.DigitalWrite)
+	case "read":
+		if err := app.acquire(); err != nil {
+			return fail(err)
+		}
+		v, _, ok := pin.pinValue()
+		if !ok && pin.OutOfRange {
+			return fail(fmt.Errorf("%s is out of range of its scale", s.pin))
+		}
+		if !ok {
+			return fail(fmt.Errorf("%s is not in use", s.pin))
+		}
+		read[s.pin] = v
+		res.Value = strconv.FormatFloat(v, 'g', 6, 64)
+	case "assert":
+		v, ok := read[s.pin]
+		if !ok {
+			res.Passed = false
+			res.Error = s.pin + " has not been read"
+			return nil
+		}
+		res.Value = strconv.FormatFloat(v, 'g', 6, 64)
+		if math.IsNaN(v) || v < s.vals[0] || v > s.vals[1] {
+			res.Passed = false
+			res.Error = fmt.Sprintf("%s is %s, not within %g to %g", s.pin, res.Value, s.vals[0], s.vals[1])
+		}
+	}
+	return nil
+}
+
+//must be called with app.mu held.
+func (app *application) findRun(id int) *SequenceRun {
+	for _, run := range app.u3.Runs {
+		if run.ID == id {
+			return run
+		}
+	}
+	return nil
+}
+
+//<++++++++++++++++++++++++   sequence and report files   ++++++++++++++++++++++++>
+
+//the reports are written to app.reportDir, when given, one JSON file per run.
+func (app *application) saveReport(run *SequenceRun) error {
+	if app.reportDir == "" {
+		return nil
+	}
+	name := fmt.Sprintf("%s-%s-%d.json", run.Sequence, run.Started.Format("20060102-150405"), run.ID)
+	run.Report = filepath.Join(app.reportDir, name)
+	b, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(run.Report, b, 0644)
+}
+
+//listSequences returns the names of the sequences in app.sequenceDir.
+func (app *application) listSequences() ([]string, error) {
+	if app.sequenceDir == "" {
+		return nil, nil
+	}
+	files, err := filepath.Glob(filepath.Join(app.sequenceDir, "*.seq"))
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, f := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(f), ".seq"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (app *application) sequencePath(name string) (string, error) {
+	if app.sequenceDir == "" {
+		return "", fmt.Errorf("run the program with -sequences to keep sequences")
+	}
+	if !sequenceName.MatchString(name) {
+		return "", fmt.Errorf("%q is not a sequence name, use letters, digits, - and _", name)
+	}
+	return filepath.Join(app.sequenceDir, name+".seq"), nil
+}
+
+func (app *application) loadSequence(name string) (string, error) {
+	p, err := app.sequencePath(name)
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("there is no sequence %s", name)
+	}
+	return string(b), err
+}
+
+func (app *application) saveSequence(name, text string) error {
+	p, err := app.sequencePath(name)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, []byte(text), 0644)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Saied74/labjack/pkg/jack"
+)
+
+/*
+Txlog file keeps a record of every command sent to the device: when, to which
+device, the command and its decoded fields, the decoded response, the error if
+any, how long it took and what it was done for (the user and page, the poll,
+a sequence).  The last maxTransactions are kept in memory and, with -txlog,
+every one is appended to the file as a line of JSON so the record outlives the
+program.  The transactions page and /api/transactions query the memory, only
+the export reads the file, without holding app.mu.  When the file grows past
+maxTxLogSize it is renamed with .1 on the end, replacing the one before, and a
+new one started, so the export can reach back two files.
+
+The origin of a transaction is whoever holds app.mu, so the device is locked
+with lock rather than app.mu.Lock.
+*/
+
+const (
+	maxTransactions = 1000
+	defaultTxLimit  = 200
+	maxTxLogSize    = 64 << 20 //bytes
+)
+
+//Transaction is one command sent to the device and its response.
+type Transaction struct {
+	ID        int
+	Time      time.Time
+	Device    int
+	Op        string
+	WriteMask int
+	Request   jack.Fields
+	Response  jack.Fields
+	Error     string        `json:",omitempty"`
+	Latency   time.Duration //nanoseconds in the JSON
+	Origin    string
+}
+
+//LatencyText is for the pages.
+func (tx Transaction) LatencyText() string {
+	return tx.Latency.Round(10 * time.Microsecond).String()
+}
+
+//lock takes app.mu for from, which is put on every transaction made until
+//unlock.
+func (app *application) lock(from string) {
+	app.mu.Lock()
+	app.origin = from
+}
+
+func (app *application) unlock() {
+	app.origin = ""
+	app.mu.Unlock()
+}
+
+//origin is the user and page of a request.
+func origin(r *http.Request) string {
+	return who(r) + " " + r.Method + " " + r.URL.Path
+}
+
+//logTransaction numbers tx and keeps it.  Must be called with app.mu held.
+func (app *application) logTransaction(tx Transaction) {
+	app.lastTx++
+	tx.ID = app.lastTx
+	tx.Origin = app.origin
+	app.transactions = append(app.transactions, tx)
+	if len(app.transactions) > maxTransactions {
+		app.transactions = app.transactions[len(app.transactions)-maxTransactions:]
+	}
+	if app.txFile == nil {
+		return
+	}
+	b, err := json.Marshal(tx)
+	if err == nil {
+		var n int
+		n, err = app.txFile.Write(append(b, '\n'))
+		app.txSize += int64(n)
+	}
+	if err == nil && app.txSize > maxTxLogSize {
+		err = app.rotateTxLog()
+	}
+	if err != nil {
+		app.errorLog.Printf("transaction log: %v", err)
+	}
+}
+
+//rotateTxLog moves the -txlog file to the .1 file and starts a new one.  Must
+//be called with app.mu held.
+func (app *application) rotateTxLog() error {
+	app.txFile.Close()
+	err := os.Rename(app.txPath, app.txPath+".1")
+	file, e := os.OpenFile(app.txPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if e != nil {
+		//the transactions are still kept in memory
+		app.txFile = nil
+		return e
+	}
+	app.txFile, app.txSize = file, 0
+	return err
+}
+
+//openTxLog opens the -txlog file for appending and picks up the numbering
+//and the last maxTransactions from what is already in it.
+func (app *application) openTxLog(path string) error {
+	err := scanTransactions(path, func(tx Transaction) {
+		app.lastTx = tx.ID
+		app.transactions = append(app.transactions, tx)
+		if len(app.transactions) > 2*maxTransactions {
+			app.transactions = append([]Transaction{}, app.transactions[maxTransactions:]...)
+		}
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(app.transactions) > maxTransactions {
+		app.transactions = app.transactions[len(app.transactions)-maxTransactions:]
+	}
+	app.txPath = path
+	app.txFile, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := app.txFile.Stat()
+	if err == nil {
+		app.txSize = info.Size()
+	}
+	return err
+}
+
+//scanTransactions calls f for every transaction in the file at path, oldest
+//first.  Lines that are not transactions are skipped.
+func scanTransactions(path string, f func(Transaction)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var tx Transaction
+		if json.Unmarshal(scanner.Bytes(), &tx) == nil && tx.ID > 0 {
+			f(tx)
+		}
+	}
+	return scanner.Err()
+}
+
+//TxFilter picks transactions, the zero value of each field matches them all.
+//Limit keeps the newest ones.
+type TxFilter struct {
+	Op     string
+	Origin string //part of the origin, case insensitive
+	Device int
+	Errors bool //only the ones that failed
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+//parseTxFilter reads the filter from the query parameters op, origin, device,
+//errors, since, until (2006-01-02T15:04 in local time, or RFC 3339) and limit.
+func parseTxFilter(v url.Values) (TxFilter, error) {
+	f := TxFilter{Op: v.Get("op"), Origin: v.Get("origin"), Limit: defaultTxLimit}
+	f.Errors = v.Get("errors") != ""
+	var err error
+	if s := v.Get("device"); s != "" {
+		if f.Device, err = strconv.Atoi(s); err != nil {
+			return f, fmt.Errorf("device %q is not a number", s)
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		if f.Limit, err = strconv.Atoi(s); err != nil || f.Limit < 1 {
+			return f, fmt.Errorf("limit %q is not a positive number", s)
+		}
+	}
+	for _, t := range []struct {
+		name string
+		to   *time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		s := v.Get(t.name)
+		if s == "" {
+			continue
+		}
+		if *t.to, err = parseFormTime(s); err != nil {
+			return f, fmt.Errorf("%s %v", t.name, err)
+		}
+	}
+	return f, nil
+}
+
+func (f TxFilter) match(tx Transaction) bool {
+	switch {
+	case f.Op != "" && tx.Op != f.Op:
+	case f.Device != 0 && tx.Device != f.Device:
+	case f.Errors && tx.Error == "":
+	case !f.Since.IsZero() && tx.Time.Before(f.Since):
+	case !f.Until.IsZero() && tx.Time.After(f.Until):
+	case f.Origin != "" && !strings.Contains(strings.ToLower(tx.Origin), strings.ToLower(f.Origin)):
+	default:
+		return true
+	}
+	return false
+}
+
+//SinceText and UntilText refill the form on the transactions page.
+func (f TxFilter) SinceText() string { return formTime(f.Since) }
+func (f TxFilter) UntilText() string { return formTime(f.Until) }
+
+func formTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format("2006-01-02T15:04")
+}
+
+//queryTransactions returns the newest f.Limit transactions kept in memory
+//that match f, oldest first.  Must be called with app.mu held.
+func (app *application) queryTransactions(f TxFilter) []Transaction {
+	found := newTxFinder(f)
+	for _, tx := range app.transactions {
+		found.keep(tx)
+	}
+	return found.result()
+}
+
+/*
+readTxLog is queryTransactions over the whole -txlog file and the .1 file
+before it, for the export.  It is called without app.mu held, the file is only
+appended to and a line being written when it is read is skipped.  Without
+-txlog it queries the memory.
+*/
+func (app *application) readTxLog(f TxFilter) ([]Transaction, error) {
+	if app.txPath == "" {
+		app.mu.Lock()
+		defer app.mu.Unlock()
+		return app.queryTransactions(f), nil
+	}
+	found := newTxFinder(f)
+	err := scanTransactions(app.txPath+".1", found.keep)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := scanTransactions(app.txPath, found.keep); err != nil {
+		return nil, err
+	}
+	return found.result(), nil
+}
+
+//txFinder keeps the newest transactions that match its filter.
+type txFinder struct {
+	f     TxFilter
+	found []Transaction
+}
+
+func newTxFinder(f TxFilter) *txFinder {
+	return &txFinder{f: f, found: []Transaction{}}
+}
+
+func (t *txFinder) keep(tx Transaction) {
+	if !t.f.match(tx) {
+		return
+	}
+	t.found = append(t.found, tx)
+	if len(t.found) > 2*t.f.Limit {
+		t.found = append([]Transaction{}, t.found[len(t.found)-t.f.Limit:]...)
+	}
+}
+
+func (t *txFinder) result() []Transaction {
+	if len(t.found) > t.f.Limit {
+		return t.found[len(t.found)-t.f.Limit:]
+	}
+	return t.found
+}
+
+//writeTransactionsCSV writes one row per transaction, the fields as name=value
+//lists and the latency in milliseconds.
+func writeTransactionsCSV(w io.Writer, txs []Transaction) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "time", "device", "op", "write_mask", "request",
+		"response", "error", "latency_ms", "origin"})
+	for _, tx := range txs {
+		cw.Write([]string{strconv.Itoa(tx.ID), tx.Time.Format(time.RFC3339Nano),
+			strconv.Itoa(tx.Device), tx.Op, strconv.Itoa(tx.WriteMask),
+			tx.Request.String(), tx.Response.String(), tx.Error,
+			strconv.FormatFloat(float64(tx.Latency)/float64(time.Millisecond), 'f', 3, 64),
+			tx.Origin})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Waveform file holds the waveform generator on DAC0 and DAC1.  A Waveform is a
+shape (Sine, Triangle, Ramp, Square, or Arbitrary for a cycle of points from a
+CSV file), an amplitude and offset in volts, a frequency, and the updates a
+second to write it with.  Each update is one DAC Feedback write, timed by the
+host, so the rate is what the USB link allows: one packet there and back,
+typically a millisecond or more, and the host scheduler on top of that.  There
+is nothing faster to be had by writing the DAC this way.
+
+The value written at each update is that of the waveform at the time of the
+update, not the next of a list, so the frequency stays right when the updates
+can not keep up, the shape is just drawn with fewer points.  An update whose
+time has passed while the last write was still going is skipped and counted as
+missed.  Every WaveRun reports the rate asked for and the rate achieved, the
+mean and longest write time on the link (what limits the rate) and the points
+per cycle that makes, with a warning when the rate is not met or a cycle has
+too few points to keep its shape.
+
+Each DAC has its own generator, app.u3.Waves holds the one playing or the last
+one played.  Other writes to a DAC that is playing (the api, MQTT, SCPI,
+Modbus) are overwritten by the next update.
+*/
+
+var waveShapes = []string{"Sine", "Triangle", "Ramp", "Square", "Arbitrary"}
+
+const (
+	maxWaveRate   = 1000 //updates a second asked for, well over what the link does
+	maxWavePoints = 10000
+	dacMax        = 5.0  //volts, the DAC range is about 0.04 to 4.95
+	minCyclePts   = 10   //fewer points a cycle than this lose the shape
+	rateShortfall = 0.95 //of the rate asked for, below it is a warning
+)
+
+type Waveform struct {
+	DAC       int
+	Shape     string
+	Amplitude float64   //volts from the offset to the peak, not used by Arbitrary
+	Offset    float64   //volts in the middle, not used by Arbitrary
+	Frequency float64   //cycles a second
+	Rate      float64   //updates a second asked for
+	Seconds   float64   //how long to play, 0 for until stopped
+	Points    []float64 //one cycle of volts for Arbitrary, evenly spaced
+}
+
+type WaveRun struct {
+	Waveform
+	Started      time.Time
+	Finished     time.Time //zero while playing
+	Updates      int
+	Missed       int     //updates skipped because the last write was late
+	Achieved     float64 //updates a second so far
+	CyclePoints  float64 //updates a cycle at the achieved rate
+	RoundTrip    time.Duration
+	MaxRoundTrip time.Duration
+	Warning      string
+	Error        string
+	Stopped      bool
+}
+
+//check checks the waveform, the points of an Arbitrary are checked against
+//the DAC range too.
+func (wf *Waveform) check() error {
+	ok := false
+	for _, s := range waveShapes {
+		ok = ok || s == wf.Shape
+	}
+	//a NaN compares false with anything and would get past the range checks
+	for _, n := range []struct {
+		name string
+		v    float64
+	}{{"amplitude", wf.Amplitude}, {"offset", wf.Offset}, {"frequency", wf.Frequency},
+		{"rate", wf.Rate}, {"time to play", wf.Seconds}} {
+		if math.IsNaN(n.v) || math.IsInf(n.v, 0) {
+			return fmt.Errorf("the %s is %g, it must be a number", n.name, n.v)
+		}
+	}
+	switch {
+	case wf.DAC != 0 && wf.DAC != 1:
+		return fmt.Errorf("there is no DAC%d", wf.DAC)
+	case !ok:
+		return fmt.Errorf("%q is not a shape, use one of %v", wf.Shape, waveShapes)
+	case wf.Frequency <= 0:
+		return fmt.Errorf("the frequency must be over 0 Hz")
+	case wf.Rate <= 0 || wf.Rate > maxWaveRate:
+		return fmt.Errorf("the rate must be over 0 and at most %d updates a second", maxWaveRate)
+	case wf.Seconds < 0:
+		return fmt.Errorf("the time to play can not be negative")
+	}
+	if wf.Shape != "Arbitrary" {
+		if wf.Amplitude < 0 || wf.Offset-wf.Amplitude < 0 || wf.Offset+wf.Amplitude > dacMax {
+			return fmt.Errorf("offset %g V and amplitude %g V go outside of 0 to %g V", wf.Offset, wf.Amplitude, dacMax)
+		}
+		return nil
+	}
+	if len(wf.Points) < 2 || len(wf.Points) > maxWavePoints {
+		return fmt.Errorf("an arbitrary waveform needs 2 to %d points, not %d", maxWavePoints, len(wf.Points))
+	}
+	for i, v := range wf.Points {
+		if math.IsNaN(v) || v < 0 || v > dacMax {
+			return fmt.Errorf("point %d, %g V, is outside of 0 to %g V", i+1, v, dacMax)
+		}
+	}
+	return nil
+}
+
+//value is the volts of the waveform t into it.
+func (wf *Waveform) value(t time.Duration) float64 {
+	_, phase := math.Modf(t.Seconds() * wf.Frequency)
+	switch wf.Shape {
+	case "Sine":
+		return wf.Offset + wf.Amplitude*math.Sin(2*math.Pi*phase)
+	case "Triangle":
+		return wf.Offset + wf.Amplitude*(1-4*math.Abs(phase-0.5))
+	case "Ramp":
+		return wf.Offset + wf.Amplitude*(2*phase-1)
+	case "Square":
+		if phase < 0.5 {
+			return wf.Offset + wf.Amplitude
+		}
+		return wf.Offset - wf.Amplitude
+	}
+	//Arbitrary, the points are evenly spaced over the cycle and the last one
+	//runs back into the first
+	x := phase * float64(len(wf.Points))
+	i := int(x)
+	next := wf.Points[(i+1)%len(wf.Points)]
+	return wf.Points[i] + (next-wf.Points[i])*(x-float64(i))
+}
+
+/*
+parseWaveCSV reads the points of an arbitrary waveform, volts in the last
+column of each row, so a file of volts alone or of time and volts both work.
+A first row that is not a number is taken as the header.
+*/
+func parseWaveCSV(r io.Reader) ([]float64, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	points := []float64{}
+	for row := 1; ; row++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(rec[len(rec)-1]), 64)
+		if err != nil && row == 1 {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %q is not volts", row, rec[len(rec)-1])
+		}
+		points = append(points, v)
+		if len(points) > maxWavePoints {
+			return nil, fmt.Errorf("more than %d points", maxWavePoints)
+		}
+	}
+	return points, nil
+}
+
+//<++++++++++++++++++++++++++   playing waveforms   ++++++++++++++++++++++++++>
+
+//playWave starts wf on its DAC, in a goroutine which it returns the run of.
+//Must be called with app.mu held, the goroutine takes it for each update.
+func (app *application) playWave(from string, wf Waveform) (*WaveRun, error) {
+	if err := wf.check(); err != nil {
+		return nil, err
+	}
+	if run := app.u3.Waves[wf.DAC]; run != nil && run.Finished.IsZero() {
+		return nil, fmt.Errorf("DAC%d is already playing a %s", wf.DAC, strings.ToLower(run.Shape))
+	}
+	run := &WaveRun{Waveform: wf, Started: time.Now()}
+	stop := make(chan struct{})
+	app.u3.Waves[wf.DAC] = run
+	app.waveStop[wf.DAC] = stop
+	go app.hostWave(fmt.Sprintf("DAC%d %s, %s", wf.DAC, strings.ToLower(wf.Shape), from), run, stop)
+	return run, nil
+}
+
+//hostWave writes the updates of run until its time is up or stop is closed.
+//Must be called without app.mu held.
+func (app *application) hostWave(from string, run *WaveRun, stop chan struct{}) {
+	period := time.Duration(float64(time.Second) / run.Rate)
+	end := time.Duration(run.Seconds * float64(time.Second))
+	var total time.Duration
+	var err error
+	stopped := false
+	for n := 0; !stopped; {
+		at := time.Since(run.Started)
+		if end > 0 && at >= end {
+			break
+		}
+		app.lock(from)
+		start := time.Now()
+		err = app.setDAC(run.DAC, run.value(at))
+		took := time.Since(start)
+		if err == nil {
+			run.Updates++
+			total += took
+			run.RoundTrip = total / time.Duration(run.Updates)
+			if took > run.MaxRoundTrip {
+				run.MaxRoundTrip = took
+			}
+			run.report(time.Since(run.Started))
+		}
+		app.unlock()
+		if err != nil {
+			break
+		}
+		//the next update time not yet passed, the ones passed are missed
+		next := n + 1
+		if late := int(time.Since(run.Started) / period); late >= next {
+			app.lock(from)
+			run.Missed += late - next + 1
+			app.unlock()
+			next = late + 1
+		}
+		n = next
+		select {
+		case <-stop:
+			stopped = true
+		case <-time.After(time.Until(run.Started.Add(time.Duration(n) * period))):
+		}
+	}
+	app.lock(from)
+	defer app.unlock()
+	run.Stopped = stopped
+	if err != nil {
+		run.Error = err.Error()
+	}
+	run.Finished = time.Now()
+	run.report(run.Finished.Sub(run.Started))
+	if app.waveStop[run.DAC] == stop {
+		app.waveStop[run.DAC] = nil
+	}
+	app.infoLog.Printf("DAC%d %s played %v, %d updates at %.1f/s of %g/s asked, %d missed, %v a write %s",
+		run.DAC, run.Shape, run.Finished.Sub(run.Started).Round(time.Millisecond), run.Updates,
+		run.Achieved, run.Rate, run.Missed, run.RoundTrip, run.Warning)
+}
+
+//report works out the achieved rate after elapsed and the warning that goes
+//with it.
+func (run *WaveRun) report(elapsed time.Duration) {
+	if elapsed <= 0 || run.Updates < 2 {
+		return
+	}
+	run.Achieved = float64(run.Updates) / elapsed.Seconds()
+	run.CyclePoints = run.Achieved / run.Frequency
+	warnings := []string{}
+	if run.Achieved < rateShortfall*run.Rate {
+		limit := ""
+		if run.RoundTrip > 0 {
+			limit = fmt.Sprintf(", a write takes %v so the link allows about %.0f/s",
+				run.RoundTrip.Round(10*time.Microsecond), 1/run.RoundTrip.Seconds())
+		}
+		warnings = append(warnings, fmt.Sprintf("only %.1f of %g updates a second%s", run.Achieved, run.Rate, limit))
+	}
+	if run.CyclePoints < minCyclePts {
+		warnings = append(warnings, fmt.Sprintf("%.1f points a cycle is too few for the shape", run.CyclePoints))
+	}
+	run.Warning = strings.Join(warnings, "; ")
+}
+
+//stopWave stops the waveform playing on DAC dac.  Must be called with app.mu
+//held.
+func (app *application) stopWave(dac int) error {
+	if dac != 0 && dac != 1 {
+		return fmt.Errorf("there is no DAC%d", dac)
+	}
+	if app.waveStop[dac] == nil {
+		return fmt.Errorf("DAC%d is not playing", dac)
+	}
+	close(app.waveStop[dac])
+	app.waveStop[dac] = nil
+	return nil
+}
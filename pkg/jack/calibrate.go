@@ -0,0 +1,147 @@
+package jack
+
+import "fmt"
+
+/*
+Calibration file holds the U3 calibration constants and the conversion of raw
+analog reads to volts and of volts to DAC values.  The constants are stored in the device memory in five
+blocks of four 8 byte fixed point numbers each (see the ReadMem command in the
+low level function reference).  The layout below is for hardware version 1.30
+and later:
+
+block 0: LV single ended slope, offset, LV differential slope, offset
+block 1: DAC0 slope, offset, DAC1 slope, offset
+block 2: temperature slope, Vref at calibration, reserved, reserved
+block 3: HV AIN0 through AIN3 slopes
+block 4: HV AIN0 through AIN3 offsets
+
+Until the blocks are read from the device, the nominal values from the
+documentation are used.
+*/
+
+const (
+	CalBlocks  = 5  //number of calibration blocks read from device memory
+	SENeg      = 31 //negative channel for single ended reads (against GND)
+	VrefNeg    = 30 //negative channel for reads against Vref
+	SpecialNeg = 32 //negative channel for the LV special 0-3.6 volt range
+)
+
+type Calibration struct {
+	lvSESlope    float64
+	lvSEOffset   float64
+	lvDiffSlope  float64
+	lvDiffOffset float64
+	dac0Slope    float64
+	dac0Offset   float64
+	dac1Slope    float64
+	dac1Offset   float64
+	tempSlope    float64
+	vref         float64
+	hvSlope      [4]float64
+	hvOffset     [4]float64
+	loaded       bool //true once all blocks are read from the device
+}
+
+//nominal calibration values, per device documentation
+func NewCalibration() Calibration {
+	return Calibration{
+		lvSESlope:    0.000037231,
+		lvSEOffset:   0.0,
+		lvDiffSlope:  0.000074463,
+		lvDiffOffset: -2.44,
+		dac0Slope:    51.717,
+		dac0Offset:   0.0,
+		dac1Slope:    51.717,
+		dac1Offset:   0.0,
+		tempSlope:    0.013021,
+		vref:         2.44,
+		hvSlope:      [4]float64{0.000314, 0.000314, 0.000314, 0.000314},
+		hvOffset:     [4]float64{-10.3, -10.3, -10.3, -10.3},
+	}
+}
+
+//ParseBlock parses one ReadMem recBuffer (block number blk) into the
+//calibration.
+func (c *Calibration) ParseBlock(blk byte, recBuffer []byte) {
+	v := func(i int) float64 { return makeFixed(recBuffer, 8+8*i) }
+	switch blk {
+	case 0:
+		c.lvSESlope, c.lvSEOffset, c.lvDiffSlope, c.lvDiffOffset = v(0), v(1), v(2), v(3)
+	case 1:
+		c.dac0Slope, c.dac0Offset, c.dac1Slope, c.dac1Offset = v(0), v(1), v(2), v(3)
+	case 2:
+		c.tempSlope, c.vref = v(0), v(1)
+	case 3:
+		for i := range c.hvSlope {
+			c.hvSlope[i] = v(i)
+		}
+	case 4:
+		for i := range c.hvOffset {
+			c.hvOffset[i] = v(i)
+		}
+		c.loaded = true
+	}
+}
+
+//Takes a buffer and an offset and turns the 8 byte fixed point number into a
+//float.  The lower four bytes are the fraction and the upper four the signed
+//whole part.
+func makeFixed(buffer []byte, offset int) float64 {
+	frac := uint32(MakeInt(buffer, offset))
+	whole := int32(MakeInt(buffer, offset+4))
+	return float64(whole) + float64(frac)/4294967296.0
+}
+
+/*
+AINVoltage converts the raw read of channel pos against negative channel neg to
+volts.  The read is a float so filtered reads convert the same way.  FIO0
+through FIO3 on a U3-HV are the high voltage inputs and only read single ended.
+The rest read single ended (31), against Vref (30), against another analog
+channel (0-15), or in the special 0-3.6 volt range (32).
+*/
+func (c *Calibration) AINVoltage(pos, neg int, bits float64, hv bool) (float64, error) {
+	if hv && pos < 4 {
+		if neg != SENeg {
+			return 0, fmt.Errorf("AIN%d is a high voltage input and can only be read single ended", pos)
+		}
+		return c.hvSlope[pos]*bits + c.hvOffset[pos], nil
+	}
+	switch {
+	case neg == SENeg:
+		return c.lvSESlope*bits + c.lvSEOffset, nil
+	case neg == SpecialNeg:
+		return c.lvDiffSlope*bits + c.lvDiffOffset + c.vref, nil
+	case neg == VrefNeg || (neg >= 0 && neg < 16):
+		return c.lvDiffSlope*bits + c.lvDiffOffset, nil
+	}
+	return 0, fmt.Errorf("%d is not a valid negative channel", neg)
+}
+
+//Temperature converts the raw read of the internal temperature sensor
+//(positive channel 30) to kelvin.
+func (c *Calibration) Temperature(bits float64) float64 {
+	return c.tempSlope * bits
+}
+
+//Loaded is true once all the blocks have been read from the device.
+func (c *Calibration) Loaded() bool {
+	return c.loaded
+}
+
+//DACValue converts volts to the 16 bit value of DAC output dac (0 or 1).  The
+//calibration slope is for the 8 bit value, hence the 256.  The output range is
+//about 0.04 to 4.95 volts, volts outside of it are clamped.
+func (c *Calibration) DACValue(dac int, volts float64) uint16 {
+	slope, offset := c.dac0Slope, c.dac0Offset
+	if dac == 1 {
+		slope, offset = c.dac1Slope, c.dac1Offset
+	}
+	v := (volts*slope + offset) * 256
+	if v < 0 {
+		return 0
+	}
+	if v > 65535 {
+		return 65535
+	}
+	return uint16(v)
+}
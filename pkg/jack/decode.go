@@ -0,0 +1,528 @@
+package jack
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+The decoder breaks any U3 packet into its fields, for debugging: the extended
+commands (ConfigU3, ConfigIO, Feedback with any list of IOTypes, ReadMem,
+ReadCal, StreamConfig and the others by name), the normal commands (Reset,
+StreamStart, StreamStop) and the StreamData packets.  The checksums are checked
+and anything that does not add up is listed in Problems rather than stopping
+the decode.  See section 5 of the U3 user's guide for the packets.
+
+A Feedback response can only be split into its IOTypes knowing the command it
+answers, so DecodeRec takes the send buffer too.  Without it the data is shown
+as it is.
+*/
+
+//FrameField is Length bytes at Offset of a packet, Hex is the bytes.
+type FrameField struct {
+	Offset int
+	Length int
+	Hex    string
+	Name   string
+	Value  string
+}
+
+//Frame is a decoded packet.
+type Frame struct {
+	Command  string //Feedback, ConfigU3...
+	Response bool
+	Bytes    []byte
+	Fields   []FrameField
+	Problems []string
+}
+
+func (f *Frame) add(off, n int, name, format string, a ...interface{}) {
+	f.Fields = append(f.Fields, FrameField{Offset: off, Length: n, Name: name,
+		Hex: hex.EncodeToString(f.Bytes[off : off+n]), Value: fmt.Sprintf(format, a...)})
+}
+
+func (f *Frame) problem(format string, a ...interface{}) {
+	f.Problems = append(f.Problems, fmt.Sprintf(format, a...))
+}
+
+//Title is the command and which way it went.
+func (f Frame) Title() string {
+	if f.Response {
+		return f.Command + " response"
+	}
+	return f.Command + " command"
+}
+
+//String is one line per field and then the problems.
+func (f Frame) String() string {
+	s := fmt.Sprintf("%s, %d bytes\n", f.Title(), len(f.Bytes))
+	for _, fd := range f.Fields {
+		s += fmt.Sprintf("  %3d %-12s %-22s %s\n", fd.Offset, fd.Hex, fd.Name, fd.Value)
+	}
+	for _, p := range f.Problems {
+		s += "  problem: " + p + "\n"
+	}
+	return s
+}
+
+//names of the extended commands, byte 3 of the packet.
+var extendedNames = map[byte]string{
+	0x00: "Feedback",
+	0x08: "ConfigU3",
+	0x09: "Watchdog",
+	0x0A: "ConfigTimerClock",
+	0x0B: "ConfigIO",
+	0x0E: "SetDefaults",
+	0x11: "StreamConfig",
+	0x14: "AsynchConfig",
+	0x15: "AsynchTX",
+	0x16: "AsynchRX",
+	0x28: "WriteMem",
+	0x29: "EraseMem",
+	0x2A: "ReadMem",
+	0x2B: "WriteCal",
+	0x2C: "EraseCal",
+	0x2D: "ReadCal",
+	0x39: "SHT1X",
+	0x3A: "SPI",
+	0x3B: "I2C",
+	0xC0: "StreamData",
+}
+
+//names of the normal commands, byte 1 of the packet.
+var normalNames = map[byte]string{
+	0x99: "Reset",
+	0xA8: "StreamStart",
+	0xB0: "StreamStop",
+}
+
+//ioType is how one Feedback IOType is laid out: the bytes after the IOType
+//byte in the command and the bytes of its data in the response.
+type ioType struct {
+	name     string
+	send     int
+	rec      int
+	describe func(b []byte) string //the send bytes, nil to list them
+	result   func(b []byte) string //the response bytes, nil to list them
+}
+
+var ioTypes = map[byte]ioType{
+	1:  {"AIN", 2, 2, describeAIN, describeBits},
+	5:  {"WaitShort", 1, 0, func(b []byte) string { return fmt.Sprintf("Time=%d (x128us)", b[0]) }, nil},
+	6:  {"WaitLong", 1, 0, func(b []byte) string { return fmt.Sprintf("Time=%d (x32ms)", b[0]) }, nil},
+	9:  {"LED", 1, 0, func(b []byte) string { return fmt.Sprintf("State=%d", b[0]) }, nil},
+	10: {"BitStateRead", 1, 1, describeIONumber, func(b []byte) string { return fmt.Sprintf("State=%d", b[0]&1) }},
+	11: {"BitStateWrite", 1, 0, func(b []byte) string {
+		return fmt.Sprintf("%s State=%d", describeIONumber(b), b[0]>>7)
+	}, nil},
+	12: {"BitDirRead", 1, 1, describeIONumber, func(b []byte) string { return fmt.Sprintf("Direction=%d", b[0]&1) }},
+	13: {"BitDirWrite", 1, 0, func(b []byte) string {
+		return fmt.Sprintf("%s Direction=%d", describeIONumber(b), b[0]>>7)
+	}, nil},
+	26: {"PortStateRead", 0, 3, nil, describePorts("State")},
+	27: {"PortStateWrite", 6, 0, describePortWrite("State"), nil},
+	28: {"PortDirRead", 0, 3, nil, describePorts("Direction")},
+	29: {"PortDirWrite", 6, 0, describePortWrite("Direction"), nil},
+	34: {"DAC0 (8 bit)", 1, 0, func(b []byte) string { return fmt.Sprintf("Value=%d", b[0]) }, nil},
+	35: {"DAC1 (8 bit)", 1, 0, func(b []byte) string { return fmt.Sprintf("Value=%d", b[0]) }, nil},
+	38: {"DAC0 (16 bit)", 2, 0, func(b []byte) string { return fmt.Sprintf("Value=%d", MakeShort(b, 0)) }, nil},
+	39: {"DAC1 (16 bit)", 2, 0, func(b []byte) string { return fmt.Sprintf("Value=%d", MakeShort(b, 0)) }, nil},
+	42: {"Timer0", 3, 4, describeTimer, describeCount},
+	43: {"Timer0Config", 3, 0, describeTimerConfig, nil},
+	44: {"Timer1", 3, 4, describeTimer, describeCount},
+	45: {"Timer1Config", 3, 0, describeTimerConfig, nil},
+	54: {"Counter0", 1, 4, func(b []byte) string { return fmt.Sprintf("Reset=%d", b[0]&1) }, describeCount},
+	55: {"Counter1", 1, 4, func(b []byte) string { return fmt.Sprintf("Reset=%d", b[0]&1) }, describeCount},
+}
+
+func describeAIN(b []byte) string {
+	f := Fields{}
+	ainFields(f, b[0], b[1])
+	return f.String()
+}
+
+func describeBits(b []byte) string {
+	return fmt.Sprintf("Bits=%d", MakeShort(b, 0))
+}
+
+func describeIONumber(b []byte) string {
+	ch := int(b[0] & 0x1F)
+	if ch > 19 {
+		return fmt.Sprintf("IONumber=%d (no such pin)", ch)
+	}
+	return fmt.Sprintf("IONumber=%d (%s)", ch, ChannelName(ch))
+}
+
+func describePorts(name string) func([]byte) string {
+	return func(b []byte) string {
+		return fmt.Sprintf("%s FIO=%08b EIO=%08b CIO=%04b", name, b[0], b[1], b[2]&0x0F)
+	}
+}
+
+func describePortWrite(name string) func([]byte) string {
+	return func(b []byte) string {
+		return fmt.Sprintf("WriteMask FIO=%08b EIO=%08b CIO=%04b %s FIO=%08b EIO=%08b CIO=%04b",
+			b[0], b[1], b[2]&0x0F, name, b[3], b[4], b[5]&0x0F)
+	}
+}
+
+func describeTimer(b []byte) string {
+	return fmt.Sprintf("UpdateReset=%d Value=%d", b[0], MakeShort(b, 1))
+}
+
+func describeTimerConfig(b []byte) string {
+	return fmt.Sprintf("TimerMode=%d Value=%d", b[0], MakeShort(b, 1))
+}
+
+func describeCount(b []byte) string {
+	return fmt.Sprintf("Value=%d", MakeInt(b, 0))
+}
+
+//checksum8 is the checksum8 of the protocol over b.
+func checksum8(b []byte) byte {
+	sum := 0
+	for _, c := range b {
+		sum += int(c)
+	}
+	for sum > 255 {
+		sum = sum/256 + sum%256
+	}
+	return byte(sum)
+}
+
+//DecodeSend decodes a packet sent to the U3.
+func DecodeSend(b []byte) Frame {
+	return decode(b, false, nil)
+}
+
+//DecodeRec decodes a packet from the U3, send is the packet it answers or nil.
+func DecodeRec(b, send []byte) Frame {
+	return decode(b, true, send)
+}
+
+func decode(b []byte, response bool, send []byte) Frame {
+	f := Frame{Command: "Unknown", Response: response, Bytes: b}
+	if len(b) < 2 {
+		f.problem("a packet has at least 2 bytes")
+		return f
+	}
+	if b[1]&0x78 != 0x78 { //command number bits not 1111, a normal command
+		decodeNormal(&f)
+		return f
+	}
+	if len(b) < 6 {
+		f.problem("an extended packet has at least 6 bytes")
+		return f
+	}
+	name, ok := extendedNames[b[3]]
+	if ok {
+		f.Command = name
+	}
+	if cs := checksum8(b[1:6]); cs == b[0] {
+		f.add(0, 1, "Checksum8", "0x%02x ok", b[0])
+	} else {
+		f.add(0, 1, "Checksum8", "0x%02x", b[0])
+		f.problem("checksum8 is 0x%02x, it should be 0x%02x", b[0], cs)
+	}
+	if b[0] == 0xB8 && b[1] == 0xB8 {
+		f.problem("0xB8 0xB8 is the U3 saying the checksum of the command was bad")
+	}
+	f.add(1, 1, "Command", "0x%02x extended", b[1])
+	f.add(2, 1, "Words", "%d", b[2])
+	f.add(3, 1, "Extended command", "0x%02x %s", b[3], f.Command)
+	cs16 := 0
+	for _, c := range b[6:] {
+		cs16 += int(c)
+	}
+	got := MakeShort(b, 4)
+	if got == cs16&0xFFFF {
+		f.add(4, 2, "Checksum16", "0x%04x ok", got)
+	} else {
+		f.add(4, 2, "Checksum16", "0x%04x", got)
+		f.problem("checksum16 is 0x%04x, it should be 0x%04x", got, cs16&0xFFFF)
+	}
+	if want := 6 + 2*int(b[2]); b[3] != 0xC0 && len(b) != want && len(b) != want+1 {
+		f.problem("%d words means %d bytes, there are %d", b[2], want, len(b))
+	}
+	data := b[6:]
+	if response && b[3] != 0xC0 {
+		if len(data) > 0 {
+			f.add(6, 1, "Errorcode", "%d", data[0])
+			if data[0] != 0 {
+				f.problem("the U3 returned errorcode %d", data[0])
+			}
+		}
+	}
+	switch {
+	case b[3] == 0x00 && !response:
+		decodeFeedback(&f, 6, data)
+	case b[3] == 0x00:
+		decodeFeedbackRec(&f, data, send)
+	case b[3] == 0x08 && !response:
+		layout(&f, 6, data, configU3Send)
+	case b[3] == 0x08:
+		decodeConfigU3Rec(&f, data)
+	case b[3] == 0x0B && !response:
+		layout(&f, 6, data, configIOSend)
+	case b[3] == 0x0B:
+		layout(&f, 6, data, configIORec)
+	case (b[3] == 0x2A || b[3] == 0x2D) && !response:
+		layout(&f, 6, data, []string{"Reserved", "BlockNum"})
+	case b[3] == 0x2A || b[3] == 0x2D:
+		layout(&f, 6, data, []string{"Errorcode", "Reserved"})
+		if len(data) > 2 {
+			f.add(8, len(data)-2, "Data", "%d bytes", len(data)-2)
+		}
+	case b[3] == 0x11 && !response:
+		decodeStreamConfig(&f, data)
+	case b[3] == 0x11:
+		layout(&f, 6, data, []string{"Errorcode", "Reserved"})
+	case b[3] == 0xC0:
+		decodeStreamData(&f, data)
+	default:
+		if len(data) > 0 && !response {
+			f.add(6, len(data), "Data", "%d bytes", len(data))
+		} else if len(data) > 1 {
+			f.add(7, len(data)-1, "Data", "%d bytes", len(data)-1)
+		}
+	}
+	return f
+}
+
+//layout adds one byte field per name from offset off, the Errorcode already
+//added for a response is skipped.
+func layout(f *Frame, off int, data []byte, names []string) {
+	for i, name := range names {
+		if i >= len(data) {
+			f.problem("the packet ends before %s", name)
+			return
+		}
+		if name == "Errorcode" {
+			continue
+		}
+		f.add(off+i, 1, name, "%d (0x%02x, %08b)", data[i], data[i], data[i])
+	}
+}
+
+var configU3Send = []string{"WriteMask", "Reserved", "LocalID", "TimerCounterConfig",
+	"FIOAnalog", "FIODirection", "FIOState", "EIOAnalog", "EIODirection", "EIOState",
+	"CIODirection", "CIOState", "DAC1Enable", "DAC0", "DAC1", "TimerClockConfig",
+	"TimerClockDivisor", "CompatibilityOptions", "Reserved", "Reserved"}
+
+var configIOSend = []string{"WriteMask", "Reserved", "TimerCounterConfig",
+	"DAC1Enable", "FIOAnalog", "EIOAnalog"}
+
+var configIORec = []string{"Errorcode", "Reserved", "TimerCounterConfig",
+	"DAC1Enable", "FIOAnalog", "EIOAnalog"}
+
+func decodeConfigU3Rec(f *Frame, data []byte) {
+	if len(data) < 32 {
+		f.problem("a ConfigU3 response has 38 bytes")
+		return
+	}
+	c := ParseConfig(f.Bytes)
+	f.add(7, 2, "Reserved", "")
+	f.add(9, 2, "FirmwareVersion", "%s", c.FirmwareVersion)
+	f.add(11, 2, "BootloaderVersion", "%s", c.BootLoaderVersion)
+	f.add(13, 2, "HardwareVersion", "%s", c.HardwareVersion)
+	f.add(15, 4, "SerialNumber", "%s", c.SerialNumber)
+	f.add(19, 2, "ProductID", "%s", c.ProductID)
+	layout(f, 21, data[15:], []string{"LocalID", "TimerCounterMask", "FIOAnalog",
+		"FIODirection", "FIOState", "EIOAnalog", "EIODirection", "EIOState",
+		"CIODirection", "CIOState", "DAC1Enable", "DAC0", "DAC1", "TimerClockConfig",
+		"TimerClockDivisor", "CompatibilityOptions"})
+	f.add(37, 1, "VersionInfo", "%d %s", data[31], c.DeviceName)
+}
+
+//decodeFeedback splits the IOTypes of a Feedback command from byte off on.
+func decodeFeedback(f *Frame, off int, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	f.add(off, 1, "Echo", "%d", data[0])
+	_, _ = feedbackTypes(f, off+1, data[1:], true)
+}
+
+//feedbackTypes adds the IOTypes in data (starting at byte off of the packet)
+//when add is set, and returns them and the response bytes they expect.
+func feedbackTypes(f *Frame, off int, data []byte, add bool) ([]byte, int) {
+	types := []byte{}
+	rec := 0
+	for i := 0; i < len(data); {
+		t, ok := ioTypes[data[i]]
+		if !ok {
+			//a zero past the last IOType is the pad to an even length
+			if data[i] == 0 && i == len(data)-1 {
+				if add {
+					f.add(off+i, 1, "Pad", "")
+				}
+				break
+			}
+			if add {
+				f.problem("IOType %d at byte %d is not known, the rest is not decoded", data[i], off+i)
+			}
+			return types, -1
+		}
+		if i+1+t.send > len(data) {
+			if add {
+				f.problem("IOType %s at byte %d needs %d more bytes", t.name, off+i, t.send)
+			}
+			return types, -1
+		}
+		if add {
+			args := data[i+1 : i+1+t.send]
+			value := ""
+			if t.describe != nil {
+				value = t.describe(args)
+			}
+			f.add(off+i, 1+t.send, fmt.Sprintf("IOType %d %s", data[i], t.name), "%s", value)
+		}
+		types = append(types, data[i])
+		rec += t.rec
+		i += 1 + t.send
+	}
+	return types, rec
+}
+
+//decodeFeedbackRec splits the data of a Feedback response by the IOTypes of
+//send.  data[0] is the Errorcode, already added.
+func decodeFeedbackRec(f *Frame, data, send []byte) {
+	if len(data) < 3 {
+		f.problem("a Feedback response has at least 9 bytes")
+		return
+	}
+	f.add(7, 1, "ErrorFrame", "%d", data[1])
+	f.add(8, 1, "Reserved", "")
+	data = data[3:]
+	if len(send) < 8 {
+		if len(data) > 0 {
+			f.add(9, len(data), "Data", "give the command to split it by IOType")
+		}
+		return
+	}
+	types, want := feedbackTypes(f, 7, send[7:], false)
+	if want < 0 {
+		f.problem("the IOTypes of the command could not be read")
+		return
+	}
+	off := 0
+	for _, typ := range types {
+		t := ioTypes[typ]
+		if t.rec == 0 {
+			continue
+		}
+		if off+t.rec > len(data) {
+			f.problem("the response ends before the data of %s", t.name)
+			return
+		}
+		value := fmt.Sprintf("% x", data[off:off+t.rec])
+		if t.result != nil {
+			value = t.result(data[off : off+t.rec])
+		}
+		f.add(9+off, t.rec, t.name, "%s", value)
+		off += t.rec
+	}
+	if extra := len(data) - off; extra == 1 {
+		f.add(9+off, 1, "Pad", "")
+	} else if extra > 1 {
+		f.problem("%d bytes more than the IOTypes of the command return", extra)
+	}
+}
+
+func decodeStreamConfig(f *Frame, data []byte) {
+	layout(f, 6, data, []string{"NumChannels", "SamplesPerPacket", "Reserved", "ScanConfig"})
+	if len(data) < 6 {
+		return
+	}
+	f.add(10, 2, "ScanInterval", "%d", MakeShort(data, 4))
+	for i := 0; 6+2*i+1 < len(data) && i < int(data[0]); i++ {
+		f.add(12+2*i, 2, fmt.Sprintf("Channel %d", i), "PChannel=%d NChannel=%d",
+			data[6+2*i], data[7+2*i])
+	}
+}
+
+func decodeStreamData(f *Frame, data []byte) {
+	if len(data) < 6 {
+		f.problem("a StreamData packet has at least 12 bytes")
+		return
+	}
+	f.add(6, 4, "TimeStamp", "%d", MakeInt(data, 0))
+	f.add(10, 1, "PacketNumber", "%d", data[4])
+	f.add(11, 1, "Errorcode", "%d", data[5])
+	if data[5] != 0 {
+		f.problem("the stream returned errorcode %d", data[5])
+	}
+	n := int(f.Bytes[2]) - 4
+	if n < 0 {
+		f.problem("%d words is too few for a StreamData packet", f.Bytes[2])
+		n = 0
+	}
+	for i := 0; i < n; i++ {
+		if 6+2*i+1 >= len(data) {
+			f.problem("the packet ends before sample %d", i)
+			return
+		}
+		f.add(12+2*i, 2, fmt.Sprintf("Sample %d", i), "%d", MakeShort(data, 6+2*i))
+	}
+	if rest := data[6+2*n:]; len(rest) > 0 {
+		f.add(12+2*n, 1, "Backlog", "%d", rest[0])
+	}
+}
+
+//decodeNormal decodes the normal commands, whose checksum8 covers the whole
+//packet and whose byte 1 is the command.
+func decodeNormal(f *Frame) {
+	b := f.Bytes
+	if name, ok := normalNames[b[1]]; ok {
+		f.Command = name
+	}
+	if cs := checksum8(b[1:]); cs == b[0] {
+		f.add(0, 1, "Checksum8", "0x%02x ok", b[0])
+	} else {
+		f.add(0, 1, "Checksum8", "0x%02x", b[0])
+		f.problem("checksum8 is 0x%02x, it should be 0x%02x", b[0], cs)
+	}
+	f.add(1, 1, "Command", "0x%02x normal, number %d, %d words", b[1], (b[1]>>3)&0x0F, b[1]&0x07)
+	switch {
+	case f.Command == "Reset" && !f.Response && len(b) > 2:
+		f.add(2, 1, "ResetOptions", "%d", b[2])
+	case f.Command == "Reset" && len(b) > 3:
+		f.add(3, 1, "Errorcode", "%d", b[3])
+	case f.Response && len(b) > 2:
+		f.add(2, 1, "Errorcode", "%d", b[2])
+	}
+}
+
+/*
+ParseBytes reads a packet typed or pasted in: numbers separated by spaces or
+commas, decimal like the Send Buffer and Rec Buffer lines of the web program
+(the brackets can stay) unless they start with 0x, or one run of hex digits
+like the buffers of a trace.
+*/
+func ParseBytes(s string) ([]byte, error) {
+	s = strings.Trim(strings.TrimSpace(s), "[]")
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t' || r == '\n' || r == '\r'
+	})
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no bytes given")
+	}
+	if len(fields) == 1 && len(fields[0]) > 3 && !strings.HasPrefix(fields[0], "0x") {
+		b, err := hex.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%q is not hex: %v", fields[0], err)
+		}
+		return b, nil
+	}
+	b := make([]byte, len(fields))
+	for i, field := range fields {
+		n, err := strconv.ParseUint(field, 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a byte", field)
+		}
+		b[i] = byte(n)
+	}
+	return b, nil
+}
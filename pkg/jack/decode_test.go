@@ -0,0 +1,143 @@
+package jack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+//packets as the U3 sends and gets them, the checksums worked out by hand.
+const (
+	configU3Packet = "0bf80a0800000000000000000000000000000000000000000000"
+	//U3-HV serial 320012345, firmware 1.46, FIO0-3 analog
+	configU3Response = "76f8100862030000002e011b001e0139001313030001400ff03000ff000f0500000002000012"
+	feedbackPacket   = "36f803003a00001a01001f00" //PortStateRead, AIN FIO0 single ended, pad
+	feedbackResponse = "56f8040058010000000f80033492"
+	streamPacket     = "5df906c0990340e2010007000080f0ff0000" //two samples
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name     string
+		packet   string
+		response bool
+		send     string //the command a response answers
+		command  string
+		fields   map[string]string //name to value, the ones checked
+		problems []string
+	}{
+		{"ConfigU3 command", configU3Packet, false, "", "ConfigU3",
+			map[string]string{"Checksum8": "0x0b ok", "Checksum16": "0x0000 ok", "WriteMask": "0 (0x00, 00000000)"}, nil},
+		{"ConfigU3 response", configU3Response, true, "", "ConfigU3", map[string]string{
+			"Checksum8":       "0x76 ok",
+			"Errorcode":       "0",
+			"FirmwareVersion": "1.46",
+			"HardwareVersion": "1.30",
+			"SerialNumber":    "320012345",
+			"ProductID":       "3",
+			"FIOAnalog":       "15 (0x0f, 00001111)",
+			"VersionInfo":     "18 U3-HV",
+		}, nil},
+		{"Feedback command", feedbackPacket, false, "", "Feedback", map[string]string{
+			"Echo":                    "0",
+			"IOType 26 PortStateRead": "",
+			"IOType 1 AIN":            "LongSettling=false NegativeChannel=31 PositiveChannel=0 QuickSample=false",
+			"Pad":                     "",
+		}, nil},
+		{"Feedback response", feedbackResponse, true, feedbackPacket, "Feedback", map[string]string{
+			"Checksum16":    "0x0158 ok",
+			"ErrorFrame":    "0",
+			"PortStateRead": "State FIO=00001111 EIO=10000000 CIO=0011",
+			"AIN":           "Bits=37428",
+		}, nil},
+		{"Feedback response without the command", feedbackResponse, true, "", "Feedback",
+			map[string]string{"Data": "give the command to split it by IOType"}, nil},
+		{"Feedback response to another command", feedbackResponse, true, configU3Packet, "Feedback", nil,
+			[]string{"the IOTypes of the command could not be read"}},
+		{"StreamData", streamPacket, true, "", "StreamData", map[string]string{
+			"TimeStamp":    "123456",
+			"PacketNumber": "7",
+			"Sample 0":     "32768",
+			"Sample 1":     "65520",
+			"Backlog":      "0",
+		}, nil},
+		{"Reset", "999900", false, "", "Reset", map[string]string{"Checksum8": "0x99 ok", "ResetOptions": "0"}, nil},
+
+		//what is wrong is a problem and the decode goes on
+		{"bad checksum8", "00" + configU3Packet[2:], false, "", "ConfigU3", map[string]string{"Checksum8": "0x00"},
+			[]string{"checksum8 is 0x00, it should be 0x0b"}},
+		{"bad checksum16", feedbackResponse[:24] + "35" + feedbackResponse[26:], true, feedbackPacket, "Feedback",
+			map[string]string{"Checksum16": "0x0158", "AIN": "Bits=37429"},
+			[]string{"checksum16 is 0x0158, it should be 0x0159"}},
+		{"bad StreamData checksum16", streamPacket[:32] + "05" + streamPacket[34:], true, "", "StreamData",
+			map[string]string{"Backlog": "5"}, []string{"checksum16 is 0x0399, it should be 0x039e"}},
+		{"bad Reset checksum8", "009900", false, "", "Reset", nil, []string{"checksum8 is 0x00, it should be 0x99"}},
+		{"short ConfigU3", configU3Packet[:48], false, "", "ConfigU3", nil, []string{"10 words means 26 bytes, there are 24",
+			"the packet ends before Reserved"}},
+		{"short Feedback response", feedbackResponse[:24], true, feedbackPacket, "Feedback", nil,
+			[]string{"checksum16 is 0x0158, it should be 0x0092", "4 words means 14 bytes, there are 12",
+				"the response ends before the data of AIN"}},
+		{"one byte", "f8", false, "", "Unknown", nil, []string{"a packet has at least 2 bytes"}},
+		{"short extended", "0bf80a08", false, "", "Unknown", nil, []string{"an extended packet has at least 6 bytes"}},
+	}
+	for _, tt := range tests {
+		b, _ := hex.DecodeString(tt.packet)
+		send, _ := hex.DecodeString(tt.send)
+		var f Frame
+		if tt.response {
+			f = DecodeRec(b, send)
+		} else {
+			f = DecodeSend(b)
+		}
+		if f.Command != tt.command || f.Response != tt.response {
+			t.Errorf("%s: %s", tt.name, f.Title())
+		}
+		got := map[string]string{}
+		for _, fd := range f.Fields {
+			if fd.Offset+fd.Length > len(b) || fd.Hex != hex.EncodeToString(b[fd.Offset:fd.Offset+fd.Length]) {
+				t.Errorf("%s: field %s at %d is %s", tt.name, fd.Name, fd.Offset, fd.Hex)
+			}
+			got[fd.Name] = fd.Value
+		}
+		for name, want := range tt.fields {
+			if v, ok := got[name]; !ok || v != want {
+				t.Errorf("%s: %s is %q, want %q", tt.name, name, v, want)
+			}
+		}
+		if !reflect.DeepEqual(f.Problems, tt.problems) {
+			t.Errorf("%s: problems %q, want %q\n%s", tt.name, f.Problems, tt.problems, f)
+		}
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []byte
+	}{
+		{"[11 248 10 8 0 0]", []byte{11, 248, 10, 8, 0, 0}},
+		{"11, 248,10,\t8", []byte{11, 248, 10, 8}},
+		{"0x0b 0xf8 0x0a", []byte{0x0b, 0xf8, 0x0a}},
+		{"0bf80a08", []byte{0x0b, 0xf8, 0x0a, 0x08}},
+		{" 255 ", []byte{255}},
+		{"", nil},
+		{"[]", nil},
+		{"256", nil},
+		{"11 x8", nil},
+		{"0bf80a0", nil},
+		{"zzzz", nil},
+	}
+	for _, tt := range tests {
+		got, err := ParseBytes(tt.in)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("ParseBytes(%q) = % x, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("ParseBytes(%q) = % x, %v, want % x", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package jack
+
+// #cgo CFLAGS: -g -Wall
+// #cgo amd64 386 CFLAGS: -DX86=1
+// #cgo LDFLAGS: -llabjackusb
+// #include <stdlib.h>
+//#include <stdio.h>
+//#include <errno.h>
+//#include <../labjackusb/labjackusb.h>
+//#include <../libusb/libusb.h>
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+//All C dependencies are confined to this file.
+
+//USB is the Transport to the U3s on the USB bus, through the LabJack driver.
+//The device is opened and closed on every exchange so several programs can
+//share it.
+type USB struct{}
+
+//DevCount returns the number of U3 devices connected to the USB bus.
+func (USB) DevCount() int {
+	return int(C.LJUSB_GetDevCount(C.U3_PRODUCT_ID))
+}
+
+//Exchange writes sendBuffer to device devNum (starting at 1) and reads
+//recBuffer back.
+func (USB) Exchange(devNum int, sendBuffer, recBuffer []byte) error {
+	//see labjackusb.h for documentation.
+	devHandle := C.LJUSB_OpenDevice(C.UINT(devNum), 0, C.U3_PRODUCT_ID)
+	if devHandle == nil {
+		return fmt.Errorf("Couldn't open U3 %d. Please connect one and try again", devNum)
+	}
+	defer C.LJUSB_CloseDevice(devHandle)
+
+	// Write the command to the device.
+	// LJUSB_Write( handle, sendBuffer, length of sendBuffer )
+
+	//pointer to the first byte of the sendBuffer, the way that C likes it.
+	sBuff := (*C.uchar)(unsafe.Pointer(&sendBuffer[0]))
+	//cast go int to C unsighed long
+	sBuffLength := C.ulong(len(sendBuffer))
+	//write to the device.
+	r := C.LJUSB_Write(devHandle, sBuff, sBuffLength)
+	if r != sBuffLength {
+		return fmt.Errorf("An error occurred when trying to write the buffer")
+	}
+	// Read the result from the device.
+	// LJUSB_Read( handle, recBuffer, number of bytes to read)
+	rBuff := (*C.uchar)(unsafe.Pointer(&recBuffer[0]))
+	rBuffLength := C.ulong(len(recBuffer))
+	r = C.LJUSB_Read(devHandle, rBuff, rBuffLength)
+	if r != rBuffLength {
+		return fmt.Errorf("An error occurred when trying to read from the U3 r: %v, rBuffLength: %v", r, rBuffLength)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package jack
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//Fields is a decoded send or recieve buffer, field name to value.  The names
+//are the ones in the low level function reference where there is one.
+type Fields map[string]interface{}
+
+//String is the fields sorted by name, name=value separated by spaces.
+func (f Fields) String() string {
+	names := []string{}
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	s := []string{}
+	for _, name := range names {
+		s = append(s, fmt.Sprintf("%s=%v", name, f[name]))
+	}
+	return strings.Join(s, " ")
+}
+
+//ainFields decodes the positive and negative channel bytes of an AIN IOType.
+func ainFields(f Fields, pos, neg byte) {
+	f["PositiveChannel"] = int(pos & 0x1F)
+	f["LongSettling"] = pos&0x40 != 0
+	f["QuickSample"] = pos&0x80 != 0
+	f["NegativeChannel"] = int(neg)
+}
+
+//DecodeRequest returns the fields of the send buffer of command op, the ones
+//that change from one send to the next.  Short buffers give no fields.
+func DecodeRequest(op string, send []byte) Fields {
+	f := Fields{}
+	if len(send) < 8 {
+		return f
+	}
+	switch op {
+	case ConfigJack:
+		f["WriteMask"] = int(send[6])
+	case ConfigIO:
+		if len(send) >= 12 {
+			f["WriteMask"] = int(send[6])
+			f["FIOAnalog"] = int(send[10])
+			f["EIOAnalog"] = int(send[11])
+		}
+	case ReadMem:
+		f["BlockNum"] = int(send[7])
+	case AIN, TempSense, VReg:
+		if len(send) >= 10 {
+			ainFields(f, send[8], send[9])
+		}
+	case AINBatch:
+		count := (2*int(send[2]) - 1) / 3
+		if len(send) >= 10 && len(send) >= 7+3*count {
+			ainFields(f, send[8], send[9])
+			f["Count"] = count
+		}
+	case LED:
+		if len(send) >= 9 {
+			f["State"] = int(send[8])
+		}
+	case PortStateWrite:
+		if len(send) >= 14 {
+			f["WriteMask"] = []int{int(send[8]), int(send[9]), int(send[10])}
+			f["State"] = []int{int(send[11]), int(send[12]), int(send[13])}
+		}
+	case PortDirWrite:
+		if len(send) >= 14 {
+			f["WriteMask"] = []int{int(send[8]), int(send[9]), int(send[10])}
+			f["Direction"] = []int{int(send[11]), int(send[12]), int(send[13])}
+		}
+	case BitStateRead, BitDirRead, BitStateWrite, BitDirWrite:
+		if len(send) >= 9 {
+			f["IONumber"] = int(send[8] & 0x1F)
+			if op == BitStateWrite {
+				f["State"] = int(send[8] >> 7)
+			}
+			if op == BitDirWrite {
+				f["Direction"] = int(send[8] >> 7)
+			}
+		}
+	case DAC:
+		if len(send) >= 10 {
+			f["DAC"] = int(send[7]) - 38
+			f["Value"] = MakeShort(send, 8)
+		}
+	case Feedback:
+		//the IOTypes as DecodeSend shows them, up to the pad or the first
+		//one that can not be read
+		ios := []string{}
+		for i := 7; i < len(send); {
+			t, ok := ioTypes[send[i]]
+			if !ok || i+1+t.send > len(send) {
+				break
+			}
+			s := t.name
+			if t.describe != nil {
+				s += " " + t.describe(send[i+1:i+1+t.send])
+			}
+			ios = append(ios, s)
+			i += 1 + t.send
+		}
+		f["IOTypes"] = ios
+	}
+	return f
+}
+
+//DecodeResponse returns the fields of the recieve buffer of command op.
+//Short buffers give only what fits.
+func DecodeResponse(op string, rec []byte) Fields {
+	f := Fields{}
+	if len(rec) < 7 {
+		return f
+	}
+	f["Errorcode"] = int(rec[6])
+	switch op {
+	case ConfigJack:
+		if len(rec) >= 38 {
+			c := ParseConfig(rec)
+			f["FirmwareVersion"] = c.FirmwareVersion
+			f["SerialNumber"] = c.SerialNumber
+			f["LocalID"] = c.LocalID
+			f["DeviceName"] = c.DeviceName
+		}
+	case ConfigIO:
+		if len(rec) >= 12 {
+			f["FIOAnalog"] = int(rec[10])
+			f["EIOAnalog"] = int(rec[11])
+		}
+	case AIN, TempSense, VReg:
+		if len(rec) >= 11 {
+			f["Bits"] = int(AINBits(rec, 0))
+		}
+	case AINBatch:
+		bits := []int{}
+		for k := 0; 10+2*k < len(rec)-1; k++ {
+			bits = append(bits, int(AINBits(rec, k)))
+		}
+		f["Bits"] = bits
+	case PortStateRead:
+		if len(rec) >= 12 {
+			b := PortBits(rec)
+			f["State"] = []int{int(b[0]), int(b[1]), int(b[2])}
+		}
+	case PortDirRead:
+		if len(rec) >= 12 {
+			b := PortBits(rec)
+			f["Direction"] = []int{int(b[0]), int(b[1]), int(b[2])}
+		}
+	case BitStateRead:
+		if len(rec) >= 10 {
+			f["State"] = BitValue(rec)
+		}
+	case BitDirRead:
+		if len(rec) >= 10 {
+			f["Direction"] = BitValue(rec)
+		}
+	}
+	return f
+}
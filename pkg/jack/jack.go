@@ -0,0 +1,706 @@
+/*
+Package jack is the U3 low level protocol shared by the web program and the
+u3ctl command line tool: the model of each command, building the send buffers,
+checking the recieve buffers, the calibration constants and the transports:
+USB (the only place that needs cgo, see device.go) and the trace recorder and
+replay (see trace.go).  Commands are sent with SendRec and the recieve buffer it
+returns is parsed by the caller, since what is done with it differs between
+the programs.  See the low level function reference
+in the U3 user's guide for the meaning of the bytes.
+*/
+package jack
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+//names of the commands, the keys of Commands.
+const (
+	ConfigJack     = "Config U3"
+	ConfigIO       = "Config IO"
+	AIN            = "AIN"
+	AINBatch       = "AIN Batch"
+	LED            = "LED"
+	PortStateRead  = "Port State Read"
+	PortStateWrite = "Port State Write"
+	PortDirRead    = "Port Direction Read"
+	PortDirWrite   = "Port Direction Write"
+	BitStateRead   = "Bit State Read"
+	BitStateWrite  = "Bit State Write"
+	BitDirRead     = "Bit Direction Read"
+	BitDirWrite    = "Bit Direction Write"
+	Feedback       = "Feedback"
+	DAC            = "DAC"
+	TempSense      = "Temperature Sense"
+	VReg           = "VReg"
+	ReadMem        = "Read Mem"
+)
+
+/*
+Command type is the model for each individual U3 command.  The send and recieved
+lengths for each command are different.  Also, the meaning of each byte is different
+for each commmand and commmand type.  For this reason, they are documented inline
+in the NewCommands function for each command.  The names of the commands are
+the same as the names of the commands in the documentation.
+*/
+type Command struct {
+	SendLength  int
+	RecLength   int
+	Byte1       byte
+	Byte2       byte
+	Byte3       byte
+	Byte6       byte
+	Byte7       byte
+	Byte8       byte
+	Byte9       byte
+	Byte10      byte
+	Byte11      byte
+	Byte12      byte
+	Byte13      byte
+	Count       int    //number of IOTypes in a batched feedback command
+	Data        []byte //IOTypes of the Feedback command, see SetFeedback
+	checkReturn func(*Command, []byte) error
+	buildBytes  func(*Command, []byte, byte)
+}
+
+// Commands type is the collection of all the commands available for the U3 device
+type Commands map[string]*Command
+
+//NewCommands returns the model of every command.  Callers set the bytes that
+//change from one send to the next (the channel of an AIN read for example)
+//before each send.
+func NewCommands() Commands {
+	return Commands{
+		ConfigJack: &Command{ //ConfigU3 changed to ConfigJack (older naming conflict)
+			SendLength:  26,                  //to make the sendBuffer in the SendRec function.
+			RecLength:   38,                  //to make the recBuffer in the SendRec function
+			Byte1:       0xF8,                //per device low level function reference
+			Byte2:       0x0A,                //per device low level function refrence
+			Byte3:       0x08,                //per device low level function refrence
+			Byte6:       0x00,                //per device low level function refrence
+			Byte7:       0x00,                //per device low level function refrence
+			checkReturn: checkJack,           //call to check the validity of the returned bytes
+			buildBytes:  buildJackSendBuffer, //call to form the send bytes.
+		},
+		//Analog or digital nature of pins is set with this command, it does not impact flash
+		ConfigIO: &Command{ //all fields the same as configJack fields.
+			SendLength:  12,
+			RecLength:   12,
+			Byte1:       0xF8,
+			Byte2:       0x03,
+			Byte3:       0x0B,
+			Byte6:       0x00,
+			Byte7:       0x00,
+			checkReturn: checkIO,
+			buildBytes:  buildJackSendBuffer,
+		},
+		//reads one block of device memory, used for the calibration constants
+		ReadMem: &Command{
+			SendLength:  8,
+			RecLength:   40,
+			Byte1:       0xF8,
+			Byte2:       0x01,
+			Byte3:       0x2D,
+			Byte6:       0x00,
+			Byte7:       0x00, //block number, 0 through 4 hold calibration
+			checkReturn: checkReadMem,
+			buildBytes:  buildReadMemBuffer,
+		},
+		//the following are all subcommands of the "feedback" command.
+		AIN: &Command{ //read analog pin voltage
+			SendLength:  10,
+			RecLength:   12,
+			Byte1:       0xF8, //per device low level function refrence
+			Byte2:       2,    //number of words (two byte pairs) startying with byte 6
+			Byte3:       0x00,
+			Byte6:       0,
+			Byte7:       1,     //feedback subcommand
+			Byte9:       SENeg, //negative channel
+			checkReturn: checkFeedback,
+			buildBytes:  buildAINReadBuffer,
+		},
+		AINBatch: &Command{ //same as ain, repeated count times in one packet
+			SendLength:  10, //lengths and Byte2 are set by SetAINBatch
+			RecLength:   12,
+			Byte1:       0xF8,
+			Byte2:       2,
+			Byte3:       0x00,
+			Byte6:       0,
+			Byte7:       1, //feedback subcommand
+			Byte9:       SENeg,
+			Count:       1,
+			checkReturn: checkFeedback,
+			buildBytes:  buildAINBatchBuffer,
+		},
+		LED: &Command{ //set led state (on or off)
+			SendLength:  10, //9 bytes padded to an even length
+			RecLength:   10, //9 bytes padded to an even length
+			Byte1:       0xF8,
+			Byte2:       2, //number of words (two byte pairs) startying with byte 6
+			Byte3:       0x00,
+			Byte6:       0,
+			Byte7:       9, //feedback subcommand
+			Byte8:       1, //1 for on, 0 for off
+			checkReturn: checkFeedback,
+			buildBytes:  buildLEDBuffer,
+		},
+		PortStateRead: &Command{ //read the state of digital input pins, high or low
+			SendLength:  8,
+			RecLength:   12,
+			Byte1:       0xF8,
+			Byte2:       1, //number of words (two byte pairs) startying with byte 6
+			Byte3:       0x00,
+			Byte6:       0,
+			Byte7:       26, //feedback subcommand
+			checkReturn: checkFeedback,
+			buildBytes:  buildPortStateReadBuffer,
+		},
+		PortStateWrite: &Command{ //write the state of digital output pins (high or low)
+			SendLength:  14,
+			RecLength:   10,
+			Byte1:       0xF8,
+			Byte2:       4, //number of words (two byte pairs) startying with byte 6
+			Byte3:       0x00,
+			Byte6:       0,
+			Byte7:       27, //feedback subcommand
+			checkReturn: checkFeedback,
+			buildBytes:  buildPortStateWriteBuffer,
+		},
+		PortDirRead: &Command{ //read the direction of the digital pins, input or output
+			SendLength:  8,
+			RecLength:   12,
+			Byte1:       0xF8,
+			Byte2:       1, //number of words (two byte pairs) startying with byte 6
+			Byte3:       0x00,
+			Byte6:       0,
+			Byte7:       28, //feedback subcommand
+			checkReturn: checkFeedback,
+			buildBytes:  buildPortDirReadBuffer,
+		},
+		PortDirWrite: &Command{ //write the direction of digital pins, input or output
+			SendLength:  14,
+			RecLength:   10,
+			Byte1:       0xF8,
+			Byte2:       4, //number of words (two byte pairs) startying with byte 6
+			Byte3:       0x00,
+			Byte6:       0,
+			Byte7:       29, //feedback subcommand
+			checkReturn: checkFeedback,
+			buildBytes:  buildPortDirWriteBuffer,
+		},
+		//the bit commands work on one pin and leave the rest alone, see SetBit
+		BitStateRead: &Command{ //read the state of one digital pin
+			SendLength:  10, //9 bytes padded to an even length
+			RecLength:   10,
+			Byte1:       0xF8,
+			Byte2:       2, //number of words (two byte pairs) startying with byte 6
+			Byte3:       0x00,
+			Byte6:       0,
+			Byte7:       10, //feedback subcommand
+			Byte8:       0,  //IONumber, the channel of the pin
+			checkReturn: checkFeedback,
+			buildBytes:  buildLEDBuffer, //same layout, IOType and one byte
+		},
+		BitStateWrite: &Command{ //write the state of one digital pin, it becomes an output
+			SendLength:  10,
+			RecLength:   10, //9 bytes padded to an even length
+			Byte1:       0xF8,
+			Byte2:       2,
+			Byte3:       0x00,
+			Byte6:       0,
+			Byte7:       11, //feedback subcommand
+			Byte8:       0,  //IONumber in bits 0-4, the state in bit 7
+			checkReturn: checkFeedback,
+			buildBytes:  buildLEDBuffer,
+		},
+		BitDirRead: &Command{ //read the direction of one digital pin
+			SendLength:  10,
+			RecLength:   10,
+			Byte1:       0xF8,
+			Byte2:       2,
+			Byte3:       0x00,
+			Byte6:       0,
+			Byte7:       12, //feedback subcommand
+			Byte8:       0,  //IONumber
+			checkReturn: checkFeedback,
+			buildBytes:  buildLEDBuffer,
+		},
+		BitDirWrite: &Command{ //write the direction of one digital pin
+			SendLength:  10,
+			RecLength:   10,
+			Byte1:       0xF8,
+			Byte2:       2,
+			Byte3:       0x00,
+			Byte6:       0,
+			Byte7:       13, //feedback subcommand
+			Byte8:       0,  //IONumber in bits 0-4, 1 for output in bit 7
+			checkReturn: checkFeedback,
+			buildBytes:  buildLEDBuffer,
+		},
+		Feedback: &Command{ //any list of IOTypes in one packet, set by SetFeedback
+			SendLength:  8,
+			RecLength:   10,
+			Byte1:       0xF8,
+			Byte2:       1,
+			Byte3:       0x00,
+			Byte6:       0,
+			checkReturn: checkFeedback,
+			buildBytes:  buildFeedbackBuffer,
+		},
+		DAC: &Command{ //set a DAC output, 16 bit value
+			SendLength:  10,
+			RecLength:   10,
+			Byte1:       0xF8,
+			Byte2:       2, //number of words (two byte pairs) startying with byte 6
+			Byte3:       0x00,
+			Byte6:       0,
+			Byte7:       38, //feedback subcommand, DAC0 (16 bit), 39 for DAC1
+			Byte8:       0,  //value LSB
+			Byte9:       0,  //value MSB
+			checkReturn: checkFeedback,
+			buildBytes:  buildAINReadBuffer, //same layout, IOType and two bytes
+		},
+		TempSense: &Command{ // read temperature, an AIN of the internal sensor
+			SendLength:  10,
+			RecLength:   12,
+			Byte1:       0xF8,
+			Byte2:       2, //number of words (two byte pairs) startying with byte 6
+			Byte3:       0x00,
+			Byte6:       0,
+			Byte7:       1,     //feedback subcommand, AIN
+			Byte8:       30,    //positive channel 30 is the temperature sensor
+			Byte9:       SENeg, //negative channel
+			checkReturn: checkFeedback,
+			buildBytes:  buildAINReadBuffer,
+		},
+		VReg: &Command{ //read the 3.3 volt regulator, an AIN of channel 31
+			SendLength:  10,
+			RecLength:   12,
+			Byte1:       0xF8,
+			Byte2:       2, //number of words (two byte pairs) startying with byte 6
+			Byte3:       0x00,
+			Byte6:       0,
+			Byte7:       1,     //feedback subcommand, AIN
+			Byte8:       31,    //positive channel 31 is Vreg
+			Byte9:       SENeg, //negative channel
+			checkReturn: checkFeedback,
+			buildBytes:  buildAINReadBuffer,
+		},
+	}
+}
+
+//<+++++++++++++++++++ Check Methods for Commands to the Device +++++++++++++++>
+
+func checkJack(sr *Command, recBuffer []byte) error {
+	if recBuffer[0] == 0xB8 && recBuffer[1] == 0xB8 {
+		return fmt.Errorf("The U3 detected a bad checksum. Double check your checksum calculations and try again")
+	} else {
+		if recBuffer[1] != 0xF8 || recBuffer[2] != 0x10 || recBuffer[3] != 0x08 {
+			// Make sure the command bytes match what we expect.
+			return fmt.Errorf("Got the wrong command bytes back from the U3")
+		}
+
+		checksum16 := calculateChecksum16(recBuffer, sr.RecLength)
+		checksum8 := calculateChecksum8(recBuffer)
+		if checksum8 != recBuffer[0] || int(recBuffer[4]) != checksum16&0xff || int(recBuffer[5]) != ((checksum16/256)&0xff) {
+			return fmt.Errorf("Response had invalid checksum.\n%d != %d, %d != %d, %d != %d", checksum8, recBuffer[0], checksum16&0xff, recBuffer[4], ((checksum16 / 256) & 0xff), recBuffer[5])
+		} else {
+			if recBuffer[6] != 0 { // Check the error code in the packet. See section 5.3 of the U3
+				return fmt.Errorf("Command returned with an errorcode = %d", recBuffer[6])
+			}
+			return nil
+		}
+	}
+}
+
+func checkIO(sr *Command, recBuffer []byte) error {
+	if recBuffer[0] == 0xB8 && recBuffer[1] == 0xB8 {
+		return fmt.Errorf("The U3 detected a bad checksum. Double check your checksum calculations and try again")
+	} else {
+		if recBuffer[1] != sr.Byte1 || recBuffer[2] != sr.Byte2 || recBuffer[3] != sr.Byte3 {
+			return fmt.Errorf("Got the wrong command bytes back from the U3")
+		}
+
+		checksum16 := calculateChecksum16(recBuffer, sr.RecLength)
+		checksum8 := calculateChecksum8(recBuffer)
+		if checksum8 != recBuffer[0] || int(recBuffer[4]) != checksum16&0xff || int(recBuffer[5]) != ((checksum16/256)&0xff) {
+			return fmt.Errorf("Response had invalid checksum.\n%d != %d, %d != %d, %d != %d", checksum8, recBuffer[0], checksum16&0xff, recBuffer[4], ((checksum16 / 256) & 0xff), recBuffer[5])
+		} else {
+			if recBuffer[6] != 0 { // Check the error code in the packet. See section 5.3 of the U3
+				return fmt.Errorf("Command returned with an errorcode = %d", recBuffer[6])
+			}
+			return nil
+		}
+	}
+}
+
+// the response to ReadMem has 16 data words rather than the one sent.
+func checkReadMem(sr *Command, recBuffer []byte) error {
+	if recBuffer[0] == 0xB8 && recBuffer[1] == 0xB8 {
+		return fmt.Errorf("The U3 detected a bad checksum. Double check your checksum calculations and try again")
+	}
+	if recBuffer[1] != sr.Byte1 || recBuffer[2] != 0x11 || recBuffer[3] != sr.Byte3 {
+		return fmt.Errorf("Got the wrong command bytes back from the U3")
+	}
+	checksum16 := calculateChecksum16(recBuffer, sr.RecLength)
+	checksum8 := calculateChecksum8(recBuffer)
+	if checksum8 != recBuffer[0] || int(recBuffer[4]) != checksum16&0xff || int(recBuffer[5]) != ((checksum16/256)&0xff) {
+		return fmt.Errorf("Response had invalid checksum.\n%d != %d, %d != %d, %d != %d", checksum8, recBuffer[0], checksum16&0xff, recBuffer[4], ((checksum16 / 256) & 0xff), recBuffer[5])
+	}
+	if recBuffer[6] != 0 { // Check the error code in the packet. See section 5.3 of the U3
+		return fmt.Errorf("Command returned with an errorcode = %d", recBuffer[6])
+	}
+	return nil
+}
+
+func checkFeedback(sr *Command, recBuffer []byte) error {
+	if recBuffer[0] == 0xB8 && recBuffer[1] == 0xB8 {
+		return fmt.Errorf("The U3 detected a bad checksum. Double check your checksum calculations and try again")
+	}
+	if recBuffer[1] != sr.Byte1 {
+		return fmt.Errorf("Got the wrong command bytes back from the U3")
+	}
+	checksum16 := calculateChecksum16(recBuffer, sr.RecLength)
+	checksum8 := calculateChecksum8(recBuffer)
+	if checksum8 != recBuffer[0] || int(recBuffer[4]) != checksum16&0xff || int(recBuffer[5]) != ((checksum16/256)&0xff) {
+		return fmt.Errorf("Response had invalid checksum.\n%d != %d, %d != %d, %d != %d", checksum8, recBuffer[0], checksum16&0xff, recBuffer[4], ((checksum16 / 256) & 0xff), recBuffer[5])
+	}
+	if recBuffer[6] != 0 { // Check the error code in the packet. See section 5.3 of the U3
+		return fmt.Errorf("Command returned with an errorcode = %d", recBuffer[6])
+	}
+	return nil
+}
+
+//<+++++++++++++ Build Methods for sendBuffer for the commands ++++++++++++++++>
+
+// builds the configU3 command send buffer templated after the vendor C example
+func buildJackSendBuffer(sr *Command, sendBuffer []byte, writeMask byte) {
+	copyHead(sr, sendBuffer)
+	sendBuffer[6] = writeMask
+	for i := 7; i < sr.SendLength; i++ {
+		sendBuffer[i] = 0
+	}
+	sendBuffer[10] = sr.Byte10
+	sendBuffer[11] = sr.Byte11
+	addChecksum(sr, sendBuffer)
+}
+
+// templated after the configU3 buffer build provided by the vendor (in C)
+func buildPortDirReadBuffer(sr *Command, sendBuffer []byte, writeMask byte) {
+	copyHead(sr, sendBuffer)
+	sendBuffer[6] = writeMask
+	sendBuffer[7] = sr.Byte7
+	addChecksum(sr, sendBuffer)
+}
+
+func buildPortStateReadBuffer(sr *Command, sendBuffer []byte, writeMask byte) {
+	copyHead(sr, sendBuffer)
+	sendBuffer[6] = writeMask
+	sendBuffer[7] = sr.Byte7
+	addChecksum(sr, sendBuffer)
+}
+
+func buildAINReadBuffer(sr *Command, sendBuffer []byte, writeMask byte) {
+	copyHead(sr, sendBuffer)
+	sendBuffer[6] = writeMask
+	sendBuffer[7] = sr.Byte7
+	sendBuffer[8] = sr.Byte8
+	sendBuffer[9] = sr.Byte9
+	addChecksum(sr, sendBuffer)
+}
+
+// repeats the AIN IOType count times, the pad byte if any is left at zero.
+func buildAINBatchBuffer(sr *Command, sendBuffer []byte, writeMask byte) {
+	copyHead(sr, sendBuffer)
+	sendBuffer[6] = writeMask
+	for k := 0; k < sr.Count; k++ {
+		sendBuffer[7+3*k] = sr.Byte7
+		sendBuffer[8+3*k] = sr.Byte8
+		sendBuffer[9+3*k] = sr.Byte9
+	}
+	addChecksum(sr, sendBuffer)
+}
+
+// copies the IOTypes in Data after the echo byte, the pad byte if any is zero.
+func buildFeedbackBuffer(sr *Command, sendBuffer []byte, writeMask byte) {
+	copyHead(sr, sendBuffer)
+	sendBuffer[6] = writeMask
+	for i := 7; i < sr.SendLength; i++ {
+		sendBuffer[i] = 0x00
+	}
+	copy(sendBuffer[7:], sr.Data)
+	addChecksum(sr, sendBuffer)
+}
+
+//MaxAINBatch is the number of AIN reads that fit in one 64 byte feedback packet.
+const MaxAINBatch = 19
+
+// sets the number of reads in the ainBatch command along with the lengths and
+// word count that go with it.  Each read is 3 bytes sent and 2 recieved, and
+// both packets are padded to an even length.
+func (sr *Command) SetAINBatch(n int) {
+	sr.Count = n
+	sr.SendLength = 7 + 3*n
+	sr.SendLength += sr.SendLength % 2
+	sr.RecLength = 9 + 2*n + 1
+	sr.Byte2 = byte((sr.SendLength - 6) / 2)
+}
+
+func buildReadMemBuffer(sr *Command, sendBuffer []byte, writeMask byte) {
+	copyHead(sr, sendBuffer)
+	sendBuffer[6] = 0x00
+	sendBuffer[7] = sr.Byte7
+	addChecksum(sr, sendBuffer)
+}
+
+// the LED state is the only data byte, byte 9 is padding.
+func buildLEDBuffer(sr *Command, sendBuffer []byte, writeMask byte) {
+	copyHead(sr, sendBuffer)
+	sendBuffer[6] = writeMask
+	sendBuffer[7] = sr.Byte7
+	sendBuffer[8] = sr.Byte8
+	sendBuffer[9] = 0x00
+	addChecksum(sr, sendBuffer)
+}
+
+/*
+The feedback functions all have a different send and recieve buffer templetaes.
+Hence all send buffer builds will be different.
+*/
+func buildPortDirWriteBuffer(sr *Command, sendBuffer []byte, writeMask byte) {
+	copyHead(sr, sendBuffer)
+	for i := 8; i < sr.SendLength; i++ {
+		sendBuffer[i] = 0x00
+	}
+	sendBuffer[8] = 0xff
+	sendBuffer[9] = 0xff
+	sendBuffer[10] = 0xff
+	sendBuffer[11] = sr.Byte11
+	sendBuffer[12] = sr.Byte12
+	sendBuffer[13] = sr.Byte13
+	addChecksum(sr, sendBuffer)
+}
+
+func buildPortStateWriteBuffer(sr *Command, sendBuffer []byte, writeMask byte) {
+	copyHead(sr, sendBuffer)
+	for i := 8; i < sr.SendLength; i++ {
+		sendBuffer[i] = 0x00
+	}
+	sendBuffer[8] = sr.Byte8
+	sendBuffer[9] = sr.Byte9
+	sendBuffer[10] = sr.Byte10
+	sendBuffer[11] = sr.Byte11
+	sendBuffer[12] = sr.Byte12
+	sendBuffer[13] = sr.Byte13
+	addChecksum(sr, sendBuffer)
+}
+
+// <++++++++++++++++++++++++  Helper Functions ++++++++++++++++++++++++++++++++>
+// helper function for building sendBuffer
+func copyHead(sr *Command, sendBuffer []byte) {
+	sendBuffer[1] = sr.Byte1
+	sendBuffer[2] = sr.Byte2
+	sendBuffer[3] = sr.Byte3
+	sendBuffer[6] = sr.Byte6
+	sendBuffer[7] = sr.Byte7
+}
+
+// helper function for building sendbuffer
+func addChecksum(sr *Command, sendBuffer []byte) {
+	checksum := 0
+	checksum = calculateChecksum16(sendBuffer, sr.SendLength)
+	sendBuffer[4] = byte(checksum & 0xff)
+	sendBuffer[5] = byte((checksum / 256) & 0xff)
+	sendBuffer[0] = calculateChecksum8(sendBuffer)
+}
+
+// helper function for building send and checking recieve buffers.
+func calculateChecksum16(buffer []byte, len int) int {
+	checksum := 0
+	for i := 6; i < len; i++ {
+		checksum += int(buffer[i])
+	}
+
+	return checksum
+}
+
+// helper function for building the send and checking the recive buffers.
+func calculateChecksum8(buffer []byte) byte {
+	var temp int // For holding a value while we working.
+	checksum := 0
+
+	for i := 1; i < 6; i++ {
+		checksum += int(buffer[i])
+	}
+
+	temp = checksum / 256
+	checksum = (checksum - 256*temp) + temp
+	temp = checksum / 256
+
+	return byte((checksum - 256*temp) + temp)
+}
+
+// Takes a buffer and an offset, and turns into an 32-bit integer
+func MakeInt(buffer []byte, offset int) int {
+	return int(uint32(buffer[offset+3])<<24 + uint32(buffer[offset+2])<<16 + uint32(buffer[offset+1])<<8 + uint32(buffer[offset]))
+}
+
+// Takes a buffer and an offset, and turns into an 16-bit integer
+func MakeShort(buffer []byte, offset int) int {
+	return int(uint16(buffer[offset+1])<<8 + uint16(buffer[offset]))
+}
+
+//Build fills sendBuffer (SendLength long) for the command, writeMask goes in
+//byte 6 for the commands that have one.
+func (sr *Command) Build(sendBuffer []byte, writeMask byte) {
+	sr.buildBytes(sr, sendBuffer, writeMask)
+}
+
+//Check returns an error when recBuffer is not a good response to the command.
+func (sr *Command) Check(recBuffer []byte) error {
+	if sr.checkReturn == nil {
+		return nil
+	}
+	return sr.checkReturn(sr, recBuffer)
+}
+
+//sets the DAC command for output dac (0 or 1) to value, which is the 16 bit
+//value from Calibration.DACValue.
+func (sr *Command) SetDAC(dac int, value uint16) {
+	sr.Byte7 = byte(38 + dac)
+	sr.Byte8 = byte(value & 0xff)
+	sr.Byte9 = byte(value >> 8)
+}
+
+/*
+IOType bytes and units for building a Feedback command.  WaitShort and WaitLong
+take one byte, the wait in WaitShortUnit or WaitLongUnit, and hold up the
+IOTypes after them in the packet, which is how a packet times its writes much
+closer than the host can between packets.  BitStateWrite takes one byte made
+as in SetBit.
+*/
+const (
+	IOWaitShort     = 5
+	IOWaitLong      = 6
+	IOBitStateWrite = 11
+	WaitShortUnit   = 128 * time.Microsecond
+	WaitLongUnit    = 32 * time.Millisecond
+)
+
+//MaxFeedback is the most IOType bytes that fit in one 64 byte Feedback packet.
+const MaxFeedback = 64 - 7
+
+//sets the Feedback command to send the IOTypes in data (at most MaxFeedback
+//bytes) and expect rec bytes of data back, with the lengths and word count
+//that go with them.  Both packets are padded to an even length.
+func (sr *Command) SetFeedback(data []byte, rec int) error {
+	if len(data) == 0 || len(data) > MaxFeedback {
+		return fmt.Errorf("a Feedback command takes 1 to %d bytes of IOTypes, not %d", MaxFeedback, len(data))
+	}
+	sr.Data = data
+	sr.SendLength = 7 + len(data)
+	sr.SendLength += sr.SendLength % 2
+	sr.RecLength = 9 + rec
+	sr.RecLength += sr.RecLength % 2
+	sr.Byte2 = byte((sr.SendLength - 6) / 2)
+	sr.Byte7 = data[0]
+	return nil
+}
+
+//sets the IONumber byte of a bit command to channel ch (see Channel) and, for
+//the writes, the state or direction to value (1 for high or output).  The
+//reads ignore value.
+func (sr *Command) SetBit(ch int, value int) {
+	sr.Byte8 = byte(ch&0x1F) | byte(value&1)<<7
+}
+
+//<++++++++++++++++++++++++  Parsing the responses  +++++++++++++++++++++++++++>
+
+//Config is what the ConfigU3 response says about the device.
+type Config struct {
+	FirmwareVersion   string
+	BootLoaderVersion string
+	HardwareVersion   string
+	SerialNumber      string
+	ProductID         string
+	LocalID           string
+	DeviceName        string
+}
+
+// Parses the ConfigU3 recBuffer.
+func ParseConfig(recBuffer []byte) Config {
+	c := Config{
+		FirmwareVersion:   fmt.Sprintf("%d.%02d", int(recBuffer[10]), int(recBuffer[9])),
+		BootLoaderVersion: fmt.Sprintf("%d.%02d", recBuffer[12], recBuffer[11]),
+		HardwareVersion:   fmt.Sprintf("%d.%02d", recBuffer[14], recBuffer[13]),
+		SerialNumber:      fmt.Sprintf("%d", MakeInt(recBuffer, 15)),
+		ProductID:         fmt.Sprintf("%d", MakeShort(recBuffer, 19)),
+		LocalID:           fmt.Sprintf("%d", recBuffer[21]),
+	}
+	switch recBuffer[37] {
+	case 0:
+		c.DeviceName = "U3A"
+	case 1:
+		c.DeviceName = "U3B"
+	case 2:
+		c.DeviceName = "U3-LV"
+	case 18:
+		c.DeviceName = "U3-HV"
+	default:
+		c.DeviceName = "Not recognized"
+	}
+	return c
+}
+
+//HV is true for the U3-HV, whose FIO0 through FIO3 are high voltage inputs.
+//It is assumed for anything but the U3-LV, as the web program does.
+func (c Config) HV() bool {
+	return c.DeviceName != "U3-LV"
+}
+
+//PortBits returns the FIO, EIO and CIO bytes of a Port State Read or Port
+//Direction Read response.
+func PortBits(recBuffer []byte) [3]byte {
+	return [3]byte{recBuffer[9], recBuffer[10], recBuffer[11]}
+}
+
+//BitValue returns the state or direction (1 for output) in a Bit State Read or
+//Bit Direction Read response.
+func BitValue(recBuffer []byte) int {
+	return int(recBuffer[9] & 1)
+}
+
+//AINBits returns read k (0 for a single AIN) of an AIN or AIN Batch response.
+func AINBits(recBuffer []byte, k int) uint16 {
+	return uint16(recBuffer[9+2*k]) + uint16(recBuffer[10+2*k])*256
+}
+
+/*
+Channel returns the channel number of the pin named name: 0-7 for FIO0-FIO7,
+8-15 for EIO0-EIO7 and 16-19 for CIO0-CIO3.  The analog channel of FIO and EIO
+pins is the same number, and the digital port bytes hold the pins in that order,
+so channel/8 is the port and channel%8 the bit.
+*/
+func Channel(name string) (int, error) {
+	var port string
+	var i int
+	if n, err := fmt.Sscanf(strings.ToUpper(name), "%3s%d", &port, &i); n != 2 || err != nil {
+		return 0, fmt.Errorf("%q is not a pin name", name)
+	}
+	switch {
+	case port == "FIO" && i >= 0 && i < 8:
+		return i, nil
+	case port == "EIO" && i >= 0 && i < 8:
+		return i + 8, nil
+	case port == "CIO" && i >= 0 && i < 4:
+		return i + 16, nil
+	}
+	return 0, fmt.Errorf("%q is not a pin name", name)
+}
+
+//ChannelName is the reverse of Channel.
+func ChannelName(ch int) string {
+	return fmt.Sprintf("%s%d", [3]string{"FIO", "EIO", "CIO"}[ch/8], ch%8)
+}
@@ -0,0 +1,304 @@
+package jack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+A trace is every buffer sent to the U3s and every buffer read back, kept so a
+misbehaving command can be looked at byte by byte and played back later with
+no hardware.  Recorder writes one and Replay plays one back.
+
+It is a text file with one line per call of the transport.  Lines starting
+with # are comments, the first one is "#u3trace 1".  The other lines have
+their fields separated by single spaces:
+
+	TIME x DEVICE SEND REC [ERROR]
+	TIME n COUNT
+
+TIME is when the call was made, RFC 3339 with nanoseconds.  An x line is an
+exchange: DEVICE is the device number, SEND and REC are the buffers in lower
+case hex with two digits per byte and nothing between them ("-" when empty),
+and ERROR, when there is one, is the rest of the line.  REC is recorded as
+read even when there is an error.  An n line is a call of DevCount and COUNT
+is what it returned.  For example
+
+	#u3trace 1
+	2026-10-19T16:40:02.123456789Z n 1
+	2026-10-19T16:40:02.124001234Z x 1 5ff8020064000001441f d8f80300dc00000000409c00
+
+The trace has the buffers only, not the names of the commands.
+*/
+
+const traceHeader = "#u3trace 1"
+
+//TraceEntry is one line of a trace.
+type TraceEntry struct {
+	Time   time.Time
+	Device int //device of an exchange, 0 for a DevCount
+	Count  int //what DevCount returned
+	Send   []byte
+	Rec    []byte
+	Err    string
+}
+
+func hexOrDash(b []byte) string {
+	if len(b) == 0 {
+		return "-"
+	}
+	return hex.EncodeToString(b)
+}
+
+func unhex(s string) ([]byte, error) {
+	if s == "-" {
+		return nil, nil
+	}
+	return hex.DecodeString(s)
+}
+
+//String is the entry as a line of the trace, without the newline.
+func (e TraceEntry) String() string {
+	t := e.Time.UTC().Format(time.RFC3339Nano)
+	if e.Device == 0 {
+		return fmt.Sprintf("%s n %d", t, e.Count)
+	}
+	s := fmt.Sprintf("%s x %d %s %s", t, e.Device, hexOrDash(e.Send), hexOrDash(e.Rec))
+	if e.Err != "" {
+		//a line can not hold a newline, the check errors have some
+		s += " " + strings.ReplaceAll(e.Err, "\n", " ")
+	}
+	return s
+}
+
+//ReadTrace reads the trace in r.
+func ReadTrace(r io.Reader) ([]TraceEntry, error) {
+	entries := []TraceEntry{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			if n == 1 && line != traceHeader {
+				return nil, fmt.Errorf("trace line 1: %q is not a %s trace", line, traceHeader)
+			}
+			continue
+		}
+		e, err := parseTraceLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("trace line %d: %v", n, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+func parseTraceLine(line string) (TraceEntry, error) {
+	e := TraceEntry{}
+	f := strings.SplitN(line, " ", 6)
+	if len(f) < 3 {
+		return e, fmt.Errorf("too few fields")
+	}
+	var err error
+	if e.Time, err = time.Parse(time.RFC3339Nano, f[0]); err != nil {
+		return e, err
+	}
+	switch {
+	case f[1] == "n" && len(f) == 3:
+		e.Count, err = strconv.Atoi(f[2])
+		return e, err
+	case f[1] == "x" && len(f) >= 5:
+		if e.Device, err = strconv.Atoi(f[2]); err != nil || e.Device < 1 {
+			return e, fmt.Errorf("%q is not a device number", f[2])
+		}
+		if e.Send, err = unhex(f[3]); err != nil {
+			return e, err
+		}
+		if e.Rec, err = unhex(f[4]); err != nil {
+			return e, err
+		}
+		if len(f) == 6 {
+			e.Err = f[5]
+		}
+		return e, nil
+	}
+	return e, fmt.Errorf("expected TIME x DEVICE SEND REC [ERROR] or TIME n COUNT")
+}
+
+//Recorder is a Transport that passes everything on to T and writes it to a
+//trace as it goes.  A failed write is kept in Err and stops the recording, not
+//the exchanges.
+type Recorder struct {
+	T   Transport
+	w   io.Writer
+	mu  sync.Mutex
+	Err error
+}
+
+//NewRecorder starts a trace of t on w.
+func NewRecorder(t Transport, w io.Writer) *Recorder {
+	r := &Recorder{T: t, w: w}
+	_, r.Err = fmt.Fprintln(w, traceHeader)
+	return r
+}
+
+func (r *Recorder) write(e TraceEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Err == nil {
+		_, r.Err = fmt.Fprintln(r.w, e)
+	}
+}
+
+func (r *Recorder) DevCount() int {
+	e := TraceEntry{Time: time.Now()}
+	e.Count = r.T.DevCount()
+	r.write(e)
+	return e.Count
+}
+
+func (r *Recorder) Exchange(devNum int, sendBuffer, recBuffer []byte) error {
+	e := TraceEntry{Time: time.Now(), Device: devNum, Send: sendBuffer}
+	err := r.T.Exchange(devNum, sendBuffer, recBuffer)
+	e.Rec = recBuffer
+	if err != nil {
+		e.Err = err.Error()
+	}
+	r.write(e)
+	return err
+}
+
+/*
+Replay is a Transport that plays back a trace.  Each exchange is answered with
+the first exchange of the trace, not used yet, to the same device with the same
+send buffer, so the reads come back in the order they were recorded even if
+the program sends a few other things in between.  With Strict it has to be the
+very next exchange of the trace.  DevCount returns the counts of the trace in
+order, and the last one once they are used up.
+*/
+type Replay struct {
+	Strict  bool
+	entries []TraceEntry
+	used    []bool
+	next    int //first exchange not used
+	counts  []int
+	mu      sync.Mutex
+}
+
+//NewReplay plays back entries, see ReadTrace.
+func NewReplay(entries []TraceEntry) *Replay {
+	p := &Replay{entries: entries, used: make([]bool, len(entries))}
+	max := 0
+	for _, e := range entries {
+		if e.Device == 0 {
+			p.counts = append(p.counts, e.Count)
+		} else if e.Device > max {
+			max = e.Device
+		}
+	}
+	if len(p.counts) == 0 {
+		p.counts = []int{max}
+	}
+	return p
+}
+
+func (p *Replay) DevCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := p.counts[0]
+	if len(p.counts) > 1 {
+		p.counts = p.counts[1:]
+	}
+	return n
+}
+
+func (p *Replay) Exchange(devNum int, sendBuffer, recBuffer []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := p.next; i < len(p.entries); i++ {
+		e := p.entries[i]
+		if e.Device == 0 || p.used[i] {
+			continue
+		}
+		if e.Device != devNum || !bytes.Equal(e.Send, sendBuffer) {
+			if p.Strict {
+				return fmt.Errorf("replay: trace entry %d is device %d sending %x, not device %d sending %x",
+					i+1, e.Device, e.Send, devNum, sendBuffer)
+			}
+			continue
+		}
+		p.used[i] = true
+		for p.next < len(p.entries) && (p.used[p.next] || p.entries[p.next].Device == 0) {
+			p.next++
+		}
+		copy(recBuffer, e.Rec)
+		if e.Err != "" {
+			return errors.New(e.Err)
+		}
+		if len(e.Rec) != len(recBuffer) {
+			return fmt.Errorf("replay: trace has %d bytes back, %d were expected", len(e.Rec), len(recBuffer))
+		}
+		return nil
+	}
+	return fmt.Errorf("replay: nothing left in the trace for device %d sending %x", devNum, sendBuffer)
+}
+
+//Left is the number of exchanges of the trace not played back yet.
+func (p *Replay) Left() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for i, e := range p.entries {
+		if e.Device != 0 && !p.used[i] {
+			n++
+		}
+	}
+	return n
+}
+
+/*
+UseTrace sets the transport from the -trace and -replay flags of the programs.
+With replay the trace in that file is played back instead of talking to the
+USB, strictly in order with strict.  With trace everything is recorded to that
+file, which is overwritten.  The returned function closes the trace file.
+*/
+func UseTrace(trace, replay string, strict bool) (func() error, error) {
+	if replay != "" {
+		f, err := os.Open(replay)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := ReadTrace(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", replay, err)
+		}
+		p := NewReplay(entries)
+		p.Strict = strict
+		SetTransport(p)
+	}
+	if trace == "" {
+		return func() error { return nil }, nil
+	}
+	f, err := os.Create(trace)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRecorder(transport, f)
+	SetTransport(r)
+	return func() error {
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return r.Err
+	}, nil
+}
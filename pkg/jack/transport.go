@@ -0,0 +1,54 @@
+package jack
+
+//Transport carries the raw buffers to a U3 and back.  USB is the real one,
+//Replay plays back a trace and Recorder writes one, see trace.go.
+type Transport interface {
+	//DevCount returns the number of U3s that can be talked to.
+	DevCount() int
+	//Exchange writes sendBuffer to device devNum (starting at 1) and fills
+	//recBuffer, which is as long as the response.
+	Exchange(devNum int, sendBuffer, recBuffer []byte) error
+}
+
+var transport Transport = USB{}
+
+//SetTransport makes DevCount and SendRec go through t and returns the one
+//they went through before.  It is meant to be called once at start up.
+func SetTransport(t Transport) Transport {
+	old := transport
+	transport = t
+	return old
+}
+
+//DevCount returns the number of U3 devices on the transport.
+func DevCount() int {
+	return transport.DevCount()
+}
+
+//TransportError is the error of SendRec when the exchange with the device
+//failed, as opposed to a response that failed the checks.
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string { return e.Err.Error() }
+func (e *TransportError) Unwrap() error { return e.Err }
+
+/*
+SendRec is a generic function for writing command sr to the U3 with device
+number devNum (starting at 1) and getting the results back.  It returns the
+send and recieve buffers, the recieve buffer to be parsed by the caller.  Both
+are returned with the error too, for debugging.  The send buffer is built
+first so it is good for the logs even if the device can not be reached.  A
+failed exchange comes back as a *TransportError.
+*/
+func SendRec(devNum int, sr *Command, writeMask byte) ([]byte, []byte, error) {
+	sendBuffer := make([]byte, sr.SendLength)
+	recBuffer := make([]byte, sr.RecLength)
+	sr.Build(sendBuffer, writeMask)
+	if err := transport.Exchange(devNum, sendBuffer, recBuffer); err != nil {
+		return sendBuffer, recBuffer, &TransportError{err}
+	}
+	// Check the command for errors
+	return sendBuffer, recBuffer, sr.Check(recBuffer)
+}
@@ -0,0 +1,1367 @@
+//u3.proto is the gRPC api of the web program (see grpc.go in cmd/web), for
+//driving the U3 from other services.  The messages mirror the U3 and Pin types
+//of cmd/web field for field, with the same strings for the settings ("Analog"
+//or "Digital", "Input" or "Output", the filter and scale kinds).  Pins are
+//named like on the device, FIO4 or EIO0 for example.
+//
+//After changing it, generate u3.pb.go and u3_grpc.pb.go again from this
+//directory with
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	  --go-grpc_out=. --go-grpc_opt=paths=source_relative u3.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: u3.proto
+
+package u3pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Scale struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Kind         string                 `protobuf:"bytes,1,opt,name=kind,proto3" json:"kind,omitempty"`
+	Slope        float64                `protobuf:"fixed64,2,opt,name=slope,proto3" json:"slope,omitempty"`
+	Offset       float64                `protobuf:"fixed64,3,opt,name=offset,proto3" json:"offset,omitempty"`
+	Coefficients []float64              `protobuf:"fixed64,4,rep,packed,name=coefficients,proto3" json:"coefficients,omitempty"`
+	//(volts, value) pairs sorted by volts
+	Table         []*TablePoint `protobuf:"bytes,5,rep,name=table,proto3" json:"table,omitempty"`
+	Beta          float64       `protobuf:"fixed64,6,opt,name=beta,proto3" json:"beta,omitempty"`
+	R0            float64       `protobuf:"fixed64,7,opt,name=r0,proto3" json:"r0,omitempty"`
+	T0            float64       `protobuf:"fixed64,8,opt,name=t0,proto3" json:"t0,omitempty"`
+	RFixed        float64       `protobuf:"fixed64,9,opt,name=r_fixed,json=rFixed,proto3" json:"r_fixed,omitempty"`
+	Supply        float64       `protobuf:"fixed64,10,opt,name=supply,proto3" json:"supply,omitempty"`
+	TcType        string        `protobuf:"bytes,11,opt,name=tc_type,json=tcType,proto3" json:"tc_type,omitempty"`
+	ColdJunction  float64       `protobuf:"fixed64,12,opt,name=cold_junction,json=coldJunction,proto3" json:"cold_junction,omitempty"`
+	Gain          float64       `protobuf:"fixed64,13,opt,name=gain,proto3" json:"gain,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Scale) Reset() {
+	*x = Scale{}
+	mi := &file_u3_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Scale) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Scale) ProtoMessage() {}
+
+func (x *Scale) ProtoReflect() protoreflect.Message {
+	mi := &file_u3_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Scale.ProtoReflect.Descriptor instead.
+func (*Scale) Descriptor() ([]byte, []int) {
+	return file_u3_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Scale) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *Scale) GetSlope() float64 {
+	if x != nil {
+		return x.Slope
+	}
+	return 0
+}
+
+func (x *Scale) GetOffset() float64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *Scale) GetCoefficients() []float64 {
+	if x != nil {
+		return x.Coefficients
+	}
+	return nil
+}
+
+func (x *Scale) GetTable() []*TablePoint {
+	if x != nil {
+		return x.Table
+	}
+	return nil
+}
+
+func (x *Scale) GetBeta() float64 {
+	if x != nil {
+		return x.Beta
+	}
+	return 0
+}
+
+func (x *Scale) GetR0() float64 {
+	if x != nil {
+		return x.R0
+	}
+	return 0
+}
+
+func (x *Scale) GetT0() float64 {
+	if x != nil {
+		return x.T0
+	}
+	return 0
+}
+
+func (x *Scale) GetRFixed() float64 {
+	if x != nil {
+		return x.RFixed
+	}
+	return 0
+}
+
+func (x *Scale) GetSupply() float64 {
+	if x != nil {
+		return x.Supply
+	}
+	return 0
+}
+
+func (x *Scale) GetTcType() string {
+	if x != nil {
+		return x.TcType
+	}
+	return ""
+}
+
+func (x *Scale) GetColdJunction() float64 {
+	if x != nil {
+		return x.ColdJunction
+	}
+	return 0
+}
+
+func (x *Scale) GetGain() float64 {
+	if x != nil {
+		return x.Gain
+	}
+	return 0
+}
+
+type TablePoint struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Volts         float64                `protobuf:"fixed64,1,opt,name=volts,proto3" json:"volts,omitempty"`
+	Value         float64                `protobuf:"fixed64,2,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TablePoint) Reset() {
+	*x = TablePoint{}
+	mi := &file_u3_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TablePoint) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TablePoint) ProtoMessage() {}
+
+func (x *TablePoint) ProtoReflect() protoreflect.Message {
+	mi := &file_u3_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TablePoint.ProtoReflect.Descriptor instead.
+func (*TablePoint) Descriptor() ([]byte, []int) {
+	return file_u3_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *TablePoint) GetVolts() float64 {
+	if x != nil {
+		return x.Volts
+	}
+	return 0
+}
+
+func (x *TablePoint) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+type Pin struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Ad              string                 `protobuf:"bytes,1,opt,name=ad,proto3" json:"ad,omitempty"`
+	Io              string                 `protobuf:"bytes,2,opt,name=io,proto3" json:"io,omitempty"`
+	AnalogRead      uint32                 `protobuf:"varint,3,opt,name=analog_read,json=analogRead,proto3" json:"analog_read,omitempty"`
+	AnalogVoltage   string                 `protobuf:"bytes,4,opt,name=analog_voltage,json=analogVoltage,proto3" json:"analog_voltage,omitempty"`
+	DigitalRead     int32                  `protobuf:"varint,5,opt,name=digital_read,json=digitalRead,proto3" json:"digital_read,omitempty"`
+	DigitalWrite    int32                  `protobuf:"varint,6,opt,name=digital_write,json=digitalWrite,proto3" json:"digital_write,omitempty"`
+	NegChannel      int32                  `protobuf:"varint,7,opt,name=neg_channel,json=negChannel,proto3" json:"neg_channel,omitempty"`
+	LongSettling    bool                   `protobuf:"varint,8,opt,name=long_settling,json=longSettling,proto3" json:"long_settling,omitempty"`
+	QuickSample     bool                   `protobuf:"varint,9,opt,name=quick_sample,json=quickSample,proto3" json:"quick_sample,omitempty"`
+	Samples         int32                  `protobuf:"varint,10,opt,name=samples,proto3" json:"samples,omitempty"`
+	Filter          string                 `protobuf:"bytes,11,opt,name=filter,proto3" json:"filter,omitempty"`
+	EmaWeight       float64                `protobuf:"fixed64,12,opt,name=ema_weight,json=emaWeight,proto3" json:"ema_weight,omitempty"`
+	FilteredRead    float64                `protobuf:"fixed64,13,opt,name=filtered_read,json=filteredRead,proto3" json:"filtered_read,omitempty"`
+	FilteredVoltage string                 `protobuf:"bytes,14,opt,name=filtered_voltage,json=filteredVoltage,proto3" json:"filtered_voltage,omitempty"`
+	Label           string                 `protobuf:"bytes,15,opt,name=label,proto3" json:"label,omitempty"`
+	Name            string                 `protobuf:"bytes,16,opt,name=name,proto3" json:"name,omitempty"`
+	Unit            string                 `protobuf:"bytes,17,opt,name=unit,proto3" json:"unit,omitempty"`
+	Scale           *Scale                 `protobuf:"bytes,18,opt,name=scale,proto3" json:"scale,omitempty"`
+	Value           float64                `protobuf:"fixed64,19,opt,name=value,proto3" json:"value,omitempty"`
+	ValueText       string                 `protobuf:"bytes,20,opt,name=value_text,json=valueText,proto3" json:"value_text,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Pin) Reset() {
+	*x = Pin{}
+	mi := &file_u3_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Pin) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Pin) ProtoMessage() {}
+
+func (x *Pin) ProtoReflect() protoreflect.Message {
+	mi := &file_u3_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Pin.ProtoReflect.Descriptor instead.
+func (*Pin) Descriptor() ([]byte, []int) {
+	return file_u3_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Pin) GetAd() string {
+	if x != nil {
+		return x.Ad
+	}
+	return ""
+}
+
+func (x *Pin) GetIo() string {
+	if x != nil {
+		return x.Io
+	}
+	return ""
+}
+
+func (x *Pin) GetAnalogRead() uint32 {
+	if x != nil {
+		return x.AnalogRead
+	}
+	return 0
+}
+
+func (x *Pin) GetAnalogVoltage() string {
+	if x != nil {
+		return x.AnalogVoltage
+	}
+	return ""
+}
+
+func (x *Pin) GetDigitalRead() int32 {
+	if x != nil {
+		return x.DigitalRead
+	}
+	return 0
+}
+
+func (x *Pin) GetDigitalWrite() int32 {
+	if x != nil {
+		return x.DigitalWrite
+	}
+	return 0
+}
+
+func (x *Pin) GetNegChannel() int32 {
+	if x != nil {
+		return x.NegChannel
+	}
+	return 0
+}
+
+func (x *Pin) GetLongSettling() bool {
+	if x != nil {
+		return x.LongSettling
+	}
+	return false
+}
+
+func (x *Pin) GetQuickSample() bool {
+	if x != nil {
+		return x.QuickSample
+	}
+	return false
+}
+
+func (x *Pin) GetSamples() int32 {
+	if x != nil {
+		return x.Samples
+	}
+	return 0
+}
+
+func (x *Pin) GetFilter() string {
+	if x != nil {
+		return x.Filter
+	}
+	return ""
+}
+
+func (x *Pin) GetEmaWeight() float64 {
+	if x != nil {
+		return x.EmaWeight
+	}
+	return 0
+}
+
+func (x *Pin) GetFilteredRead() float64 {
+	if x != nil {
+		return x.FilteredRead
+	}
+	return 0
+}
+
+func (x *Pin) GetFilteredVoltage() string {
+	if x != nil {
+		return x.FilteredVoltage
+	}
+	return ""
+}
+
+func (x *Pin) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+func (x *Pin) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Pin) GetUnit() string {
+	if x != nil {
+		return x.Unit
+	}
+	return ""
+}
+
+func (x *Pin) GetScale() *Scale {
+	if x != nil {
+		return x.Scale
+	}
+	return nil
+}
+
+func (x *Pin) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+func (x *Pin) GetValueText() string {
+	if x != nil {
+		return x.ValueText
+	}
+	return ""
+}
+
+type Device struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Fio               []*Pin                 `protobuf:"bytes,1,rep,name=fio,proto3" json:"fio,omitempty"`
+	Eio               []*Pin                 `protobuf:"bytes,2,rep,name=eio,proto3" json:"eio,omitempty"`
+	Cio               []*Pin                 `protobuf:"bytes,3,rep,name=cio,proto3" json:"cio,omitempty"`
+	FirmwareVersion   string                 `protobuf:"bytes,4,opt,name=firmware_version,json=firmwareVersion,proto3" json:"firmware_version,omitempty"`
+	BootLoaderVersion string                 `protobuf:"bytes,5,opt,name=boot_loader_version,json=bootLoaderVersion,proto3" json:"boot_loader_version,omitempty"`
+	HardwareVersion   string                 `protobuf:"bytes,6,opt,name=hardware_version,json=hardwareVersion,proto3" json:"hardware_version,omitempty"`
+	SerialNumber      string                 `protobuf:"bytes,7,opt,name=serial_number,json=serialNumber,proto3" json:"serial_number,omitempty"`
+	ProductId         string                 `protobuf:"bytes,8,opt,name=product_id,json=productId,proto3" json:"product_id,omitempty"`
+	LocalId           string                 `protobuf:"bytes,9,opt,name=local_id,json=localId,proto3" json:"local_id,omitempty"`
+	DeviceName        string                 `protobuf:"bytes,10,opt,name=device_name,json=deviceName,proto3" json:"device_name,omitempty"`
+	Temperature       float64                `protobuf:"fixed64,11,opt,name=temperature,proto3" json:"temperature,omitempty"`
+	Dac               []float64              `protobuf:"fixed64,12,rep,packed,name=dac,proto3" json:"dac,omitempty"`
+	Message           string                 `protobuf:"bytes,13,opt,name=message,proto3" json:"message,omitempty"`
+	Led               string                 `protobuf:"bytes,14,opt,name=led,proto3" json:"led,omitempty"`
+	DeviceNumber      int32                  `protobuf:"varint,15,opt,name=device_number,json=deviceNumber,proto3" json:"device_number,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *Device) Reset() {
+	*x = Device{}
+	mi := &file_u3_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Device) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Device) ProtoMessage() {}
+
+func (x *Device) ProtoReflect() protoreflect.Message {
+	mi := &file_u3_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Device.ProtoReflect.Descriptor instead.
+func (*Device) Descriptor() ([]byte, []int) {
+	return file_u3_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Device) GetFio() []*Pin {
+	if x != nil {
+		return x.Fio
+	}
+	return nil
+}
+
+func (x *Device) GetEio() []*Pin {
+	if x != nil {
+		return x.Eio
+	}
+	return nil
+}
+
+func (x *Device) GetCio() []*Pin {
+	if x != nil {
+		return x.Cio
+	}
+	return nil
+}
+
+func (x *Device) GetFirmwareVersion() string {
+	if x != nil {
+		return x.FirmwareVersion
+	}
+	return ""
+}
+
+func (x *Device) GetBootLoaderVersion() string {
+	if x != nil {
+		return x.BootLoaderVersion
+	}
+	return ""
+}
+
+func (x *Device) GetHardwareVersion() string {
+	if x != nil {
+		return x.HardwareVersion
+	}
+	return ""
+}
+
+func (x *Device) GetSerialNumber() string {
+	if x != nil {
+		return x.SerialNumber
+	}
+	return ""
+}
+
+func (x *Device) GetProductId() string {
+	if x != nil {
+		return x.ProductId
+	}
+	return ""
+}
+
+func (x *Device) GetLocalId() string {
+	if x != nil {
+		return x.LocalId
+	}
+	return ""
+}
+
+func (x *Device) GetDeviceName() string {
+	if x != nil {
+		return x.DeviceName
+	}
+	return ""
+}
+
+func (x *Device) GetTemperature() float64 {
+	if x != nil {
+		return x.Temperature
+	}
+	return 0
+}
+
+func (x *Device) GetDac() []float64 {
+	if x != nil {
+		return x.Dac
+	}
+	return nil
+}
+
+func (x *Device) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *Device) GetLed() string {
+	if x != nil {
+		return x.Led
+	}
+	return ""
+}
+
+func (x *Device) GetDeviceNumber() int32 {
+	if x != nil {
+		return x.DeviceNumber
+	}
+	return 0
+}
+
+type GetDeviceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetDeviceRequest) Reset() {
+	*x = GetDeviceRequest{}
+	mi := &file_u3_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetDeviceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDeviceRequest) ProtoMessage() {}
+
+func (x *GetDeviceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_u3_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDeviceRequest.ProtoReflect.Descriptor instead.
+func (*GetDeviceRequest) Descriptor() ([]byte, []int) {
+	return file_u3_proto_rawDescGZIP(), []int{4}
+}
+
+type PinMode struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Pin   string                 `protobuf:"bytes,1,opt,name=pin,proto3" json:"pin,omitempty"`
+	//Analog or Digital
+	Ad string `protobuf:"bytes,2,opt,name=ad,proto3" json:"ad,omitempty"`
+	//Input or Output, for digital pins
+	Io            string `protobuf:"bytes,3,opt,name=io,proto3" json:"io,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PinMode) Reset() {
+	*x = PinMode{}
+	mi := &file_u3_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PinMode) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PinMode) ProtoMessage() {}
+
+func (x *PinMode) ProtoReflect() protoreflect.Message {
+	mi := &file_u3_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PinMode.ProtoReflect.Descriptor instead.
+func (*PinMode) Descriptor() ([]byte, []int) {
+	return file_u3_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PinMode) GetPin() string {
+	if x != nil {
+		return x.Pin
+	}
+	return ""
+}
+
+func (x *PinMode) GetAd() string {
+	if x != nil {
+		return x.Ad
+	}
+	return ""
+}
+
+func (x *PinMode) GetIo() string {
+	if x != nil {
+		return x.Io
+	}
+	return ""
+}
+
+type ConfigureRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pins          []*PinMode             `protobuf:"bytes,1,rep,name=pins,proto3" json:"pins,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConfigureRequest) Reset() {
+	*x = ConfigureRequest{}
+	mi := &file_u3_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConfigureRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConfigureRequest) ProtoMessage() {}
+
+func (x *ConfigureRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_u3_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConfigureRequest.ProtoReflect.Descriptor instead.
+func (*ConfigureRequest) Descriptor() ([]byte, []int) {
+	return file_u3_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ConfigureRequest) GetPins() []*PinMode {
+	if x != nil {
+		return x.Pins
+	}
+	return nil
+}
+
+// PinSettings only changes the fields that are set.
+type PinSettings struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pin           string                 `protobuf:"bytes,1,opt,name=pin,proto3" json:"pin,omitempty"`
+	Name          *string                `protobuf:"bytes,2,opt,name=name,proto3,oneof" json:"name,omitempty"`
+	Unit          *string                `protobuf:"bytes,3,opt,name=unit,proto3,oneof" json:"unit,omitempty"`
+	Scale         *Scale                 `protobuf:"bytes,4,opt,name=scale,proto3" json:"scale,omitempty"`
+	NegChannel    *int32                 `protobuf:"varint,5,opt,name=neg_channel,json=negChannel,proto3,oneof" json:"neg_channel,omitempty"`
+	LongSettling  *bool                  `protobuf:"varint,6,opt,name=long_settling,json=longSettling,proto3,oneof" json:"long_settling,omitempty"`
+	QuickSample   *bool                  `protobuf:"varint,7,opt,name=quick_sample,json=quickSample,proto3,oneof" json:"quick_sample,omitempty"`
+	Samples       *int32                 `protobuf:"varint,8,opt,name=samples,proto3,oneof" json:"samples,omitempty"`
+	Filter        *string                `protobuf:"bytes,9,opt,name=filter,proto3,oneof" json:"filter,omitempty"`
+	EmaWeight     *float64               `protobuf:"fixed64,10,opt,name=ema_weight,json=emaWeight,proto3,oneof" json:"ema_weight,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PinSettings) Reset() {
+	*x = PinSettings{}
+	mi := &file_u3_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PinSettings) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PinSettings) ProtoMessage() {}
+
+func (x *PinSettings) ProtoReflect() protoreflect.Message {
+	mi := &file_u3_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PinSettings.ProtoReflect.Descriptor instead.
+func (*PinSettings) Descriptor() ([]byte, []int) {
+	return file_u3_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *PinSettings) GetPin() string {
+	if x != nil {
+		return x.Pin
+	}
+	return ""
+}
+
+func (x *PinSettings) GetName() string {
+	if x != nil && x.Name != nil {
+		return *x.Name
+	}
+	return ""
+}
+
+func (x *PinSettings) GetUnit() string {
+	if x != nil && x.Unit != nil {
+		return *x.Unit
+	}
+	return ""
+}
+
+func (x *PinSettings) GetScale() *Scale {
+	if x != nil {
+		return x.Scale
+	}
+	return nil
+}
+
+func (x *PinSettings) GetNegChannel() int32 {
+	if x != nil && x.NegChannel != nil {
+		return *x.NegChannel
+	}
+	return 0
+}
+
+func (x *PinSettings) GetLongSettling() bool {
+	if x != nil && x.LongSettling != nil {
+		return *x.LongSettling
+	}
+	return false
+}
+
+func (x *PinSettings) GetQuickSample() bool {
+	if x != nil && x.QuickSample != nil {
+		return *x.QuickSample
+	}
+	return false
+}
+
+func (x *PinSettings) GetSamples() int32 {
+	if x != nil && x.Samples != nil {
+		return *x.Samples
+	}
+	return 0
+}
+
+func (x *PinSettings) GetFilter() string {
+	if x != nil && x.Filter != nil {
+		return *x.Filter
+	}
+	return ""
+}
+
+func (x *PinSettings) GetEmaWeight() float64 {
+	if x != nil && x.EmaWeight != nil {
+		return *x.EmaWeight
+	}
+	return 0
+}
+
+type ReadRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	//pins to return, all the pins in use when empty
+	Pins          []string `protobuf:"bytes,1,rep,name=pins,proto3" json:"pins,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReadRequest) Reset() {
+	*x = ReadRequest{}
+	mi := &file_u3_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadRequest) ProtoMessage() {}
+
+func (x *ReadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_u3_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadRequest.ProtoReflect.Descriptor instead.
+func (*ReadRequest) Descriptor() ([]byte, []int) {
+	return file_u3_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ReadRequest) GetPins() []string {
+	if x != nil {
+		return x.Pins
+	}
+	return nil
+}
+
+type Readings struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	//of the acquisition, unix nanoseconds
+	Time          int64   `protobuf:"varint,1,opt,name=time,proto3" json:"time,omitempty"`
+	Pins          []*Pin  `protobuf:"bytes,2,rep,name=pins,proto3" json:"pins,omitempty"`
+	Temperature   float64 `protobuf:"fixed64,3,opt,name=temperature,proto3" json:"temperature,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Readings) Reset() {
+	*x = Readings{}
+	mi := &file_u3_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Readings) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Readings) ProtoMessage() {}
+
+func (x *Readings) ProtoReflect() protoreflect.Message {
+	mi := &file_u3_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Readings.ProtoReflect.Descriptor instead.
+func (*Readings) Descriptor() ([]byte, []int) {
+	return file_u3_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Readings) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+func (x *Readings) GetPins() []*Pin {
+	if x != nil {
+		return x.Pins
+	}
+	return nil
+}
+
+func (x *Readings) GetTemperature() float64 {
+	if x != nil {
+		return x.Temperature
+	}
+	return 0
+}
+
+type DigitalLevel struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pin           string                 `protobuf:"bytes,1,opt,name=pin,proto3" json:"pin,omitempty"`
+	Level         int32                  `protobuf:"varint,2,opt,name=level,proto3" json:"level,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DigitalLevel) Reset() {
+	*x = DigitalLevel{}
+	mi := &file_u3_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DigitalLevel) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DigitalLevel) ProtoMessage() {}
+
+func (x *DigitalLevel) ProtoReflect() protoreflect.Message {
+	mi := &file_u3_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DigitalLevel.ProtoReflect.Descriptor instead.
+func (*DigitalLevel) Descriptor() ([]byte, []int) {
+	return file_u3_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *DigitalLevel) GetPin() string {
+	if x != nil {
+		return x.Pin
+	}
+	return ""
+}
+
+func (x *DigitalLevel) GetLevel() int32 {
+	if x != nil {
+		return x.Level
+	}
+	return 0
+}
+
+type WriteDigitalRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Levels        []*DigitalLevel        `protobuf:"bytes,1,rep,name=levels,proto3" json:"levels,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WriteDigitalRequest) Reset() {
+	*x = WriteDigitalRequest{}
+	mi := &file_u3_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WriteDigitalRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WriteDigitalRequest) ProtoMessage() {}
+
+func (x *WriteDigitalRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_u3_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WriteDigitalRequest.ProtoReflect.Descriptor instead.
+func (*WriteDigitalRequest) Descriptor() ([]byte, []int) {
+	return file_u3_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *WriteDigitalRequest) GetLevels() []*DigitalLevel {
+	if x != nil {
+		return x.Levels
+	}
+	return nil
+}
+
+type WriteDACRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Dac           int32                  `protobuf:"varint,1,opt,name=dac,proto3" json:"dac,omitempty"`
+	Volts         float64                `protobuf:"fixed64,2,opt,name=volts,proto3" json:"volts,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WriteDACRequest) Reset() {
+	*x = WriteDACRequest{}
+	mi := &file_u3_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WriteDACRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WriteDACRequest) ProtoMessage() {}
+
+func (x *WriteDACRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_u3_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WriteDACRequest.ProtoReflect.Descriptor instead.
+func (*WriteDACRequest) Descriptor() ([]byte, []int) {
+	return file_u3_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *WriteDACRequest) GetDac() int32 {
+	if x != nil {
+		return x.Dac
+	}
+	return 0
+}
+
+func (x *WriteDACRequest) GetVolts() float64 {
+	if x != nil {
+		return x.Volts
+	}
+	return 0
+}
+
+type StreamRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Pins  []string               `protobuf:"bytes,1,rep,name=pins,proto3" json:"pins,omitempty"`
+	//milliseconds between samples, at least 10
+	IntervalMs int64 `protobuf:"varint,2,opt,name=interval_ms,json=intervalMs,proto3" json:"interval_ms,omitempty"`
+	//samples to send, 0 for no end
+	Count         int64 `protobuf:"varint,3,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamRequest) Reset() {
+	*x = StreamRequest{}
+	mi := &file_u3_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamRequest) ProtoMessage() {}
+
+func (x *StreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_u3_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamRequest.ProtoReflect.Descriptor instead.
+func (*StreamRequest) Descriptor() ([]byte, []int) {
+	return file_u3_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *StreamRequest) GetPins() []string {
+	if x != nil {
+		return x.Pins
+	}
+	return nil
+}
+
+func (x *StreamRequest) GetIntervalMs() int64 {
+	if x != nil {
+		return x.IntervalMs
+	}
+	return 0
+}
+
+func (x *StreamRequest) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+var File_u3_proto protoreflect.FileDescriptor
+
+const file_u3_proto_rawDesc = "" +
+	"\n" +
+	"\bu3.proto\x12\n" +
+	"labjack.u3\"\xd2\x02\n" +
+	"\x05Scale\x12\x12\n" +
+	"\x04kind\x18\x01 \x01(\tR\x04kind\x12\x14\n" +
+	"\x05slope\x18\x02 \x01(\x01R\x05slope\x12\x16\n" +
+	"\x06offset\x18\x03 \x01(\x01R\x06offset\x12\"\n" +
+	"\fcoefficients\x18\x04 \x03(\x01R\fcoefficients\x12,\n" +
+	"\x05table\x18\x05 \x03(\v2\x16.labjack.u3.TablePointR\x05table\x12\x12\n" +
+	"\x04beta\x18\x06 \x01(\x01R\x04beta\x12\x0e\n" +
+	"\x02r0\x18\a \x01(\x01R\x02r0\x12\x0e\n" +
+	"\x02t0\x18\b \x01(\x01R\x02t0\x12\x17\n" +
+	"\ar_fixed\x18\t \x01(\x01R\x06rFixed\x12\x16\n" +
+	"\x06supply\x18\n" +
+	" \x01(\x01R\x06supply\x12\x17\n" +
+	"\atc_type\x18\v \x01(\tR\x06tcType\x12#\n" +
+	"\rcold_junction\x18\f \x01(\x01R\fcoldJunction\x12\x12\n" +
+	"\x04gain\x18\r \x01(\x01R\x04gain\"8\n" +
+	"\n" +
+	"TablePoint\x12\x14\n" +
+	"\x05volts\x18\x01 \x01(\x01R\x05volts\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value\"\xdb\x04\n" +
+	"\x03Pin\x12\x0e\n" +
+	"\x02ad\x18\x01 \x01(\tR\x02ad\x12\x0e\n" +
+	"\x02io\x18\x02 \x01(\tR\x02io\x12\x1f\n" +
+	"\vanalog_read\x18\x03 \x01(\rR\n" +
+	"analogRead\x12%\n" +
+	"\x0eanalog_voltage\x18\x04 \x01(\tR\ranalogVoltage\x12!\n" +
+	"\fdigital_read\x18\x05 \x01(\x05R\vdigitalRead\x12#\n" +
+	"\rdigital_write\x18\x06 \x01(\x05R\fdigitalWrite\x12\x1f\n" +
+	"\vneg_channel\x18\a \x01(\x05R\n" +
+	"negChannel\x12#\n" +
+	"\rlong_settling\x18\b \x01(\bR\flongSettling\x12!\n" +
+	"\fquick_sample\x18\t \x01(\bR\vquickSample\x12\x18\n" +
+	"\asamples\x18\n" +
+	" \x01(\x05R\asamples\x12\x16\n" +
+	"\x06filter\x18\v \x01(\tR\x06filter\x12\x1d\n" +
+	"\n" +
+	"ema_weight\x18\f \x01(\x01R\temaWeight\x12#\n" +
+	"\rfiltered_read\x18\r \x01(\x01R\ffilteredRead\x12)\n" +
+	"\x10filtered_voltage\x18\x0e \x01(\tR\x0ffilteredVoltage\x12\x14\n" +
+	"\x05label\x18\x0f \x01(\tR\x05label\x12\x12\n" +
+	"\x04name\x18\x10 \x01(\tR\x04name\x12\x12\n" +
+	"\x04unit\x18\x11 \x01(\tR\x04unit\x12'\n" +
+	"\x05scale\x18\x12 \x01(\v2\x11.labjack.u3.ScaleR\x05scale\x12\x14\n" +
+	"\x05value\x18\x13 \x01(\x01R\x05value\x12\x1d\n" +
+	"\n" +
+	"value_text\x18\x14 \x01(\tR\tvalueText\"\xfc\x03\n" +
+	"\x06Device\x12!\n" +
+	"\x03fio\x18\x01 \x03(\v2\x0f.labjack.u3.PinR\x03fio\x12!\n" +
+	"\x03eio\x18\x02 \x03(\v2\x0f.labjack.u3.PinR\x03eio\x12!\n" +
+	"\x03cio\x18\x03 \x03(\v2\x0f.labjack.u3.PinR\x03cio\x12)\n" +
+	"\x10firmware_version\x18\x04 \x01(\tR\x0ffirmwareVersion\x12.\n" +
+	"\x13boot_loader_version\x18\x05 \x01(\tR\x11bootLoaderVersion\x12)\n" +
+	"\x10hardware_version\x18\x06 \x01(\tR\x0fhardwareVersion\x12#\n" +
+	"\rserial_number\x18\a \x01(\tR\fserialNumber\x12\x1d\n" +
+	"\n" +
+	"product_id\x18\b \x01(\tR\tproductId\x12\x19\n" +
+	"\blocal_id\x18\t \x01(\tR\alocalId\x12\x1f\n" +
+	"\vdevice_name\x18\n" +
+	" \x01(\tR\n" +
+	"deviceName\x12 \n" +
+	"\vtemperature\x18\v \x01(\x01R\vtemperature\x12\x10\n" +
+	"\x03dac\x18\f \x03(\x01R\x03dac\x12\x18\n" +
+	"\amessage\x18\r \x01(\tR\amessage\x12\x10\n" +
+	"\x03led\x18\x0e \x01(\tR\x03led\x12#\n" +
+	"\rdevice_number\x18\x0f \x01(\x05R\fdeviceNumber\"\x12\n" +
+	"\x10GetDeviceRequest\";\n" +
+	"\aPinMode\x12\x10\n" +
+	"\x03pin\x18\x01 \x01(\tR\x03pin\x12\x0e\n" +
+	"\x02ad\x18\x02 \x01(\tR\x02ad\x12\x0e\n" +
+	"\x02io\x18\x03 \x01(\tR\x02io\";\n" +
+	"\x10ConfigureRequest\x12'\n" +
+	"\x04pins\x18\x01 \x03(\v2\x13.labjack.u3.PinModeR\x04pins\"\xbd\x03\n" +
+	"\vPinSettings\x12\x10\n" +
+	"\x03pin\x18\x01 \x01(\tR\x03pin\x12\x17\n" +
+	"\x04name\x18\x02 \x01(\tH\x00R\x04name\x88\x01\x01\x12\x17\n" +
+	"\x04unit\x18\x03 \x01(\tH\x01R\x04unit\x88\x01\x01\x12'\n" +
+	"\x05scale\x18\x04 \x01(\v2\x11.labjack.u3.ScaleR\x05scale\x12$\n" +
+	"\vneg_channel\x18\x05 \x01(\x05H\x02R\n" +
+	"negChannel\x88\x01\x01\x12(\n" +
+	"\rlong_settling\x18\x06 \x01(\bH\x03R\flongSettling\x88\x01\x01\x12&\n" +
+	"\fquick_sample\x18\a \x01(\bH\x04R\vquickSample\x88\x01\x01\x12\x1d\n" +
+	"\asamples\x18\b \x01(\x05H\x05R\asamples\x88\x01\x01\x12\x1b\n" +
+	"\x06filter\x18\t \x01(\tH\x06R\x06filter\x88\x01\x01\x12\"\n" +
+	"\n" +
+	"ema_weight\x18\n" +
+	" \x01(\x01H\aR\temaWeight\x88\x01\x01B\a\n" +
+	"\x05_nameB\a\n" +
+	"\x05_unitB\x0e\n" +
+	"\f_neg_channelB\x10\n" +
+	"\x0e_long_settlingB\x0f\n" +
+	"\r_quick_sampleB\n" +
+	"\n" +
+	"\b_samplesB\t\n" +
+	"\a_filterB\r\n" +
+	"\v_ema_weight\"!\n" +
+	"\vReadRequest\x12\x12\n" +
+	"\x04pins\x18\x01 \x03(\tR\x04pins\"e\n" +
+	"\bReadings\x12\x12\n" +
+	"\x04time\x18\x01 \x01(\x03R\x04time\x12#\n" +
+	"\x04pins\x18\x02 \x03(\v2\x0f.labjack.u3.PinR\x04pins\x12 \n" +
+	"\vtemperature\x18\x03 \x01(\x01R\vtemperature\"6\n" +
+	"\fDigitalLevel\x12\x10\n" +
+	"\x03pin\x18\x01 \x01(\tR\x03pin\x12\x14\n" +
+	"\x05level\x18\x02 \x01(\x05R\x05level\"G\n" +
+	"\x13WriteDigitalRequest\x120\n" +
+	"\x06levels\x18\x01 \x03(\v2\x18.labjack.u3.DigitalLevelR\x06levels\"9\n" +
+	"\x0fWriteDACRequest\x12\x10\n" +
+	"\x03dac\x18\x01 \x01(\x05R\x03dac\x12\x14\n" +
+	"\x05volts\x18\x02 \x01(\x01R\x05volts\"Z\n" +
+	"\rStreamRequest\x12\x12\n" +
+	"\x04pins\x18\x01 \x03(\tR\x04pins\x12\x1f\n" +
+	"\vinterval_ms\x18\x02 \x01(\x03R\n" +
+	"intervalMs\x12\x14\n" +
+	"\x05count\x18\x03 \x01(\x03R\x05count2\xbd\x03\n" +
+	"\x02U3\x12=\n" +
+	"\tGetDevice\x12\x1c.labjack.u3.GetDeviceRequest\x1a\x12.labjack.u3.Device\x12=\n" +
+	"\tConfigure\x12\x1c.labjack.u3.ConfigureRequest\x1a\x12.labjack.u3.Device\x12:\n" +
+	"\x0eSetPinSettings\x12\x17.labjack.u3.PinSettings\x1a\x0f.labjack.u3.Pin\x125\n" +
+	"\x04Read\x12\x17.labjack.u3.ReadRequest\x1a\x14.labjack.u3.Readings\x12E\n" +
+	"\fWriteDigital\x12\x1f.labjack.u3.WriteDigitalRequest\x1a\x14.labjack.u3.Readings\x12;\n" +
+	"\bWriteDAC\x12\x1b.labjack.u3.WriteDACRequest\x1a\x12.labjack.u3.Device\x12B\n" +
+	"\rStreamSamples\x12\x19.labjack.u3.StreamRequest\x1a\x14.labjack.u3.Readings0\x01B%Z#github.com/Saied74/labjack/pkg/u3pbb\x06proto3"
+
+var (
+	file_u3_proto_rawDescOnce sync.Once
+	file_u3_proto_rawDescData []byte
+)
+
+func file_u3_proto_rawDescGZIP() []byte {
+	file_u3_proto_rawDescOnce.Do(func() {
+		file_u3_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_u3_proto_rawDesc), len(file_u3_proto_rawDesc)))
+	})
+	return file_u3_proto_rawDescData
+}
+
+var file_u3_proto_msgTypes = make([]protoimpl.MessageInfo, 14)
+var file_u3_proto_goTypes = []any{
+	(*Scale)(nil),               // 0: labjack.u3.Scale
+	(*TablePoint)(nil),          // 1: labjack.u3.TablePoint
+	(*Pin)(nil),                 // 2: labjack.u3.Pin
+	(*Device)(nil),              // 3: labjack.u3.Device
+	(*GetDeviceRequest)(nil),    // 4: labjack.u3.GetDeviceRequest
+	(*PinMode)(nil),             // 5: labjack.u3.PinMode
+	(*ConfigureRequest)(nil),    // 6: labjack.u3.ConfigureRequest
+	(*PinSettings)(nil),         // 7: labjack.u3.PinSettings
+	(*ReadRequest)(nil),         // 8: labjack.u3.ReadRequest
+	(*Readings)(nil),            // 9: labjack.u3.Readings
+	(*DigitalLevel)(nil),        // 10: labjack.u3.DigitalLevel
+	(*WriteDigitalRequest)(nil), // 11: labjack.u3.WriteDigitalRequest
+	(*WriteDACRequest)(nil),     // 12: labjack.u3.WriteDACRequest
+	(*StreamRequest)(nil),       // 13: labjack.u3.StreamRequest
+}
+var file_u3_proto_depIdxs = []int32{
+	1,  // 0: labjack.u3.Scale.table:type_name -> labjack.u3.TablePoint
+	0,  // 1: labjack.u3.Pin.scale:type_name -> labjack.u3.Scale
+	2,  // 2: labjack.u3.Device.fio:type_name -> labjack.u3.Pin
+	2,  // 3: labjack.u3.Device.eio:type_name -> labjack.u3.Pin
+	2,  // 4: labjack.u3.Device.cio:type_name -> labjack.u3.Pin
+	5,  // 5: labjack.u3.ConfigureRequest.pins:type_name -> labjack.u3.PinMode
+	0,  // 6: labjack.u3.PinSettings.scale:type_name -> labjack.u3.Scale
+	2,  // 7: labjack.u3.Readings.pins:type_name -> labjack.u3.Pin
+	10, // 8: labjack.u3.WriteDigitalRequest.levels:type_name -> labjack.u3.DigitalLevel
+	4,  // 9: labjack.u3.U3.GetDevice:input_type -> labjack.u3.GetDeviceRequest
+	6,  // 10: labjack.u3.U3.Configure:input_type -> labjack.u3.ConfigureRequest
+	7,  // 11: labjack.u3.U3.SetPinSettings:input_type -> labjack.u3.PinSettings
+	8,  // 12: labjack.u3.U3.Read:input_type -> labjack.u3.ReadRequest
+	11, // 13: labjack.u3.U3.WriteDigital:input_type -> labjack.u3.WriteDigitalRequest
+	12, // 14: labjack.u3.U3.WriteDAC:input_type -> labjack.u3.WriteDACRequest
+	13, // 15: labjack.u3.U3.StreamSamples:input_type -> labjack.u3.StreamRequest
+	3,  // 16: labjack.u3.U3.GetDevice:output_type -> labjack.u3.Device
+	3,  // 17: labjack.u3.U3.Configure:output_type -> labjack.u3.Device
+	2,  // 18: labjack.u3.U3.SetPinSettings:output_type -> labjack.u3.Pin
+	9,  // 19: labjack.u3.U3.Read:output_type -> labjack.u3.Readings
+	9,  // 20: labjack.u3.U3.WriteDigital:output_type -> labjack.u3.Readings
+	3,  // 21: labjack.u3.U3.WriteDAC:output_type -> labjack.u3.Device
+	9,  // 22: labjack.u3.U3.StreamSamples:output_type -> labjack.u3.Readings
+	16, // [16:23] is the sub-list for method output_type
+	9,  // [9:16] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_u3_proto_init() }
+func file_u3_proto_init() {
+	if File_u3_proto != nil {
+		return
+	}
+	file_u3_proto_msgTypes[7].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_u3_proto_rawDesc), len(file_u3_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   14,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_u3_proto_goTypes,
+		DependencyIndexes: file_u3_proto_depIdxs,
+		MessageInfos:      file_u3_proto_msgTypes,
+	}.Build()
+	File_u3_proto = out.File
+	file_u3_proto_goTypes = nil
+	file_u3_proto_depIdxs = nil
+}
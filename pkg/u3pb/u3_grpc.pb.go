@@ -0,0 +1,383 @@
+//u3.proto is the gRPC api of the web program (see grpc.go in cmd/web), for
+//driving the U3 from other services.  The messages mirror the U3 and Pin types
+//of cmd/web field for field, with the same strings for the settings ("Analog"
+//or "Digital", "Input" or "Output", the filter and scale kinds).  Pins are
+//named like on the device, FIO4 or EIO0 for example.
+//
+//After changing it, generate u3.pb.go and u3_grpc.pb.go again from this
+//directory with
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	  --go-grpc_out=. --go-grpc_opt=paths=source_relative u3.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: u3.proto
+
+package u3pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	U3_GetDevice_FullMethodName      = "/labjack.u3.U3/GetDevice"
+	U3_Configure_FullMethodName      = "/labjack.u3.U3/Configure"
+	U3_SetPinSettings_FullMethodName = "/labjack.u3.U3/SetPinSettings"
+	U3_Read_FullMethodName           = "/labjack.u3.U3/Read"
+	U3_WriteDigital_FullMethodName   = "/labjack.u3.U3/WriteDigital"
+	U3_WriteDAC_FullMethodName       = "/labjack.u3.U3/WriteDAC"
+	U3_StreamSamples_FullMethodName  = "/labjack.u3.U3/StreamSamples"
+)
+
+// U3Client is the client API for U3 service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type U3Client interface {
+	//GetDevice returns the model as it is, it does not talk to the device.
+	GetDevice(ctx context.Context, in *GetDeviceRequest, opts ...grpc.CallOption) (*Device, error)
+	//Configure sets pins analog or digital and the direction of digital pins,
+	//and writes the configuration to the device.
+	Configure(ctx context.Context, in *ConfigureRequest, opts ...grpc.CallOption) (*Device, error)
+	//SetPinSettings changes the read settings of a pin, like /api/pin.
+	SetPinSettings(ctx context.Context, in *PinSettings, opts ...grpc.CallOption) (*Pin, error)
+	//Read acquires and returns the pins asked for.
+	Read(ctx context.Context, in *ReadRequest, opts ...grpc.CallOption) (*Readings, error)
+	//WriteDigital sets digital outputs.
+	WriteDigital(ctx context.Context, in *WriteDigitalRequest, opts ...grpc.CallOption) (*Readings, error)
+	//WriteDAC sets DAC0 or DAC1.
+	WriteDAC(ctx context.Context, in *WriteDACRequest, opts ...grpc.CallOption) (*Device, error)
+	//StreamSamples sends Readings every interval until the client goes away or
+	//count have been sent.
+	StreamSamples(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Readings], error)
+}
+
+type u3Client struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewU3Client(cc grpc.ClientConnInterface) U3Client {
+	return &u3Client{cc}
+}
+
+func (c *u3Client) GetDevice(ctx context.Context, in *GetDeviceRequest, opts ...grpc.CallOption) (*Device, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Device)
+	err := c.cc.Invoke(ctx, U3_GetDevice_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *u3Client) Configure(ctx context.Context, in *ConfigureRequest, opts ...grpc.CallOption) (*Device, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Device)
+	err := c.cc.Invoke(ctx, U3_Configure_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *u3Client) SetPinSettings(ctx context.Context, in *PinSettings, opts ...grpc.CallOption) (*Pin, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Pin)
+	err := c.cc.Invoke(ctx, U3_SetPinSettings_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *u3Client) Read(ctx context.Context, in *ReadRequest, opts ...grpc.CallOption) (*Readings, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Readings)
+	err := c.cc.Invoke(ctx, U3_Read_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *u3Client) WriteDigital(ctx context.Context, in *WriteDigitalRequest, opts ...grpc.CallOption) (*Readings, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Readings)
+	err := c.cc.Invoke(ctx, U3_WriteDigital_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *u3Client) WriteDAC(ctx context.Context, in *WriteDACRequest, opts ...grpc.CallOption) (*Device, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Device)
+	err := c.cc.Invoke(ctx, U3_WriteDAC_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *u3Client) StreamSamples(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Readings], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &U3_ServiceDesc.Streams[0], U3_StreamSamples_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamRequest, Readings]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type U3_StreamSamplesClient = grpc.ServerStreamingClient[Readings]
+
+// U3Server is the server API for U3 service.
+// All implementations must embed UnimplementedU3Server
+// for forward compatibility.
+type U3Server interface {
+	//GetDevice returns the model as it is, it does not talk to the device.
+	GetDevice(context.Context, *GetDeviceRequest) (*Device, error)
+	//Configure sets pins analog or digital and the direction of digital pins,
+	//and writes the configuration to the device.
+	Configure(context.Context, *ConfigureRequest) (*Device, error)
+	//SetPinSettings changes the read settings of a pin, like /api/pin.
+	SetPinSettings(context.Context, *PinSettings) (*Pin, error)
+	//Read acquires and returns the pins asked for.
+	Read(context.Context, *ReadRequest) (*Readings, error)
+	//WriteDigital sets digital outputs.
+	WriteDigital(context.Context, *WriteDigitalRequest) (*Readings, error)
+	//WriteDAC sets DAC0 or DAC1.
+	WriteDAC(context.Context, *WriteDACRequest) (*Device, error)
+	//StreamSamples sends Readings every interval until the client goes away or
+	//count have been sent.
+	StreamSamples(*StreamRequest, grpc.ServerStreamingServer[Readings]) error
+	mustEmbedUnimplementedU3Server()
+}
+
+// UnimplementedU3Server must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedU3Server struct{}
+
+func (UnimplementedU3Server) GetDevice(context.Context, *GetDeviceRequest) (*Device, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDevice not implemented")
+}
+func (UnimplementedU3Server) Configure(context.Context, *ConfigureRequest) (*Device, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Configure not implemented")
+}
+func (UnimplementedU3Server) SetPinSettings(context.Context, *PinSettings) (*Pin, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetPinSettings not implemented")
+}
+func (UnimplementedU3Server) Read(context.Context, *ReadRequest) (*Readings, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Read not implemented")
+}
+func (UnimplementedU3Server) WriteDigital(context.Context, *WriteDigitalRequest) (*Readings, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WriteDigital not implemented")
+}
+func (UnimplementedU3Server) WriteDAC(context.Context, *WriteDACRequest) (*Device, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WriteDAC not implemented")
+}
+func (UnimplementedU3Server) StreamSamples(*StreamRequest, grpc.ServerStreamingServer[Readings]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamSamples not implemented")
+}
+func (UnimplementedU3Server) mustEmbedUnimplementedU3Server() {}
+func (UnimplementedU3Server) testEmbeddedByValue()            {}
+
+// UnsafeU3Server may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to U3Server will
+// result in compilation errors.
+type UnsafeU3Server interface {
+	mustEmbedUnimplementedU3Server()
+}
+
+func RegisterU3Server(s grpc.ServiceRegistrar, srv U3Server) {
+	// If the following call pancis, it indicates UnimplementedU3Server was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&U3_ServiceDesc, srv)
+}
+
+func _U3_GetDevice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDeviceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(U3Server).GetDevice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: U3_GetDevice_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(U3Server).GetDevice(ctx, req.(*GetDeviceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _U3_Configure_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConfigureRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(U3Server).Configure(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: U3_Configure_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(U3Server).Configure(ctx, req.(*ConfigureRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _U3_SetPinSettings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PinSettings)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(U3Server).SetPinSettings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: U3_SetPinSettings_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(U3Server).SetPinSettings(ctx, req.(*PinSettings))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _U3_Read_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(U3Server).Read(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: U3_Read_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(U3Server).Read(ctx, req.(*ReadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _U3_WriteDigital_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WriteDigitalRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(U3Server).WriteDigital(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: U3_WriteDigital_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(U3Server).WriteDigital(ctx, req.(*WriteDigitalRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _U3_WriteDAC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WriteDACRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(U3Server).WriteDAC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: U3_WriteDAC_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(U3Server).WriteDAC(ctx, req.(*WriteDACRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _U3_StreamSamples_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(U3Server).StreamSamples(m, &grpc.GenericServerStream[StreamRequest, Readings]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type U3_StreamSamplesServer = grpc.ServerStreamingServer[Readings]
+
+// U3_ServiceDesc is the grpc.ServiceDesc for U3 service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var U3_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "labjack.u3.U3",
+	HandlerType: (*U3Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetDevice",
+			Handler:    _U3_GetDevice_Handler,
+		},
+		{
+			MethodName: "Configure",
+			Handler:    _U3_Configure_Handler,
+		},
+		{
+			MethodName: "SetPinSettings",
+			Handler:    _U3_SetPinSettings_Handler,
+		},
+		{
+			MethodName: "Read",
+			Handler:    _U3_Read_Handler,
+		},
+		{
+			MethodName: "WriteDigital",
+			Handler:    _U3_WriteDigital_Handler,
+		},
+		{
+			MethodName: "WriteDAC",
+			Handler:    _U3_WriteDAC_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamSamples",
+			Handler:       _U3_StreamSamples_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "u3.proto",
+}
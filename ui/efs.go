@@ -0,0 +1,9 @@
+package ui
+
+import "embed"
+
+//Files holds the templates and static files so the web program does not depend
+//on the directory it is run from.
+
+//go:embed html static
+var Files embed.FS